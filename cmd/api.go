@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"goland.local/binance-portfolio/pkg"
+)
+
+// apiPrefix is where the versioned JSON API lives. Its answers are all
+// pkg.APIResp envelopes, errors included, and /openapi.json describes it.
+const apiPrefix = "/api/v1"
+
+// apiRoute is an /api/v1 route: what the OpenAPI document says about it and
+// its handler.
+type apiRoute struct {
+	pkg.APIRoute
+	handler echo.HandlerFunc
+}
+
+var (
+	accountParam = pkg.APIParam{Name: "account", In: "query", Description: "account name, name/sub-account email, or all (the default) for every account merged by asset"}
+	assetParam   = pkg.APIParam{Name: "asset", In: "query", Description: "comma separated assets or pairs, e.g. BTC,ETHUSDT"}
+	fromParam    = pkg.APIParam{Name: "from", In: "query", Description: "first day, YYYY-MM-DD UTC"}
+	toParam      = pkg.APIParam{Name: "to", In: "query", Description: "last day, YYYY-MM-DD UTC, inclusive"}
+	// orders are signed with the account's own key, which sub-accounts don't have
+	ordersAccountParam = pkg.APIParam{Name: "account", In: "query", Description: "account name, or all (the default) for the orders of every account"}
+)
+
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		{pkg.APIRoute{Method: http.MethodGet, Path: "/accounts", Summary: "Value and PNL of every account and sub-account",
+			Data: []pkg.AccountHoldings(nil)}, apiAccounts},
+		{pkg.APIRoute{Method: http.MethodGet, Path: "/portfolio", Summary: "Holdings with their trade stats and PNL",
+			Params: []pkg.APIParam{accountParam, assetParam}, Data: []*pkg.PortfolioBalance(nil), Paged: true}, apiPortfolio},
+		{pkg.APIRoute{Method: http.MethodGet, Path: "/wallet", Summary: "Balances valued at the current prices",
+			Params: []pkg.APIParam{accountParam, assetParam}, Data: []*pkg.WalletBalance(nil), Paged: true}, apiWallet},
+		{pkg.APIRoute{Method: http.MethodGet, Path: "/orders", Summary: "Orders of a pair, oldest first, from Binance",
+			Params: []pkg.APIParam{
+				{Name: "symbol", In: "query", Required: true, Description: "pair, e.g. BTCUSDT"},
+				{Name: "status", In: "query", Description: "comma separated statuses, e.g. FILLED,CANCELED"},
+				ordersAccountParam, fromParam, toParam,
+			}, Data: []pkg.Order(nil), Paged: true}, apiOrders},
+		{pkg.APIRoute{Method: http.MethodGet, Path: "/trades", Summary: "Stored trades, API and imported, oldest first",
+			Params: []pkg.APIParam{accountParam, assetParam, fromParam, toParam}, Data: []pkg.Trade(nil), Paged: true}, apiTrades},
+		{pkg.APIRoute{Method: http.MethodGet, Path: "/ratelimit", Summary: "Use of the Binance rate limits",
+			Data: pkg.RateLimitStatus{}}, apiRateLimit},
+	}
+}
+
+// registerAPI adds the /api/v1 routes and their OpenAPI document to e, and
+// answers the errors of /api/v1 paths, unknown routes included, with the
+// envelope too.
+func registerAPI(e *echo.Echo) {
+	api := e.Group(apiPrefix)
+	var described []pkg.APIRoute
+	for _, route := range apiRoutes() {
+		api.Add(route.Method, route.Path, route.handler)
+		described = append(described, route.APIRoute)
+	}
+	doc := pkg.OpenAPI("binance-portfolio", "1.0.0", apiPrefix, described)
+	api.GET("/openapi.json", func(c echo.Context) error {
+		return c.JSON(200, doc)
+	})
+
+	defaultErrorHandler := e.HTTPErrorHandler
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		if !strings.HasPrefix(c.Request().URL.Path, apiPrefix+"/") || c.Response().Committed {
+			defaultErrorHandler(err, c)
+			return
+		}
+		code, message := pkg.ErrorInternal, err.Error()
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			message = fmt.Sprint(httpErr.Message)
+			switch httpErr.Code {
+			case http.StatusNotFound:
+				code = pkg.ErrorNotFound
+			case http.StatusMethodNotAllowed:
+				code = pkg.ErrorMethodNotAllowed
+			case http.StatusBadRequest:
+				code = pkg.ErrorInvalidParameter
+			case http.StatusUnauthorized:
+				code = pkg.ErrorUnauthorized
+			case http.StatusForbidden:
+				code = pkg.ErrorForbidden
+			}
+		}
+		if err := apiError(c, code, message); err != nil {
+			log.Error("Error answering ", c.Request().URL.Path, " - ", err)
+		}
+	}
+}
+
+func apiError(c echo.Context, code pkg.ErrorCode, message string) error {
+	return c.JSON(code.Status(), pkg.APIResp[interface{}]{Error: &pkg.APIError{Code: code, Message: message}})
+}
+
+// apiUpstreamError answers with the error of a Binance or CCData call.
+func apiUpstreamError(c echo.Context, err error) error {
+	log.Errorf("%s: %v", c.Request().URL.Path, err)
+	return apiError(c, pkg.UpstreamErrorCode(err), err.Error())
+}
+
+// apiListParams reads the asset, from and to filters and the page of a list.
+func apiListParams(c echo.Context) (pkg.ExportFilter, pkg.Page, error) {
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		return filter, pkg.Page{}, err
+	}
+	page, err := pkg.ParsePage(c.QueryParam("limit"), c.QueryParam("offset"))
+	return filter, page, err
+}
+
+// apiHoldings returns the holdings of the account param, or why there are
+// none.
+func apiHoldings(c echo.Context) (pkg.AccountHoldings, *pkg.APIError) {
+	holdings, err := pkg.SelectHoldings(loadAccountsHoldings(c.Request().Context(), config.Currency), c.QueryParam("account"))
+	if err != nil {
+		return holdings, &pkg.APIError{Code: pkg.ErrorInvalidParameter, Message: err.Error()}
+	}
+	if holdings.Err != "" {
+		return holdings, &pkg.APIError{Code: pkg.ErrorUpstream, Message: holdings.Err}
+	}
+	return holdings, nil
+}
+
+func apiAccounts(c echo.Context) error {
+	holdings := loadAccountsHoldings(c.Request().Context(), config.Currency)
+	all := pkg.ConsolidateHoldings(holdings)
+	return c.JSON(200, pkg.APIResp[[]pkg.AccountHoldings]{Data: holdings, Warnings: all.Warnings, Freshness: all.Freshness})
+}
+
+func apiPortfolio(c echo.Context) error {
+	filter, page, err := apiListParams(c)
+	if err != nil {
+		return apiError(c, pkg.ErrorInvalidParameter, err.Error())
+	}
+	holdings, apiErr := apiHoldings(c)
+	if apiErr != nil {
+		return apiError(c, apiErr.Code, apiErr.Message)
+	}
+	balances, paged := pkg.Paginate(pkg.FilterBalances(holdings.Balances, filter), page)
+	return c.JSON(200, pkg.APIResp[[]*pkg.PortfolioBalance]{Data: balances, Page: paged, Warnings: holdings.Warnings, Freshness: holdings.Freshness})
+}
+
+func apiWallet(c echo.Context) error {
+	filter, page, err := apiListParams(c)
+	if err != nil {
+		return apiError(c, pkg.ErrorInvalidParameter, err.Error())
+	}
+	holdings, apiErr := apiHoldings(c)
+	if apiErr != nil {
+		return apiError(c, apiErr.Code, apiErr.Message)
+	}
+	balances, paged := pkg.Paginate(pkg.FilterBalances(holdings.Wallet, filter), page)
+	return c.JSON(200, pkg.APIResp[[]*pkg.WalletBalance]{Data: balances, Page: paged, Warnings: holdings.Warnings, Freshness: holdings.Freshness})
+}
+
+func apiOrders(c echo.Context) error {
+	symbol := strings.ToUpper(strings.TrimSpace(c.QueryParam("symbol")))
+	if symbol == "" {
+		return apiError(c, pkg.ErrorInvalidParameter, "symbol is required")
+	}
+	filter, page, err := apiListParams(c)
+	if err != nil {
+		return apiError(c, pkg.ErrorInvalidParameter, err.Error())
+	}
+	accounts, err := pkg.SelectAccounts(c.QueryParam("account"))
+	if err != nil {
+		return apiError(c, pkg.ErrorInvalidParameter, err.Error())
+	}
+	orders, err := pkg.GetAccountsOrders(c.Request().Context(), accounts, symbol, strconv.Itoa(config.OrderFetchLimit))
+	if err != nil {
+		return apiUpstreamError(c, err)
+	}
+	if status := c.QueryParam("status"); status != "" {
+		orders = pkg.FilterOrdersByStatus(orders, strings.Split(strings.ToUpper(status), ",")...)
+	}
+	orders, paged := pkg.Paginate(pkg.FilterOrders(orders, filter), page)
+	return c.JSON(200, pkg.APIResp[[]pkg.Order]{Data: orders, Page: paged})
+}
+
+// apiTrades answers from the trade stores, once the holdings have synced
+// the trades of every held pair.
+func apiTrades(c echo.Context) error {
+	filter, page, err := apiListParams(c)
+	if err != nil {
+		return apiError(c, pkg.ErrorInvalidParameter, err.Error())
+	}
+	holdings, apiErr := apiHoldings(c)
+	if apiErr != nil {
+		return apiError(c, apiErr.Code, apiErr.Message)
+	}
+	trades, paged := pkg.Paginate(pkg.FilterTrades(accountAssetTrades(c.QueryParam("account")), filter), page)
+	return c.JSON(200, pkg.APIResp[[]pkg.Trade]{Data: trades, Page: paged, Warnings: holdings.Warnings, Freshness: holdings.Freshness})
+}
+
+func apiRateLimit(c echo.Context) error {
+	return c.JSON(200, pkg.APIResp[pkg.RateLimitStatus]{Data: pkg.GetRateLimitStatus()})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"goland.local/binance-portfolio/pkg"
+)
+
+const (
+	sessionCookie = "portfolio_session"
+	// csrfCookie is readable by the dashboard's scripts, which send it back
+	// in csrfHeader; a cross-site page can't read it
+	csrfCookie = "portfolio_csrf"
+	csrfHeader = "X-CSRF-Token"
+	sessionKey = "session"
+)
+
+type LoginPage struct {
+	Next  string
+	Error string
+}
+
+// isLoopback reports whether host only accepts connections from this
+// machine. An empty host listens on every interface.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// registerAuth requires a dashboard session or an API token for every route
+// but the login page, and adds the login and logout routes. With
+// openWhenEmpty, requests go through while there are no users or tokens.
+func registerAuth(e *echo.Echo, users *pkg.UserStore, openWhenEmpty bool) {
+	sessions := pkg.NewSessions(config.SessionLifetime)
+	e.Use(authMiddleware(users, sessions, openWhenEmpty))
+
+	e.GET("/login", func(c echo.Context) error {
+		if cookie, err := c.Cookie(sessionCookie); err == nil {
+			if _, ok := sessions.Get(cookie.Value); ok {
+				return c.Redirect(http.StatusSeeOther, safeNext(c.QueryParam("next")))
+			}
+		}
+		return c.Render(200, "login", LoginPage{Next: safeNext(c.QueryParam("next"))})
+	})
+
+	e.POST("/login", func(c echo.Context) error {
+		name, next := strings.TrimSpace(c.FormValue("name")), safeNext(c.FormValue("next"))
+		if !users.Authenticate(name, c.FormValue("password")) {
+			log.Warnf("Failed login as %q from %s", name, c.RealIP())
+			return c.Render(401, "login", LoginPage{Next: next, Error: "Wrong user name or password"})
+		}
+		session, err := sessions.Create(name)
+		if err != nil {
+			return err
+		}
+		secure := c.Scheme() == "https"
+		c.SetCookie(&http.Cookie{Name: sessionCookie, Value: session.ID, Path: "/", Expires: session.Expires, HttpOnly: true, Secure: secure, SameSite: http.SameSiteLaxMode})
+		c.SetCookie(&http.Cookie{Name: csrfCookie, Value: session.CSRFToken, Path: "/", Expires: session.Expires, Secure: secure, SameSite: http.SameSiteStrictMode})
+		log.Infof("%s signed in from %s", name, c.RealIP())
+		return c.Redirect(http.StatusSeeOther, next)
+	})
+
+	e.POST("/logout", func(c echo.Context) error {
+		if session, ok := c.Get(sessionKey).(pkg.Session); ok {
+			sessions.Delete(session.ID)
+		}
+		for _, name := range []string{sessionCookie, csrfCookie} {
+			c.SetCookie(&http.Cookie{Name: name, Path: "/", MaxAge: -1})
+		}
+		return c.Redirect(http.StatusSeeOther, "/login")
+	})
+}
+
+// safeNext keeps the page to go back to after login on this site.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requiredScope is what an API token needs for r: trade to change
+// something, read otherwise. GraphQL only reads, whatever the method.
+func requiredScope(r *http.Request) string {
+	if safeMethod(r.Method) || r.URL.Path == "/graphql" {
+		return pkg.ScopeRead
+	}
+	return pkg.ScopeTrade
+}
+
+// sameOrigin reports whether r comes from the dashboard itself, or from a
+// client that isn't a browser. Browsers send Sec-Fetch-Site, or at least
+// Origin, with a cross-site form post.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get(echo.HeaderOrigin)
+	if origin == "" {
+		return true
+	}
+	url, err := url.Parse(origin)
+	return err == nil && url.Host == r.Host
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get(echo.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authMiddleware lets through requests with an API token that has the
+// scope they need, and requests of a signed-in user, which must send the
+// session's CSRF token unless they only read. Pages redirect to the login
+// page otherwise. While users is empty and openWhenEmpty, every request goes
+// through but cross-site ones that change something.
+func authMiddleware(users *pkg.UserStore, sessions *pkg.Sessions, openWhenEmpty bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			if r.URL.Path == "/login" || strings.HasPrefix(r.URL.Path, "/src/") {
+				return next(c)
+			}
+			if openWhenEmpty && users.Empty() {
+				if !safeMethod(r.Method) && !sameOrigin(r) {
+					return authError(c, pkg.ErrorForbidden, "cross-site request refused")
+				}
+				return next(c)
+			}
+			if token, ok := bearerToken(r); ok {
+				stored, ok := users.LookupToken(token)
+				if !ok {
+					return authError(c, pkg.ErrorUnauthorized, "invalid API token")
+				}
+				if scope := requiredScope(r); !stored.HasScope(scope) {
+					return authError(c, pkg.ErrorForbidden, fmt.Sprintf("the API token %s doesn't have the %s scope", stored.Name, scope))
+				}
+				return next(c)
+			}
+			if cookie, err := c.Cookie(sessionCookie); err == nil {
+				// a removed user is signed out at once
+				if session, ok := sessions.Get(cookie.Value); ok && users.HasUser(session.User) {
+					csrfToken := r.Header.Get(csrfHeader)
+					if !safeMethod(r.Method) && subtle.ConstantTimeCompare([]byte(csrfToken), []byte(session.CSRFToken)) != 1 {
+						return authError(c, pkg.ErrorForbidden, "missing or invalid CSRF token, reload the page")
+					}
+					c.Set(sessionKey, session)
+					return next(c)
+				}
+			}
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get(echo.HeaderAccept), echo.MIMETextHTML) {
+				return c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(r.URL.RequestURI()))
+			}
+			return authError(c, pkg.ErrorUnauthorized, "sign in or send an API token")
+		}
+	}
+}
+
+// authError answers in the format of the route: the envelope for /api/v1,
+// GraphQL errors for /graphql and RESTResp for the dashboard's routes.
+func authError(c echo.Context, code pkg.ErrorCode, message string) error {
+	if code == pkg.ErrorUnauthorized {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="binance-portfolio"`)
+	}
+	switch path := c.Request().URL.Path; {
+	case strings.HasPrefix(path, apiPrefix+"/"):
+		return apiError(c, code, message)
+	case path == "/graphql":
+		return gqlRequestError(c, code, errors.New(message))
+	}
+	return c.JSON(code.Status(), pkg.RESTResp[interface{}]{Err: message})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"goland.local/binance-portfolio/pkg"
+)
+
+// newAuthServer serves GET / and POST /order behind authMiddleware, with
+// user alice signed in and a read-only token.
+func newAuthServer(t *testing.T) (e *echo.Echo, users *pkg.UserStore, session pkg.Session, readToken string) {
+	t.Helper()
+	users, err := pkg.OpenUserStore(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.SetPassword("alice", "correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+	if readToken, err = users.AddToken("reader", []string{pkg.ScopeRead}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Save(); err != nil {
+		t.Fatal(err)
+	}
+	sessions := pkg.NewSessions(time.Hour)
+	if session, err = sessions.Create("alice"); err != nil {
+		t.Fatal(err)
+	}
+	e = echo.New()
+	e.Use(authMiddleware(users, sessions, true))
+	ok := func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	}
+	e.GET("/", ok)
+	e.POST("/order", ok)
+	return e, users, session, readToken
+}
+
+func serveRequest(e *echo.Echo, method string, path string, header http.Header, cookies ...*http.Cookie) int {
+	req := httptest.NewRequest(method, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAuthMiddlewareCSRF(t *testing.T) {
+	e, _, session, _ := newAuthServer(t)
+	cookie := &http.Cookie{Name: sessionCookie, Value: session.ID}
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{"GET needs no CSRF token", http.MethodGet, "", http.StatusOK},
+		{"POST without a CSRF token", http.MethodPost, "", http.StatusForbidden},
+		{"POST with a wrong CSRF token", http.MethodPost, "wrong", http.StatusForbidden},
+		{"POST with the session's CSRF token", http.MethodPost, session.CSRFToken, http.StatusOK},
+	}
+	for _, test := range tests {
+		path := "/"
+		if test.method == http.MethodPost {
+			path = "/order"
+		}
+		header := http.Header{}
+		if test.token != "" {
+			header.Set(csrfHeader, test.token)
+		}
+		if got := serveRequest(e, test.method, path, header, cookie); got != test.want {
+			t.Errorf("%s: status %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAuthMiddlewareTokenScopes(t *testing.T) {
+	e, _, _, readToken := newAuthServer(t)
+	bearer := func(token string) http.Header {
+		return http.Header{echo.HeaderAuthorization: {"Bearer " + token}}
+	}
+	if got := serveRequest(e, http.MethodGet, "/", bearer(readToken)); got != http.StatusOK {
+		t.Errorf("GET / with a read token: status %d, want 200", got)
+	}
+	if got := serveRequest(e, http.MethodPost, "/order", bearer(readToken)); got != http.StatusForbidden {
+		t.Errorf("POST /order with a read token: status %d, want 403", got)
+	}
+	if got := serveRequest(e, http.MethodGet, "/", bearer("bp_unknown")); got != http.StatusUnauthorized {
+		t.Errorf("GET / with an unknown token: status %d, want 401", got)
+	}
+}
+
+func TestAuthMiddlewareRemovedUser(t *testing.T) {
+	e, users, session, _ := newAuthServer(t)
+	cookie := &http.Cookie{Name: sessionCookie, Value: session.ID}
+	if got := serveRequest(e, http.MethodGet, "/", nil, cookie); got != http.StatusOK {
+		t.Fatalf("GET / signed in: status %d, want 200", got)
+	}
+	// users remove runs in another process, which only shares the file
+	other, err := pkg.OpenUserStore(users.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.RemoveUser("alice")
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(users.Path(), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := serveRequest(e, http.MethodGet, "/", nil, cookie); got != http.StatusUnauthorized {
+		t.Errorf("GET / as a removed user: status %d, want 401", got)
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	tests := map[string]string{
+		"":               "/",
+		"/portfolio":     "/portfolio",
+		"/graphql?x=1":   "/graphql?x=1",
+		"//evil":         "/",
+		"/\\evil":        "/",
+		"https://evil":   "/",
+		"javascript:foo": "/",
+	}
+	for next, want := range tests {
+		if got := safeNext(next); got != want {
+			t.Errorf("safeNext(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
+
+func TestAuthMiddlewareWithoutUsers(t *testing.T) {
+	users, err := pkg.OpenUserStore(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newServer := func(openWhenEmpty bool) *echo.Echo {
+		e := echo.New()
+		e.Use(authMiddleware(users, pkg.NewSessions(time.Hour), openWhenEmpty))
+		ok := func(c echo.Context) error {
+			return c.String(http.StatusOK, "ok")
+		}
+		e.GET("/", ok)
+		e.POST("/order", ok)
+		return e
+	}
+	open := newServer(true)
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		want   int
+	}{
+		{"GET", http.MethodGet, http.Header{"Sec-Fetch-Site": {"cross-site"}}, http.StatusOK},
+		{"POST from the dashboard", http.MethodPost, http.Header{"Sec-Fetch-Site": {"same-origin"}}, http.StatusOK},
+		{"POST from curl", http.MethodPost, nil, http.StatusOK},
+		{"cross-site POST", http.MethodPost, http.Header{"Sec-Fetch-Site": {"cross-site"}}, http.StatusForbidden},
+		{"same-site POST from another port", http.MethodPost, http.Header{"Sec-Fetch-Site": {"same-site"}}, http.StatusForbidden},
+		{"POST with another Origin", http.MethodPost, http.Header{"Origin": {"https://evil.example"}}, http.StatusForbidden},
+		{"POST with the server's Origin", http.MethodPost, http.Header{"Origin": {"http://example.com"}}, http.StatusOK},
+	}
+	for _, test := range tests {
+		path := "/"
+		if test.method == http.MethodPost {
+			path = "/order"
+		}
+		if got := serveRequest(open, test.method, path, test.header); got != test.want {
+			t.Errorf("%s: status %d, want %d", test.name, got, test.want)
+		}
+	}
+	if got := serveRequest(newServer(false), http.MethodGet, "/", nil); got != http.StatusUnauthorized {
+		t.Errorf("GET / without users, not open: status %d, want 401", got)
+	}
+
+	// a user added while serving closes it at once
+	if _, err := users.SetPassword("alice", "correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if got := serveRequest(open, http.MethodGet, "/", nil); got != http.StatusUnauthorized {
+		t.Errorf("GET / once a user exists: status %d, want 401", got)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryCache is a value the handlers share between requests. They run
+// concurrently, so it's only read and written under its lock. With a ttl
+// the value is fetched again once it's older than that.
+type memoryCache[T any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	value   T
+	ok      bool
+	fetched time.Time
+}
+
+// fresh reports whether there is a value that hasn't expired. c.mu must be
+// held.
+func (c *memoryCache[T]) fresh() bool {
+	return c.ok && (c.ttl == 0 || time.Since(c.fetched) < c.ttl)
+}
+
+// get returns the cached value, fetching it first when there's none. The
+// lock is held while fetching, so concurrent misses fetch once; a failed
+// fetch isn't cached.
+func (c *memoryCache[T]) get(fetch func() (T, error)) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fresh() {
+		return c.value, nil
+	}
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+	c.value, c.ok, c.fetched = value, true, time.Now()
+	return value, nil
+}
+
+// cached returns the value without fetching it.
+func (c *memoryCache[T]) cached() (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fresh() {
+		var zero T
+		return zero, false
+	}
+	return c.value, true
+}
+
+// set replaces the value.
+func (c *memoryCache[T]) set(value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value, c.ok, c.fetched = value, true, time.Now()
+}
+
+// fill sets the value unless there is a fresh one already.
+func (c *memoryCache[T]) fill(value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fresh() {
+		c.value, c.ok, c.fetched = value, true, time.Now()
+	}
+}
+
+// setTTL makes values older than ttl expire, never when it's 0.
+func (c *memoryCache[T]) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// reset drops the value, fetched again on the next get.
+func (c *memoryCache[T]) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var zero T
+	c.value, c.ok = zero, false
+}
+
+// keyedCache is a memoryCache per key, e.g. the orders of each pair.
+type keyedCache[T any] struct {
+	mu     sync.Mutex
+	values map[string]T
+}
+
+// get returns the value of key, fetching it first when there's none. The
+// lock isn't held while fetching, so a slow key doesn't hold up the others.
+func (c *keyedCache[T]) get(key string, fetch func() (T, error)) (T, error) {
+	c.mu.Lock()
+	value, ok := c.values[key]
+	c.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = make(map[string]T)
+	}
+	c.values[key] = value
+	return value, nil
+}
+
+// delete drops the value of key.
+func (c *keyedCache[T]) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, key)
+}
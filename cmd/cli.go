@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"goland.local/binance-portfolio/pkg"
+)
+
+func printUsage() {
+	fmt.Fprint(os.Stderr, `Usage: portfolio [global flags] <command> [flags]
+
+Commands:
+  serve      serve the web dashboard (default)
+  sync       fetch the trades of held assets (or -symbol) into the trade store
+  holdings   wallet balances and their value
+  trades     trades from the trade store
+  pnl        per-asset average buy price, unrealized and realized PNL
+  export     write holdings, stats, trades or lots as csv, jsonl or xlsx
+  statement  write the monthly PDF statement
+  accounts   value and PNL per account and sub-account, or -check the keys
+  watch      live holdings table in the terminal
+  keys       add, list and remove API keys in the encrypted keystore
+  users      add, list and remove the users of the dashboard
+  tokens     add, list and remove API tokens for /api/v1 and /graphql
+
+Run "portfolio <command> -h" for the flags of a command.
+
+Global flags override config.toml and the environment:
+`)
+	flags := flag.NewFlagSet("portfolio", flag.ContinueOnError)
+	pkg.ConfigFlags(flags)
+	flags.SetOutput(os.Stderr)
+	flags.PrintDefaults()
+}
+
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.DateTime)
+	}
+	return fmt.Sprint(value)
+}
+
+// printTable writes an export table as aligned columns.
+func printTable(w io.Writer, table pkg.ExportTable) {
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.ToUpper(strings.Join(table.Columns, "\t")))
+	for _, row := range table.Rows {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = formatCell(value)
+		}
+		fmt.Fprintln(writer, strings.Join(cells, "\t"))
+	}
+	writer.Flush()
+}
+
+func loadWalletBalances(ctx context.Context, currency string) []*pkg.WalletBalance {
+	walletBalances, err := cachedWalletBalances(ctx, currency)
+	if err != nil {
+		log.Fatal("Error getting balances - ", err)
+	}
+	return walletBalances
+}
+
+// runSyncCommand refreshes the trade stores from /myTrades, for the pairs of
+// every held asset of every account or just the given pairs of one account.
+func runSyncCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	symbols := flags.String("symbol", "", "pairs to sync, e.g. BTCUSDT,ETHBTC (default: every held asset against the base currency)")
+	accountName := flags.String("account", pkg.MainAccount, "account to sync -symbol for")
+	jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+	flags.Parse(args)
+	currency := config.Currency
+
+	type syncedSymbol struct {
+		account string
+		symbol  string
+	}
+	var synced []syncedSymbol
+	if strings.TrimSpace(*symbols) == "" {
+		failed := false
+		for _, holdings := range loadAccountsHoldings(ctx, currency) {
+			store := accountTradeStores[holdings.Account]
+			if store == nil {
+				continue
+			}
+			if holdings.Err != "" {
+				failed = true
+				continue
+			}
+			for _, warning := range holdings.Warnings {
+				if warning.Level != pkg.LevelInfo {
+					log.Errorf("%s: %s", holdings.Account, warning)
+					failed = true
+				}
+			}
+			for _, balance := range holdings.Wallet {
+				if symbol := balance.Symbol + currency; balance.Symbol != currency && store.Synced(symbol) {
+					synced = append(synced, syncedSymbol{holdings.Account, symbol})
+				}
+			}
+		}
+		if failed {
+			defer os.Exit(1)
+		}
+	} else {
+		var account pkg.Account
+		for _, candidate := range pkg.Accounts() {
+			if candidate.Name == *accountName {
+				account = candidate
+			}
+		}
+		if account.Name == "" {
+			log.Fatalf("unknown account %q", *accountName)
+		}
+		var pairs []string
+		for _, symbol := range strings.Split(strings.ToUpper(*symbols), ",") {
+			pairs = append(pairs, strings.TrimSpace(symbol))
+		}
+		failures := pkg.SyncAccountPairs(ctx, account, pairs, accountTradeStores[account.Name])
+		failed := false
+		for _, symbol := range pairs {
+			if err, ok := failures[symbol]; ok {
+				log.Errorf("%s: Error syncing trades: %v", symbol, err)
+				failed = true
+				continue
+			}
+			synced = append(synced, syncedSymbol{account.Name, symbol})
+		}
+		if failed {
+			defer os.Exit(1)
+		}
+	}
+	sort.Slice(synced, func(i, j int) bool {
+		if synced[i].account != synced[j].account {
+			return synced[i].account < synced[j].account
+		}
+		return synced[i].symbol < synced[j].symbol
+	})
+
+	type syncSummary struct {
+		Account   string `json:"account"`
+		Symbol    string `json:"symbol"`
+		Trades    int    `json:"trades"`
+		LastTrade string `json:"last_trade,omitempty"`
+	}
+	var summaries []syncSummary
+	for _, entry := range synced {
+		trades := accountTradeStores[entry.account].Trades(entry.symbol)
+		summary := syncSummary{Account: entry.account, Symbol: entry.symbol, Trades: len(trades)}
+		if len(trades) > 0 {
+			summary.LastTrade = time.UnixMilli(int64(trades[len(trades)-1].Time)).UTC().Format(time.DateTime)
+		}
+		summaries = append(summaries, summary)
+	}
+	if *jsonOutput {
+		printJSON(summaries)
+		return
+	}
+	table := pkg.ExportTable{Columns: []string{"account", "symbol", "trades", "last_trade"}}
+	for _, summary := range summaries {
+		table.Rows = append(table.Rows, []interface{}{summary.Account, summary.Symbol, summary.Trades, summary.LastTrade})
+	}
+	printTable(os.Stdout, table)
+}
+
+// runAccountsCommand lists the value and PNL of every account and
+// sub-account, and of all of them together, or with -check whether Binance
+// accepts the keys of each account.
+func runAccountsCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("accounts", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+	check := flags.Bool("check", false, "check the keys of each account with a signed request, and a WebSocket API logon for Ed25519 keys")
+	flags.Parse(args)
+	currency := config.Currency
+
+	if *check {
+		checkAccountKeys(ctx, *jsonOutput)
+		return
+	}
+
+	holdings := loadAccountsHoldings(ctx, currency)
+	if *jsonOutput {
+		printJSON(holdings)
+		return
+	}
+	table := pkg.ExportTable{Columns: []string{"account", "assets", "value", "24h", "unrealized", "realized", "error"}}
+	row := func(entry pkg.AccountHoldings) {
+		table.Rows = append(table.Rows, []interface{}{entry.Account, len(entry.Balances), fmt.Sprintf("%.2f", entry.TotalValue),
+			fmt.Sprintf("%.2f", entry.DailyPNL), fmt.Sprintf("%.2f", entry.UnrealizedPNL), fmt.Sprintf("%.2f", entry.RealizedPNL), entry.Err})
+	}
+	failed := false
+	for _, entry := range holdings {
+		row(entry)
+		failed = failed || entry.Err != ""
+	}
+	row(pkg.ConsolidateHoldings(holdings))
+	printTable(os.Stdout, table)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// checkAccountKeys prints what pkg.CheckAccountKey finds for each account,
+// exiting with 1 when Binance refused any key.
+func checkAccountKeys(ctx context.Context, jsonOutput bool) {
+	var checks []pkg.KeyCheck
+	failed := false
+	for _, account := range pkg.Accounts() {
+		check := pkg.CheckAccountKey(ctx, account)
+		checks = append(checks, check)
+		failed = failed || !check.OK()
+	}
+	if jsonOutput {
+		printJSON(checks)
+	} else {
+		table := pkg.ExportTable{Columns: []string{"account", "key", "rest", "ws-api"}}
+		for _, check := range checks {
+			wsAPI := check.WSAPI
+			if wsAPI == "" {
+				wsAPI = "needs an Ed25519 key"
+			}
+			table.Rows = append(table.Rows, []interface{}{check.Account, check.KeyType, check.REST, wsAPI})
+		}
+		printTable(os.Stdout, table)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// selectAccountHoldings returns the holdings of account, exiting when it is
+// unknown or couldn't be fetched.
+func selectAccountHoldings(ctx context.Context, currency string, account string) pkg.AccountHoldings {
+	holdings, err := pkg.SelectHoldings(loadAccountsHoldings(ctx, currency), account)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if holdings.Err != "" {
+		log.Fatalf("Error getting balances of %s - %s", holdings.Account, holdings.Err)
+	}
+	for _, warning := range holdings.Warnings {
+		log.Warn(warning)
+	}
+	return holdings
+}
+
+func runHoldingsCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("holdings", flag.ExitOnError)
+	assets := flags.String("asset", "", "only these assets, e.g. BTC,ETH")
+	account := flags.String("account", pkg.AllAccounts, "account name, name/sub-account email, or all")
+	jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+	flags.Parse(args)
+	currency := config.Currency
+
+	filter, err := pkg.NewExportFilter(*assets, "", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var balances []*pkg.WalletBalance
+	for _, balance := range selectAccountHoldings(ctx, currency, *account).Wallet {
+		if len(filter.Assets) == 0 || filter.Assets[balance.Symbol] {
+			balances = append(balances, balance)
+		}
+	}
+	if *jsonOutput {
+		printJSON(balances)
+		return
+	}
+	table := pkg.ExportTable{Columns: []string{"asset", "free", "locked", "price", "24h %", "value"}}
+	var total float64
+	for _, balance := range balances {
+		value := balance.QuoteValue
+		if balance.Symbol == currency {
+			value = balance.Free + balance.Locked
+		}
+		total += value
+		table.Rows = append(table.Rows, []interface{}{balance.Symbol, balance.Free, balance.Locked, balance.Price, fmt.Sprintf("%.2f", balance.PriceChangePercent), fmt.Sprintf("%.2f", value)})
+	}
+	table.Rows = append(table.Rows, []interface{}{"TOTAL", "", "", "", "", fmt.Sprintf("%.2f", total)})
+	printTable(os.Stdout, table)
+}
+
+func runTradesCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("trades", flag.ExitOnError)
+	assets := flags.String("asset", "", "only pairs with these assets or symbols, e.g. BTC or ETHBTC")
+	from := flags.String("from", "", "first day, YYYY-MM-DD")
+	to := flags.String("to", "", "last day, YYYY-MM-DD")
+	sync := flags.Bool("sync", false, "sync held assets from the API first")
+	jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+	flags.Parse(args)
+	currency := config.Currency
+
+	filter, err := pkg.NewExportFilter(*assets, *from, *to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	assetToTrades := tradeStore.AssetTrades()
+	if *sync {
+		if assetToTrades, err = loadAssetTrades(ctx, currency); err != nil {
+			log.Fatal("Error syncing trades - ", err)
+		}
+	}
+	table := pkg.TradesTable(assetToTrades, filter)
+	if *jsonOutput {
+		table.Write(os.Stdout, pkg.ExportJSONL)
+		return
+	}
+	printTable(os.Stdout, table)
+}
+
+func runPNLCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("pnl", flag.ExitOnError)
+	assets := flags.String("asset", "", "only these assets, e.g. BTC,ETH")
+	account := flags.String("account", pkg.AllAccounts, "account name, name/sub-account email, or all")
+	jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+	flags.Parse(args)
+	currency := config.Currency
+
+	filter, err := pkg.NewExportFilter(*assets, "", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var balances []*pkg.PortfolioBalance
+	for _, balance := range selectAccountHoldings(ctx, currency, *account).Balances {
+		if len(filter.Assets) == 0 || filter.Assets[balance.Symbol] {
+			balances = append(balances, balance)
+		}
+	}
+	if *jsonOutput {
+		printJSON(balances)
+		return
+	}
+	table := pkg.ExportTable{Columns: []string{"asset", "qty", "avg buy", "price", "value", "unrealized", "realized", "alloc %"}}
+	var unrealized, realized float64
+	for _, balance := range balances {
+		stats := balance.TradeStats
+		unrealized += stats.UnrealizedPNL
+		realized += stats.RealizedPNL
+		table.Rows = append(table.Rows, []interface{}{
+			balance.Symbol, balance.Free + balance.Locked, fmt.Sprintf("%.4f", stats.AvgBuyPrice), balance.Price,
+			fmt.Sprintf("%.2f", balance.QuoteValue), fmt.Sprintf("%.2f", stats.UnrealizedPNL), fmt.Sprintf("%.2f", stats.RealizedPNL), fmt.Sprintf("%.2f", stats.PortfolioAllocation),
+		})
+	}
+	table.Rows = append(table.Rows, []interface{}{"TOTAL", "", "", "", "", fmt.Sprintf("%.2f", unrealized), fmt.Sprintf("%.2f", realized), ""})
+	printTable(os.Stdout, table)
+}
+
+// runExportCommand writes the same datasets as /export/:dataset, to stdout
+// unless -out is given.
+func runExportCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatFlag := flags.String("format", "csv", "csv, jsonl or xlsx")
+	assets := flags.String("asset", "", "only these assets, e.g. BTC,ETH")
+	from := flags.String("from", "", "first day, YYYY-MM-DD")
+	to := flags.String("to", "", "last day, YYYY-MM-DD")
+	methodFlag := flags.String("method", "FIFO", "lot matching for lots: FIFO, LIFO or HIFO")
+	out := flags.String("out", "", "file to write (default: stdout)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: portfolio export [flags] holdings|stats|trades|lots")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	currency := config.Currency
+
+	format, err := pkg.ParseExportFormat(*formatFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter, err := pkg.NewExportFilter(*assets, *from, *to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var table pkg.ExportTable
+	switch dataset := flags.Arg(0); dataset {
+	case "holdings":
+		table = pkg.HoldingsTable(loadWalletBalances(ctx, currency), filter)
+	case "stats":
+		balances, err := pkg.GetPortfolioBalancesAndCCData(ctx, currency, loadWalletBalances(ctx, currency), tradeStore)
+		if err != nil {
+			log.Fatal("Error getting balances - ", err)
+		}
+		table = pkg.PortfolioStatsTable(balances, filter)
+	case "trades", "lots":
+		assetToTrades, err := loadAssetTrades(ctx, currency)
+		if err != nil {
+			log.Fatal("Error getting trades - ", err)
+		}
+		if dataset == "trades" {
+			table = pkg.TradesTable(assetToTrades, filter)
+			break
+		}
+		method, err := pkg.ParseLotMethod(*methodFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		table = pkg.RealizedLotsTable(pkg.RealizedPNLLots(assetToTrades, method), filter)
+	default:
+		log.Fatalf("unknown dataset %q, use holdings, stats, trades or lots", dataset)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := table.Write(w, format); err != nil {
+		log.Fatal("Error writing the export - ", err)
+	}
+}
+
+// runStatementCommand writes the PDF statement of a month to a file:
+//
+//	go run cmd/*.go statement -month 2024-09 -out statement.pdf
+func runStatementCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("statement", flag.ExitOnError)
+	monthFlag := flags.String("month", "", "month to report as YYYY-MM (default: last month)")
+	outFlag := flags.String("out", "", "PDF file to write (default: statement-YYYY-MM.pdf)")
+	account := flags.String("account", pkg.AllAccounts, "account name, or all for every account")
+	flags.Parse(args)
+	month, err := pkg.ParseStatementMonth(*monthFlag, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	statement, err := loadStatement(ctx, config.Currency, *account, month)
+	if err != nil {
+		log.Fatal("Error building the statement - ", err)
+	}
+	out := *outFlag
+	if out == "" {
+		out = fmt.Sprintf("statement-%s.pdf", statement.Month)
+	}
+	file, err := os.Create(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if err := statement.WritePDF(file); err != nil {
+		log.Fatal("Error writing the statement - ", err)
+	}
+	log.Infof("[statement]: %s written", out)
+}
@@ -0,0 +1,693 @@
+// Command fakebinance serves a small, in-memory stand-in for the Binance spot
+// REST API (and the CCData tick endpoint) so that order placement can be
+// exercised end-to-end without touching a real account.
+//
+//	go run ./cmd/fakebinance
+//	BINANCE_HOST=http://localhost:42001 CC_BASE_URL=http://localhost:42001 \
+//	BINANCE_API_KEY=fake-key BINANCE_SECRET_KEY=fake-secret go run cmd/*.go
+//
+// FAKE_BINANCE_PUBLIC_KEY=ed25519-pub.pem turns the key into an Ed25519 (or
+// RSA) one, for BINANCE_PRIVATE_KEY_FILE and the WebSocket API on
+// ws://localhost:42001/ws-api/v3.
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+const quoteAsset = "USDT"
+
+type fakeOrder struct {
+	Symbol              string `json:"symbol"`
+	OrderId             int64  `json:"orderId"`
+	OrderListId         int    `json:"orderListId"`
+	ClientOrderId       string `json:"clientOrderId"`
+	Price               string `json:"price"`
+	OrigQty             string `json:"origQty"`
+	ExecutedQty         string `json:"executedQty"`
+	CummulativeQuoteQty string `json:"cummulativeQuoteQty"`
+	Status              string `json:"status"`
+	TimeInForce         string `json:"timeInForce"`
+	Type                string `json:"type"`
+	Side                string `json:"side"`
+	Time                int64  `json:"time"`
+	UpdateTime          int64  `json:"updateTime"`
+	IsWorking           bool   `json:"isWorking"`
+	TransactTime        int64  `json:"transactTime"`
+}
+
+type fakeTrade struct {
+	Symbol          string `json:"symbol"`
+	ID              int64  `json:"id"`
+	OrderId         int64  `json:"orderId"`
+	OrderListId     int    `json:"orderListId"`
+	Price           string `json:"price"`
+	Qty             string `json:"qty"`
+	QuoteQty        string `json:"quoteQty"`
+	Commission      string `json:"commission"`
+	CommissionAsset string `json:"commissionAsset"`
+	Time            int64  `json:"time"`
+	IsBuyer         bool   `json:"isBuyer"`
+	IsMaker         bool   `json:"isMaker"`
+	IsBestMatch     bool   `json:"isBestMatch"`
+}
+
+type exchange struct {
+	mu     sync.Mutex
+	apiKey string
+	secret string
+	// publicKey, from the PEM file in FAKE_BINANCE_PUBLIC_KEY, makes the
+	// API key an RSA or Ed25519 one instead of HMAC
+	publicKey crypto.PublicKey
+	// clockOffset, FAKE_BINANCE_CLOCK_OFFSET, puts the server clock ahead
+	// (or behind) the local one to exercise the time sync
+	clockOffset time.Duration
+	nextId      int64
+	free        map[string]float64
+	locked      map[string]float64
+	prices      map[string]float64
+	orders      []*fakeOrder
+	trades      []fakeTrade
+	feeRate     float64
+	stepSize    float64
+	// usedWeight is the request weight spent in weightMinute, reported
+	// like Binance in X-MBX-USED-WEIGHT-1M; past weightLimit,
+	// FAKE_BINANCE_WEIGHT_LIMIT, requests are refused with 429
+	usedWeight   int
+	weightMinute int64
+	weightLimit  int
+	// orders placed in the current 10 seconds and day, reported in
+	// X-MBX-ORDER-COUNT-10S and -1D
+	orders10s, ordersDay  int
+	orderWindow, orderDay int64
+}
+
+// requestWeights are the weights of the heavier endpoints; the rest cost 1.
+var requestWeights = map[string]int{
+	"/api/v3/account":      20,
+	"/api/v3/myTrades":     20,
+	"/api/v3/allOrders":    20,
+	"/api/v3/exchangeInfo": 20,
+	"/api/v3/openOrders":   6,
+}
+
+// countWeight adds the weight of every /api request to the current minute
+// and reports the total.
+func (x *exchange) countWeight(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		path := c.Request().URL.Path
+		if !strings.HasPrefix(path, "/api/") {
+			return next(c)
+		}
+		weight, ok := requestWeights[path]
+		if !ok {
+			weight = 1
+		}
+		x.mu.Lock()
+		now := x.now().Unix()
+		if minute := now / 60; minute != x.weightMinute {
+			x.weightMinute, x.usedWeight = minute, 0
+		}
+		x.usedWeight += weight
+		c.Response().Header().Set("X-MBX-USED-WEIGHT-1M", strconv.Itoa(x.usedWeight))
+		if c.Request().Method == http.MethodPost && path == "/api/v3/order" {
+			if window := now / 10; window != x.orderWindow {
+				x.orderWindow, x.orders10s = window, 0
+			}
+			if day := now / 86400; day != x.orderDay {
+				x.orderDay, x.ordersDay = day, 0
+			}
+			x.orders10s++
+			x.ordersDay++
+			c.Response().Header().Set("X-MBX-ORDER-COUNT-10S", strconv.Itoa(x.orders10s))
+			c.Response().Header().Set("X-MBX-ORDER-COUNT-1D", strconv.Itoa(x.ordersDay))
+		}
+		overLimit := x.usedWeight > x.weightLimit
+		x.mu.Unlock()
+		if overLimit {
+			c.Response().Header().Set("Retry-After", strconv.FormatInt(60-now%60, 10))
+			return binanceError(c, http.StatusTooManyRequests, -1003, "Too many requests; current limit is exceeded, please use WebSocket Streams for live updates to avoid polling the API.")
+		}
+		return next(c)
+	}
+}
+
+func newExchange() *exchange {
+	var publicKey crypto.PublicKey
+	if path := os.Getenv("FAKE_BINANCE_PUBLIC_KEY"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			log.Fatalf("%s: no PEM public key", path)
+		}
+		if publicKey, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			log.Fatal(err)
+		}
+	}
+	clockOffset, err := time.ParseDuration(envOr("FAKE_BINANCE_CLOCK_OFFSET", "0s"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	weightLimit, err := strconv.Atoi(envOr("FAKE_BINANCE_WEIGHT_LIMIT", "6000"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &exchange{
+		publicKey:   publicKey,
+		clockOffset: clockOffset,
+		weightLimit: weightLimit,
+		apiKey:      envOr("FAKE_BINANCE_API_KEY", "fake-key"),
+		secret:      envOr("FAKE_BINANCE_SECRET_KEY", "fake-secret"),
+		nextId:      1,
+		free:        map[string]float64{"USDT": 10000, "BTC": 0.25, "ETH": 3, "BNB": 10},
+		locked:      map[string]float64{},
+		prices:      map[string]float64{"BTCUSDT": 60000, "ETHUSDT": 3000, "BNBUSDT": 550, "GBPUSDT": 1.27},
+		feeRate:     0.001,
+		stepSize:    0.00001,
+	}
+}
+
+func (x *exchange) now() time.Time {
+	return time.Now().Add(x.clockOffset)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// errResponded stops a handler once binanceError has written the response;
+// echo leaves committed responses alone.
+var errResponded = errors.New("error response written")
+
+func binanceError(c echo.Context, status int, code int, msg string) error {
+	if err := c.JSON(status, map[string]interface{}{"code": code, "msg": msg}); err != nil {
+		return err
+	}
+	return errResponded
+}
+
+// verifySignature checks signature over payload: base64 RSA PKCS#1 v1.5 or
+// Ed25519 with the public key when there is one, hex HMAC-SHA256 otherwise.
+func (x *exchange) verifySignature(payload string, signature string) bool {
+	switch key := x.publicKey.(type) {
+	case nil:
+		mac := hmac.New(sha256.New, []byte(x.secret))
+		mac.Write([]byte(payload))
+		return hex.EncodeToString(mac.Sum(nil)) == signature
+	case ed25519.PublicKey:
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		return err == nil && ed25519.Verify(key, []byte(payload), decoded)
+	case *rsa.PublicKey:
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		digest := sha256.Sum256([]byte(payload))
+		return err == nil && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], decoded) == nil
+	}
+	return false
+}
+
+// signedParams checks the API key header and the signature over the query
+// string and body, the same way Binance does.
+func (x *exchange) signedParams(c echo.Context) (url.Values, error) {
+	if c.Request().Header.Get("X-MBX-APIKEY") != x.apiKey {
+		return nil, binanceError(c, 401, -2015, "Invalid API-key, IP, or permissions for action.")
+	}
+	rawQuery := c.Request().URL.RawQuery
+	body, _ := io.ReadAll(c.Request().Body)
+	payload := rawQuery
+	if len(body) > 0 {
+		if payload != "" {
+			payload += "&"
+		}
+		payload += string(body)
+	}
+	index := strings.LastIndex(payload, "&signature=")
+	if index < 0 {
+		return nil, binanceError(c, 400, -1102, "Mandatory parameter 'signature' was not sent.")
+	}
+	signature, err := url.QueryUnescape(payload[index+len("&signature="):])
+	if err != nil || !x.verifySignature(payload[:index], signature) {
+		return nil, binanceError(c, 400, -1022, "Signature for this request is not valid.")
+	}
+	params, err := url.ParseQuery(payload)
+	if err != nil {
+		return nil, binanceError(c, 400, -1100, "Illegal characters found in parameter.")
+	}
+	timestamp, _ := strconv.ParseInt(params.Get("timestamp"), 10, 64)
+	recvWindow := int64(5000)
+	if params.Has("recvWindow") {
+		recvWindow, _ = strconv.ParseInt(params.Get("recvWindow"), 10, 64)
+	}
+	if d := x.now().UnixMilli() - timestamp; d > recvWindow || d < -1000 {
+		return nil, binanceError(c, 400, -1021, "Timestamp for this request is outside of the recvWindow.")
+	}
+	return params, nil
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 8, 64)
+}
+
+func (x *exchange) baseAsset(symbol string) string {
+	return strings.TrimSuffix(symbol, quoteAsset)
+}
+
+func (x *exchange) account(c echo.Context) error {
+	if _, err := x.signedParams(c); err != nil {
+		return err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var balances []map[string]string
+	for asset, free := range x.free {
+		balances = append(balances, map[string]string{"asset": asset, "free": formatFloat(free), "locked": formatFloat(x.locked[asset])})
+	}
+	return c.JSON(200, map[string]interface{}{
+		"makerCommission": 10,
+		"takerCommission": 10,
+		"commissionRates": map[string]string{"maker": formatFloat(x.feeRate), "taker": formatFloat(x.feeRate), "buyer": "0", "seller": "0"},
+		"canTrade":        true,
+		"accountType":     "SPOT",
+		"balances":        balances,
+		"permissions":     []string{"SPOT"},
+	})
+}
+
+func (x *exchange) myTrades(c echo.Context) error {
+	params, err := x.signedParams(c)
+	if err != nil {
+		return err
+	}
+	// like Binance: limit defaults to 500, at most 1000, from trade fromId on
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 500
+	}
+	limit = min(limit, 1000)
+	fromID, _ := strconv.ParseInt(params.Get("fromId"), 10, 64)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	trades := []fakeTrade{}
+	for _, trade := range x.trades {
+		if trade.Symbol == params.Get("symbol") && trade.ID >= fromID && len(trades) < limit {
+			trades = append(trades, trade)
+		}
+	}
+	return c.JSON(200, trades)
+}
+
+func (x *exchange) allOrders(c echo.Context) error {
+	params, err := x.signedParams(c)
+	if err != nil {
+		return err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	orders := []*fakeOrder{}
+	for _, order := range x.orders {
+		if order.Symbol == params.Get("symbol") {
+			orders = append(orders, order)
+		}
+	}
+	return c.JSON(200, orders)
+}
+
+func (x *exchange) openOrders(c echo.Context) error {
+	params, err := x.signedParams(c)
+	if err != nil {
+		return err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	orders := []*fakeOrder{}
+	for _, order := range x.orders {
+		if order.IsWorking && (params.Get("symbol") == "" || order.Symbol == params.Get("symbol")) {
+			orders = append(orders, order)
+		}
+	}
+	return c.JSON(200, orders)
+}
+
+// release gives back what a working order locked and marks it cancelled.
+func (x *exchange) release(order *fakeOrder) {
+	price, _ := strconv.ParseFloat(order.Price, 64)
+	qty, _ := strconv.ParseFloat(order.OrigQty, 64)
+	if order.Side == "BUY" {
+		x.locked[quoteAsset] -= qty * price
+		x.free[quoteAsset] += qty * price
+	} else {
+		base := x.baseAsset(order.Symbol)
+		x.locked[base] -= qty
+		x.free[base] += qty
+	}
+	order.Status = "CANCELED"
+	order.IsWorking = false
+	order.UpdateTime = time.Now().UnixMilli()
+}
+
+func (x *exchange) cancelOrder(c echo.Context) error {
+	params, err := x.signedParams(c)
+	if err != nil {
+		return err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	orderId, _ := strconv.ParseInt(params.Get("orderId"), 10, 64)
+	for _, order := range x.orders {
+		if order.OrderId == orderId && order.Symbol == params.Get("symbol") && order.IsWorking {
+			x.release(order)
+			return c.JSON(200, order)
+		}
+	}
+	return binanceError(c, 400, -2011, "Unknown order sent.")
+}
+
+func (x *exchange) cancelOpenOrders(c echo.Context) error {
+	params, err := x.signedParams(c)
+	if err != nil {
+		return err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	orders := []*fakeOrder{}
+	for _, order := range x.orders {
+		if order.IsWorking && order.Symbol == params.Get("symbol") {
+			x.release(order)
+			orders = append(orders, order)
+		}
+	}
+	if len(orders) == 0 {
+		return binanceError(c, 400, -2011, "Unknown order sent.")
+	}
+	return c.JSON(200, orders)
+}
+
+func (x *exchange) newOrder(test bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		params, err := x.signedParams(c)
+		if err != nil {
+			return err
+		}
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		symbol := params.Get("symbol")
+		marketPrice, ok := x.prices[symbol]
+		if !ok {
+			return binanceError(c, 400, -1121, "Invalid symbol.")
+		}
+		side, orderType := params.Get("side"), params.Get("type")
+		qty, _ := strconv.ParseFloat(params.Get("quantity"), 64)
+		if quoteQty, _ := strconv.ParseFloat(params.Get("quoteOrderQty"), 64); qty == 0 && quoteQty > 0 && orderType == "MARKET" {
+			qty = quoteQty / marketPrice
+		}
+		price := marketPrice
+		if orderType == "LIMIT" {
+			price, _ = strconv.ParseFloat(params.Get("price"), 64)
+		}
+		if qty <= 0 || price <= 0 {
+			return binanceError(c, 400, -1013, "Filter failure: LOT_SIZE")
+		}
+		if qty*price < 5 {
+			return binanceError(c, 400, -1013, "Filter failure: NOTIONAL")
+		}
+		base := x.baseAsset(symbol)
+		if (side == "BUY" && x.free[quoteAsset] < qty*price) || (side == "SELL" && x.free[base] < qty) {
+			return binanceError(c, 400, -2010, "Account has insufficient balance for requested action.")
+		}
+		if test {
+			return c.JSON(200, map[string]interface{}{})
+		}
+
+		now := time.Now().UnixMilli()
+		order := &fakeOrder{
+			Symbol:        symbol,
+			OrderId:       x.nextId,
+			OrderListId:   -1,
+			ClientOrderId: fmt.Sprintf("fake-%d", x.nextId),
+			Price:         formatFloat(price),
+			OrigQty:       formatFloat(qty),
+			ExecutedQty:   formatFloat(0),
+			Status:        "NEW",
+			TimeInForce:   params.Get("timeInForce"),
+			Type:          orderType,
+			Side:          side,
+			Time:          now,
+			UpdateTime:    now,
+			IsWorking:     true,
+			TransactTime:  now,
+		}
+		if clientOrderId := params.Get("newClientOrderId"); clientOrderId != "" {
+			order.ClientOrderId = clientOrderId
+		}
+		x.nextId++
+		x.orders = append(x.orders, order)
+		var fills []map[string]interface{}
+		if orderType == "MARKET" {
+			fills = append(fills, x.fill(order, marketPrice, qty))
+		} else if side == "BUY" {
+			x.free[quoteAsset] -= qty * price
+			x.locked[quoteAsset] += qty * price
+		} else {
+			x.free[base] -= qty
+			x.locked[base] += qty
+		}
+		return c.JSON(200, map[string]interface{}{
+			"symbol":              order.Symbol,
+			"orderId":             order.OrderId,
+			"clientOrderId":       order.ClientOrderId,
+			"transactTime":        order.TransactTime,
+			"price":               order.Price,
+			"origQty":             order.OrigQty,
+			"executedQty":         order.ExecutedQty,
+			"cummulativeQuoteQty": order.CummulativeQuoteQty,
+			"status":              order.Status,
+			"timeInForce":         order.TimeInForce,
+			"type":                order.Type,
+			"side":                order.Side,
+			"fills":               fills,
+		})
+	}
+}
+
+// fill executes order in full at price and books the trade and the fee.
+func (x *exchange) fill(order *fakeOrder, price float64, qty float64) map[string]interface{} {
+	base := x.baseAsset(order.Symbol)
+	commission := qty * x.feeRate
+	if order.Side == "BUY" {
+		x.free[quoteAsset] -= qty * price
+		x.free[base] += qty - commission
+	} else {
+		commission = qty * price * x.feeRate
+		x.free[base] -= qty
+		x.free[quoteAsset] += qty*price - commission
+	}
+	commissionAsset := base
+	if order.Side == "SELL" {
+		commissionAsset = quoteAsset
+	}
+	order.Status = "FILLED"
+	order.IsWorking = false
+	order.ExecutedQty = formatFloat(qty)
+	order.CummulativeQuoteQty = formatFloat(qty * price)
+	order.UpdateTime = time.Now().UnixMilli()
+	trade := fakeTrade{
+		Symbol:          order.Symbol,
+		ID:              int64(len(x.trades) + 1),
+		OrderId:         order.OrderId,
+		OrderListId:     -1,
+		Price:           formatFloat(price),
+		Qty:             formatFloat(qty),
+		QuoteQty:        formatFloat(qty * price),
+		Commission:      formatFloat(commission),
+		CommissionAsset: commissionAsset,
+		Time:            order.UpdateTime,
+		IsBuyer:         order.Side == "BUY",
+		IsMaker:         order.Type == "LIMIT",
+		IsBestMatch:     true,
+	}
+	x.trades = append(x.trades, trade)
+	return map[string]interface{}{"price": trade.Price, "qty": trade.Qty, "commission": trade.Commission, "commissionAsset": trade.CommissionAsset, "tradeId": trade.ID}
+}
+
+func (x *exchange) tickerPrice(c echo.Context) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	symbol := c.QueryParam("symbol")
+	price, ok := x.prices[symbol]
+	if !ok {
+		return binanceError(c, 400, -1121, "Invalid symbol.")
+	}
+	return c.JSON(200, map[string]string{"symbol": symbol, "price": formatFloat(price)})
+}
+
+func (x *exchange) ticker24hr(c echo.Context) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	symbol := c.QueryParam("symbol")
+	price, ok := x.prices[symbol]
+	if !ok {
+		return binanceError(c, 400, -1121, "Invalid symbol.")
+	}
+	return c.JSON(200, map[string]string{"symbol": symbol, "priceChange": formatFloat(price * 0.01), "lastPrice": formatFloat(price)})
+}
+
+func (x *exchange) klines(c echo.Context) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	price, ok := x.prices[c.QueryParam("symbol")]
+	if !ok {
+		return binanceError(c, 400, -1121, "Invalid symbol.")
+	}
+	startTime, _ := strconv.ParseInt(c.QueryParam("startTime"), 10, 64)
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	day := int64(24 * time.Hour / time.Millisecond)
+	now := time.Now().UnixMilli()
+	rows := [][]interface{}{}
+	for openTime := startTime - startTime%day; openTime <= now && len(rows) < limit; openTime += day {
+		close := formatFloat(price)
+		rows = append(rows, []interface{}{openTime, close, close, close, close, "0", openTime + day - 1})
+	}
+	return c.JSON(200, rows)
+}
+
+func (x *exchange) exchangeInfo(c echo.Context) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var symbols []map[string]interface{}
+	for symbol := range x.prices {
+		symbols = append(symbols, map[string]interface{}{
+			"symbol":     symbol,
+			"status":     "TRADING",
+			"baseAsset":  x.baseAsset(symbol),
+			"quoteAsset": quoteAsset,
+			"orderTypes": []string{"LIMIT", "MARKET"},
+			"filters": []map[string]string{
+				{"filterType": "PRICE_FILTER", "minPrice": "0.01", "maxPrice": "1000000", "tickSize": "0.01"},
+				{"filterType": "LOT_SIZE", "minQty": formatFloat(x.stepSize), "maxQty": "9000", "stepSize": formatFloat(x.stepSize)},
+				{"filterType": "NOTIONAL", "minNotional": "5.00000000"},
+			},
+		})
+	}
+	rateLimits := []map[string]interface{}{
+		{"rateLimitType": "REQUEST_WEIGHT", "interval": "MINUTE", "intervalNum": 1, "limit": x.weightLimit},
+		{"rateLimitType": "ORDERS", "interval": "SECOND", "intervalNum": 10, "limit": 100},
+		{"rateLimitType": "ORDERS", "interval": "DAY", "intervalNum": 1, "limit": 200000},
+		{"rateLimitType": "RAW_REQUESTS", "interval": "MINUTE", "intervalNum": 5, "limit": 61000},
+	}
+	return c.JSON(200, map[string]interface{}{"timezone": "UTC", "serverTime": time.Now().UnixMilli(), "rateLimits": rateLimits, "symbols": symbols})
+}
+
+// emptyHistory stands in for the capital deposit and withdrawal history; the
+// fake exchange only ever trades.
+func (x *exchange) emptyHistory(c echo.Context) error {
+	if _, err := x.signedParams(c); err != nil {
+		return err
+	}
+	return c.JSON(200, []interface{}{})
+}
+
+// subAccountAssets stands in for /sapi/v4/sub-account/assets: every
+// sub-account holds the same small balance.
+func (x *exchange) subAccountAssets(c echo.Context) error {
+	params, err := x.signedParams(c)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(params.Get("email"), "@") {
+		return binanceError(c, 400, -12022, "email is not a sub-account of this master.")
+	}
+	return c.JSON(200, map[string]interface{}{
+		"balances": []map[string]interface{}{
+			{"asset": "BTC", "free": "0.05", "locked": "0", "freeze": "0", "withdrawing": "0"},
+			{"asset": "USDT", "free": "250", "locked": "0", "freeze": "0", "withdrawing": "0"},
+		},
+	})
+}
+
+// ccDataTick answers like data-api.ccdata.io/spot/v1/latest/tick.
+func (x *exchange) ccDataTick(c echo.Context) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	data := make(map[string]interface{})
+	for _, instrument := range strings.Split(c.QueryParam("instruments"), ",") {
+		price, ok := x.prices[strings.ReplaceAll(instrument, "-", "")]
+		if !ok {
+			continue
+		}
+		data[instrument] = map[string]interface{}{
+			"TYPE":                          "952",
+			"MARKET":                        "binance",
+			"INSTRUMENT":                    instrument,
+			"PRICE":                         price,
+			"PRICE_FLAG":                    "UP",
+			"PRICE_LAST_UPDATE_TS":          time.Now().Unix(),
+			"CURRENT_DAY_CHANGE":            price * 0.01,
+			"CURRENT_DAY_CHANGE_PERCENTAGE": 1.0,
+		}
+	}
+	return c.JSON(200, map[string]interface{}{"Data": data})
+}
+
+func main() {
+	x := newExchange()
+	e := echo.New()
+	e.HideBanner = true
+	e.Use(x.countWeight)
+
+	e.GET("/api/v3/ping", func(c echo.Context) error {
+		return c.JSON(200, map[string]interface{}{})
+	})
+	e.GET("/api/v3/time", func(c echo.Context) error {
+		return c.JSON(200, map[string]int64{"serverTime": x.now().UnixMilli()})
+	})
+	e.GET("/api/v3/account", x.account)
+	e.GET("/api/v3/myTrades", x.myTrades)
+	e.GET("/api/v3/allOrders", x.allOrders)
+	e.POST("/api/v3/order", x.newOrder(false))
+	e.POST("/api/v3/order/test", x.newOrder(true))
+	e.DELETE("/api/v3/order", x.cancelOrder)
+	e.GET("/api/v3/openOrders", x.openOrders)
+	e.DELETE("/api/v3/openOrders", x.cancelOpenOrders)
+	e.GET("/api/v3/ticker/price", x.tickerPrice)
+	e.GET("/api/v3/ticker/24hr", x.ticker24hr)
+	e.GET("/api/v3/klines", x.klines)
+	e.GET("/api/v3/exchangeInfo", x.exchangeInfo)
+	e.GET("/sapi/v1/capital/deposit/hisrec", x.emptyHistory)
+	e.GET("/sapi/v1/capital/withdraw/history", x.emptyHistory)
+	e.GET("/sapi/v4/sub-account/assets", x.subAccountAssets)
+	e.GET("/spot/v1/latest/tick", x.ccDataTick)
+	e.GET("/ws-api/v3", echo.WrapHandler(websocket.Handler(x.wsAPI)))
+
+	port := envOr("FAKE_BINANCE_PORT", "42001")
+	log.Infof("fake binance listening on :%s (api key %q)", port, x.apiKey)
+	if err := e.Start(fmt.Sprintf(":%s", port)); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+type wsRequest struct {
+	ID     string                 `json:"id"`
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+type wsError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// wsAPI serves the few WebSocket API methods the client uses: ping,
+// session.logon (Ed25519 keys only, like Binance), session.status and
+// account.status once logged on.
+func (x *exchange) wsAPI(conn *websocket.Conn) {
+	defer conn.Close()
+	connectedSince := time.Now().UnixMilli()
+	var authorizedSince int64
+	for {
+		var message []byte
+		if err := websocket.Message.Receive(conn, &message); err != nil {
+			return
+		}
+		var request wsRequest
+		decoder := json.NewDecoder(bytes.NewReader(message))
+		// keep the timestamp as it was sent, the signature covers its digits
+		decoder.UseNumber()
+		if err := decoder.Decode(&request); err != nil {
+			websocket.JSON.Send(conn, map[string]interface{}{"id": nil, "status": 400, "error": wsError{-1100, "Malformed request."}})
+			continue
+		}
+		result, status, wsErr := x.wsCall(request, &authorizedSince, connectedSince)
+		response := map[string]interface{}{"id": request.ID, "status": status}
+		if wsErr != nil {
+			response["error"] = wsErr
+		} else {
+			response["result"] = result
+		}
+		if err := websocket.JSON.Send(conn, response); err != nil {
+			return
+		}
+	}
+}
+
+func (x *exchange) wsCall(request wsRequest, authorizedSince *int64, connectedSince int64) (interface{}, int, *wsError) {
+	switch request.Method {
+	case "ping":
+		return map[string]interface{}{}, 200, nil
+	case "session.logon":
+		if _, ok := x.publicKey.(ed25519.PublicKey); !ok {
+			return nil, 400, &wsError{-4056, "HMAC_SHA256 and RSA API keys are not supported."}
+		}
+		if request.Params["apiKey"] != x.apiKey {
+			return nil, 401, &wsError{-2015, "Invalid API-key, IP, or permissions for action."}
+		}
+		signature, _ := request.Params["signature"].(string)
+		names := make([]string, 0, len(request.Params))
+		for name := range request.Params {
+			if name != "signature" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		pairs := make([]string, len(names))
+		for i, name := range names {
+			pairs[i] = fmt.Sprintf("%s=%v", name, request.Params[name])
+		}
+		if !x.verifySignature(strings.Join(pairs, "&"), signature) {
+			return nil, 400, &wsError{-1022, "Signature for this request is not valid."}
+		}
+		*authorizedSince = time.Now().UnixMilli()
+		return x.wsSession(*authorizedSince, connectedSince), 200, nil
+	case "session.status":
+		return x.wsSession(*authorizedSince, connectedSince), 200, nil
+	case "account.status":
+		if *authorizedSince == 0 {
+			return nil, 401, &wsError{-2015, "Invalid API-key, IP, or permissions for action."}
+		}
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		var balances []map[string]string
+		for asset, free := range x.free {
+			balances = append(balances, map[string]string{"asset": asset, "free": formatFloat(free), "locked": formatFloat(x.locked[asset])})
+		}
+		return map[string]interface{}{"canTrade": true, "accountType": "SPOT", "balances": balances}, 200, nil
+	}
+	return nil, 400, &wsError{-1100, fmt.Sprintf("Unknown method %q.", request.Method)}
+}
+
+func (x *exchange) wsSession(authorizedSince int64, connectedSince int64) map[string]interface{} {
+	session := map[string]interface{}{"apiKey": nil, "authorizedSince": nil, "connectedSince": connectedSince, "returnRateLimits": false, "serverTime": time.Now().UnixMilli()}
+	if authorizedSince != 0 {
+		session["apiKey"], session["authorizedSince"] = x.apiKey, authorizedSince
+	}
+	return session
+}
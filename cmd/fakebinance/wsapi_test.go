@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"goland.local/binance-portfolio/pkg"
+	"golang.org/x/net/websocket"
+)
+
+func privateKeyPEM(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// startWSAPI serves the WebSocket API of an exchange that knows publicKey
+// and points the client at it.
+func startWSAPI(t *testing.T, publicKey interface{}) {
+	t.Helper()
+	x := newExchange()
+	x.publicKey = publicKey
+	server := httptest.NewServer(websocket.Handler(x.wsAPI))
+	t.Cleanup(server.Close)
+	config := pkg.DefaultConfig()
+	config.BinanceWSAPIURL = "ws" + strings.TrimPrefix(server.URL, "http")
+	config.BinanceTimeSync = 0
+	pkg.SetConfig(config)
+}
+
+func TestWSAPILogon(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startWSAPI(t, publicKey)
+	ctx := context.Background()
+
+	conn, err := pkg.DialWSAPI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Call(ctx, "account.status", nil); err == nil {
+		t.Fatal("account.status before logon succeeded")
+	}
+	account := pkg.Account{Name: pkg.MainAccount, APIKey: "fake-key", PrivateKey: privateKeyPEM(t, privateKey)}
+	if err := conn.Logon(ctx, account); err != nil {
+		t.Fatalf("Logon: %v", err)
+	}
+	result, err := conn.Call(ctx, "session.status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var session struct {
+		APIKey string `json:"apiKey"`
+	}
+	if err := json.Unmarshal(result, &session); err != nil || session.APIKey != "fake-key" {
+		t.Errorf("session.status = %s, want logged on as fake-key", result)
+	}
+	if _, err := conn.Call(ctx, "account.status", nil); err != nil {
+		t.Errorf("account.status after logon: %v", err)
+	}
+
+	// a signature by another key is refused by the exchange
+	conn, err = pkg.DialWSAPI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	account.PrivateKey = privateKeyPEM(t, otherKey)
+	var wsErr pkg.WSAPIError
+	if err := conn.Logon(ctx, account); !errors.As(err, &wsErr) || wsErr.Code != -1022 {
+		t.Errorf("Logon with another key = %v, want error -1022", err)
+	}
+}
+
+func TestWSAPILogonRejectsOtherKeyTypes(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startWSAPI(t, publicKey)
+	ctx := context.Background()
+	conn, err := pkg.DialWSAPI(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accounts := map[string]pkg.Account{
+		"HMAC": {Name: pkg.MainAccount, APIKey: "fake-key", SecretKey: "fake-secret"},
+		"RSA":  {Name: pkg.MainAccount, APIKey: "fake-key", PrivateKey: privateKeyPEM(t, rsaKey)},
+	}
+	for keyType, account := range accounts {
+		err := conn.Logon(ctx, account)
+		if err == nil || !strings.Contains(err.Error(), "Ed25519") {
+			t.Errorf("Logon with an %s key = %v, want an Ed25519 error", keyType, err)
+		}
+	}
+}
+
+func TestCheckAccountKey(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := newExchange()
+	x.publicKey = publicKey
+	e := echo.New()
+	e.GET("/api/v3/account", x.account)
+	e.GET("/ws-api/v3", echo.WrapHandler(websocket.Handler(x.wsAPI)))
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	config := pkg.DefaultConfig()
+	config.BinanceHost = server.URL
+	config.BinanceWSAPIURL = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws-api/v3"
+	config.BinanceTimeSync = 0
+	pkg.SetConfig(config)
+
+	ctx := context.Background()
+	account := pkg.Account{Name: "main", APIKey: "fake-key", PrivateKey: privateKeyPEM(t, privateKey)}
+	want := pkg.KeyCheck{Account: "main", KeyType: "Ed25519", REST: "ok", WSAPI: "ok"}
+	if got := pkg.CheckAccountKey(ctx, account); got != want || !got.OK() {
+		t.Errorf("CheckAccountKey = %+v, want %+v", got, want)
+	}
+
+	account.PrivateKey = privateKeyPEM(t, otherKey)
+	if got := pkg.CheckAccountKey(ctx, account); got.OK() || got.REST == "ok" || got.WSAPI == "ok" {
+		t.Errorf("CheckAccountKey with an unregistered key = %+v, want both refused", got)
+	}
+
+	// an exchange that knows the Ed25519 key refuses HMAC signatures, and
+	// HMAC keys can't log on at all
+	hmac := pkg.CheckAccountKey(ctx, pkg.Account{Name: "hmac", APIKey: "fake-key", SecretKey: "fake-secret"})
+	if hmac.OK() || hmac.KeyType != "HMAC" || hmac.WSAPI != "" {
+		t.Errorf("CheckAccountKey with an HMAC key = %+v, want REST refused and no logon", hmac)
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/labstack/echo/v4"
+	"goland.local/binance-portfolio/pkg"
+)
+
+// gqlError carries the error code of /api/v1 in the extensions of a GraphQL
+// error.
+type gqlError struct {
+	code pkg.ErrorCode
+	err  error
+}
+
+func (e gqlError) Error() string {
+	return e.err.Error()
+}
+
+func (e gqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+func gqlInvalid(err error) error {
+	return gqlError{pkg.ErrorInvalidParameter, err}
+}
+
+func gqlUpstream(err error) error {
+	return gqlError{pkg.UpstreamErrorCode(err), err}
+}
+
+// gqlBalance is a holding of account (all when merged) with the warnings
+// about it. Its own fields resolve from the balance.
+type gqlBalance struct {
+	*pkg.PortfolioBalance
+	account  string
+	warnings []pkg.AssetWarning
+}
+
+func (b gqlBalance) Resolve(p graphql.ResolveParams) (interface{}, error) {
+	p.Source = b.PortfolioBalance
+	return graphql.DefaultResolveFn(p)
+}
+
+func gqlTime(ms int64) string {
+	if ms == 0 {
+		return ""
+	}
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+// gqlTimeField renders the milliseconds ms returns as RFC 3339; GraphQL's Int
+// is 32 bits.
+func gqlTimeField[S any](description string, ms func(S) int64) *graphql.Field {
+	return &graphql.Field{Type: graphql.String, Description: description, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		if ts := ms(p.Source.(S)); ts != 0 {
+			return gqlTime(ts), nil
+		}
+		return nil, nil
+	}}
+}
+
+func gqlIDField[S any](id func(S) int64) *graphql.Field {
+	return &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return strconv.FormatInt(id(p.Source.(S)), 10), nil
+	}}
+}
+
+func gqlList(of graphql.Type) *graphql.List {
+	return graphql.NewList(graphql.NewNonNull(of))
+}
+
+var (
+	gqlAssetsArg  = &graphql.ArgumentConfig{Type: gqlList(graphql.String), Description: "assets or pairs, e.g. [\"BTC\", \"ETHUSDT\"]"}
+	gqlFromArg    = &graphql.ArgumentConfig{Type: graphql.String, Description: "first day, YYYY-MM-DD UTC"}
+	gqlToArg      = &graphql.ArgumentConfig{Type: graphql.String, Description: "last day, YYYY-MM-DD UTC, inclusive"}
+	gqlLimitArg   = &graphql.ArgumentConfig{Type: graphql.Int, Description: "at most this many, 1 to 1000 (default all)"}
+	gqlOffsetArg  = &graphql.ArgumentConfig{Type: graphql.Int, Description: "skip this many"}
+	gqlAccountArg = &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: pkg.AllAccounts,
+		Description: "account name, name/sub-account email, or all for every account merged by asset"}
+)
+
+// gqlFilter reads the assets, from and to arguments; assets, when given,
+// narrows them down further.
+func gqlFilter(p graphql.ResolveParams, assets ...string) (pkg.ExportFilter, error) {
+	if list, ok := p.Args["assets"].([]interface{}); ok {
+		for _, asset := range list {
+			assets = append(assets, asset.(string))
+		}
+	}
+	from, _ := p.Args["from"].(string)
+	to, _ := p.Args["to"].(string)
+	filter, err := pkg.NewExportFilter(strings.Join(assets, ","), from, to)
+	if err != nil {
+		return filter, gqlInvalid(err)
+	}
+	return filter, nil
+}
+
+// gqlPage applies the limit and offset arguments, if any, to items.
+func gqlPage[T any](p graphql.ResolveParams, items []T) ([]T, error) {
+	limit, hasLimit := p.Args["limit"].(int)
+	offset, hasOffset := p.Args["offset"].(int)
+	if !hasLimit && !hasOffset {
+		return items, nil
+	}
+	if !hasLimit {
+		limit = len(items)
+	}
+	page, err := pkg.ParsePage(strconv.Itoa(max(limit, 1)), strconv.Itoa(offset))
+	if err != nil || limit < 1 {
+		return nil, gqlInvalid(fmt.Errorf("limit must be between 1 and 1000, offset 0 or more"))
+	}
+	items, _ = pkg.Paginate(items, page)
+	return items, nil
+}
+
+func gqlStrings(p graphql.ResolveParams, name string) []string {
+	var values []string
+	if list, ok := p.Args[name].([]interface{}); ok {
+		for _, value := range list {
+			values = append(values, value.(string))
+		}
+	}
+	return values
+}
+
+// gqlHoldings returns the holdings of account, fetching them like the
+// dashboard does.
+func gqlHoldings(p graphql.ResolveParams, account string) (pkg.AccountHoldings, error) {
+	holdings, err := pkg.SelectHoldings(loadAccountsHoldings(p.Context, config.Currency), account)
+	if err != nil {
+		return holdings, gqlInvalid(err)
+	}
+	if holdings.Err != "" {
+		return holdings, gqlError{pkg.ErrorUpstream, fmt.Errorf("%s", holdings.Err)}
+	}
+	return holdings, nil
+}
+
+func gqlBalances(p graphql.ResolveParams, holdings pkg.AccountHoldings) ([]gqlBalance, error) {
+	filter, err := gqlFilter(p)
+	if err != nil {
+		return nil, err
+	}
+	var balances []gqlBalance
+	for _, balance := range pkg.FilterBalances(holdings.Balances, filter) {
+		var warnings []pkg.AssetWarning
+		for _, warning := range holdings.Warnings {
+			if warning.Asset == balance.Symbol {
+				warnings = append(warnings, warning)
+			}
+		}
+		balances = append(balances, gqlBalance{balance, holdings.Account, warnings})
+	}
+	return balances, nil
+}
+
+// gqlTrades returns the stored trades of account, once the holdings have
+// synced every held pair.
+func gqlTrades(p graphql.ResolveParams, account string, assets ...string) ([]pkg.Trade, error) {
+	if _, err := gqlHoldings(p, account); err != nil {
+		return nil, err
+	}
+	filter, err := gqlFilter(p, assets...)
+	if err != nil {
+		return nil, err
+	}
+	trades := pkg.FilterTrades(accountAssetTrades(account), filter)
+	if side, ok := p.Args["side"].(string); ok {
+		var sided []pkg.Trade
+		for _, trade := range trades {
+			if trade.IsBuyer == (side == "BUY") {
+				sided = append(sided, trade)
+			}
+		}
+		trades = sided
+	}
+	return gqlPage(p, trades)
+}
+
+func gqlLots(p graphql.ResolveParams, account string, assets ...string) ([]pkg.RealizedLot, error) {
+	if _, err := gqlHoldings(p, account); err != nil {
+		return nil, err
+	}
+	filter, err := gqlFilter(p, assets...)
+	if err != nil {
+		return nil, err
+	}
+	method, _ := p.Args["method"].(string)
+	return gqlPage(p, pkg.FilterLots(pkg.RealizedPNLLots(accountAssetTrades(account), pkg.LotMethod(method)), filter))
+}
+
+// gqlOrders fetches the orders of symbol of account, signed with its own
+// key: a sub-account's are an error.
+func gqlOrders(p graphql.ResolveParams, account string, symbol string) ([]pkg.Order, error) {
+	filter, err := gqlFilter(p)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := pkg.SelectAccounts(account)
+	if err != nil {
+		return nil, gqlInvalid(err)
+	}
+	orders, err := pkg.GetAccountsOrders(p.Context, accounts, symbol, strconv.Itoa(config.OrderFetchLimit))
+	if err != nil {
+		return nil, gqlUpstream(err)
+	}
+	if statuses := gqlStrings(p, "status"); len(statuses) > 0 {
+		orders = pkg.FilterOrdersByStatus(orders, statuses...)
+	}
+	return gqlPage(p, pkg.FilterOrders(orders, filter))
+}
+
+// newGraphQLSchema builds the schema of /graphql: accounts, balances with
+// their trade stats, trades, lots and orders, and performance against
+// benchmarks, from the same holdings and trade stores as the dashboard.
+func newGraphQLSchema() (graphql.Schema, error) {
+	warningType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Warning",
+		Description: "an asset missing (error) or stale (warning) in the data, or a note (info)",
+		Fields: graphql.Fields{
+			"account": &graphql.Field{Type: graphql.String},
+			"asset":   &graphql.Field{Type: graphql.String},
+			"level":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"message": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	freshnessFields := graphql.Fields{}
+	for _, source := range []string{pkg.SourceBalances, pkg.SourcePrices, pkg.SourceTrades} {
+		freshnessFields[source] = &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			if at, ok := p.Source.(map[string]time.Time)[source]; ok {
+				return at.UTC().Format(time.RFC3339), nil
+			}
+			return nil, nil
+		}}
+	}
+	freshnessType := graphql.NewObject(graphql.ObjectConfig{Name: "Freshness", Description: "when the data of each source was fetched", Fields: freshnessFields})
+
+	sideEnum := graphql.NewEnum(graphql.EnumConfig{Name: "Side", Values: graphql.EnumValueConfigMap{
+		"BUY": &graphql.EnumValueConfig{Value: "BUY"}, "SELL": &graphql.EnumValueConfig{Value: "SELL"},
+	}})
+	lotMethodEnum := graphql.NewEnum(graphql.EnumConfig{Name: "LotMethod", Values: graphql.EnumValueConfigMap{
+		string(pkg.LotFIFO): &graphql.EnumValueConfig{Value: string(pkg.LotFIFO)},
+		string(pkg.LotLIFO): &graphql.EnumValueConfig{Value: string(pkg.LotLIFO)},
+		string(pkg.LotHIFO): &graphql.EnumValueConfig{Value: string(pkg.LotHIFO)},
+	}})
+
+	tradeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Trade",
+		Fields: graphql.Fields{
+			"id":      gqlIDField(func(t pkg.Trade) int64 { return t.ID }),
+			"orderId": gqlIDField(func(t pkg.Trade) int64 { return t.OrderId }),
+			"symbol":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"side": &graphql.Field{Type: graphql.NewNonNull(sideEnum), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return map[bool]string{true: "BUY", false: "SELL"}[p.Source.(pkg.Trade).IsBuyer], nil
+			}},
+			"price":           &graphql.Field{Type: graphql.Float},
+			"qty":             &graphql.Field{Type: graphql.Float},
+			"quoteQty":        &graphql.Field{Type: graphql.Float},
+			"commission":      &graphql.Field{Type: graphql.Float},
+			"commissionAsset": &graphql.Field{Type: graphql.String},
+			"isMaker":         &graphql.Field{Type: graphql.Boolean},
+			"imported":        &graphql.Field{Type: graphql.Boolean, Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(pkg.Trade).ID < 0, nil }},
+			"time":            gqlTimeField("", func(t pkg.Trade) int64 { return int64(t.Time) }),
+		},
+	})
+	lotType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Lot",
+		Description: "a sell matched with the buy it closes, in the quote asset of the pair",
+		Fields: graphql.Fields{
+			"symbol":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"baseAsset":  &graphql.Field{Type: graphql.String},
+			"quoteAsset": &graphql.Field{Type: graphql.String},
+			"qty":        &graphql.Field{Type: graphql.Float},
+			"boughtAt":   gqlTimeField("", func(l pkg.RealizedLot) int64 { return l.BoughtAt }),
+			"soldAt":     gqlTimeField("", func(l pkg.RealizedLot) int64 { return l.SoldAt }),
+			"costBasis":  &graphql.Field{Type: graphql.Float},
+			"proceeds":   &graphql.Field{Type: graphql.Float},
+			"pnl":        &graphql.Field{Type: graphql.Float},
+			"unmatched":  &graphql.Field{Type: graphql.Boolean, Description: "no buy on record, the cost basis is zero"},
+		},
+	})
+	orderType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Order",
+		Fields: graphql.Fields{
+			"orderId":             gqlIDField(func(o pkg.Order) int64 { return o.OrderId }),
+			"clientOrderId":       &graphql.Field{Type: graphql.String},
+			"symbol":              &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"side":                &graphql.Field{Type: graphql.String},
+			"type":                &graphql.Field{Type: graphql.String},
+			"status":              &graphql.Field{Type: graphql.String},
+			"timeInForce":         &graphql.Field{Type: graphql.String},
+			"price":               &graphql.Field{Type: graphql.Float},
+			"stopPrice":           &graphql.Field{Type: graphql.Float},
+			"origQty":             &graphql.Field{Type: graphql.Float},
+			"executedQty":         &graphql.Field{Type: graphql.Float},
+			"cummulativeQuoteQty": &graphql.Field{Type: graphql.Float},
+			"time":                gqlTimeField("", func(o pkg.Order) int64 { return int64(o.Time) }),
+			"updateTime":          gqlTimeField("", func(o pkg.Order) int64 { return int64(o.UpdateTime) }),
+		},
+	})
+
+	tradePriceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TradePrice",
+		Fields: graphql.Fields{
+			"price": &graphql.Field{Type: graphql.Float},
+			"time":  gqlTimeField("", func(t pkg.TradePriceAndTimestamp) int64 { return int64(t.Timestamp) }),
+		},
+	})
+	sideStatsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SideStats",
+		Fields: graphql.Fields{
+			"qty":       &graphql.Field{Type: graphql.Float},
+			"totalCost": &graphql.Field{Type: graphql.Float},
+			"totalGain": &graphql.Field{Type: graphql.Float},
+			"last":      &graphql.Field{Type: tradePriceType},
+			"highest":   &graphql.Field{Type: tradePriceType},
+			"lowest":    &graphql.Field{Type: tradePriceType},
+		},
+	})
+	tradeStatsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TradeStats",
+		Fields: graphql.Fields{
+			"totalValue":          &graphql.Field{Type: graphql.Float},
+			"avgBuyPrice":         &graphql.Field{Type: graphql.Float},
+			"dailyPnl":            &graphql.Field{Type: graphql.Float},
+			"unrealizedPnl":       &graphql.Field{Type: graphql.Float},
+			"realizedPnl":         &graphql.Field{Type: graphql.Float},
+			"portfolioAllocation": &graphql.Field{Type: graphql.Float, Description: "percent of the account's value"},
+			"buy":                 &graphql.Field{Type: sideStatsType},
+			"sale":                &graphql.Field{Type: sideStatsType},
+			"makerQty": &graphql.Field{Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(pkg.PortfolioTradeStats).LiquidityProvider.MakerQty, nil
+			}},
+			"takerQty": &graphql.Field{Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(pkg.PortfolioTradeStats).LiquidityProvider.TakerQty, nil
+			}},
+		},
+	})
+
+	tradesArgs := graphql.FieldConfigArgument{"from": gqlFromArg, "to": gqlToArg, "side": &graphql.ArgumentConfig{Type: sideEnum}, "limit": gqlLimitArg, "offset": gqlOffsetArg}
+	lotsArgs := graphql.FieldConfigArgument{"from": gqlFromArg, "to": gqlToArg, "method": &graphql.ArgumentConfig{Type: lotMethodEnum, DefaultValue: string(pkg.LotFIFO)}, "limit": gqlLimitArg, "offset": gqlOffsetArg}
+	ordersArgs := graphql.FieldConfigArgument{"status": &graphql.ArgumentConfig{Type: gqlList(graphql.String), Description: "e.g. [\"FILLED\", \"CANCELED\"]"}, "from": gqlFromArg, "to": gqlToArg, "limit": gqlLimitArg, "offset": gqlOffsetArg}
+	withAssets := func(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		withAssets := graphql.FieldConfigArgument{"account": gqlAccountArg, "assets": gqlAssetsArg}
+		for name, arg := range args {
+			withAssets[name] = arg
+		}
+		return withAssets
+	}
+
+	balanceType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Balance",
+		Description: "a held asset, valued in the base currency",
+		Fields: graphql.Fields{
+			"symbol":             &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"quoteSymbol":        &graphql.Field{Type: graphql.String},
+			"free":               &graphql.Field{Type: graphql.Float},
+			"locked":             &graphql.Field{Type: graphql.Float},
+			"price":              &graphql.Field{Type: graphql.Float},
+			"priceFlag":          &graphql.Field{Type: graphql.String},
+			"priceChangeValue":   &graphql.Field{Type: graphql.Float},
+			"priceChangePercent": &graphql.Field{Type: graphql.Float},
+			"quoteValue":         &graphql.Field{Type: graphql.Float},
+			"tradeStats":         &graphql.Field{Type: tradeStatsType},
+			"warnings": &graphql.Field{Type: graphql.NewNonNull(gqlList(warningType)), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(gqlBalance).warnings, nil
+			}},
+			"trades": &graphql.Field{Type: gqlList(tradeType), Args: tradesArgs, Description: "stored trades of the pairs of the asset", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				balance := p.Source.(gqlBalance)
+				return gqlTrades(p, balance.account, balance.Symbol)
+			}},
+			"lots": &graphql.Field{Type: gqlList(lotType), Args: lotsArgs, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				balance := p.Source.(gqlBalance)
+				return gqlLots(p, balance.account, balance.Symbol)
+			}},
+			"orders": &graphql.Field{Type: gqlList(orderType), Args: ordersArgs, Description: "orders of the asset against the base currency, from Binance", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				balance := p.Source.(gqlBalance)
+				if balance.Symbol == config.Currency {
+					return []pkg.Order{}, nil
+				}
+				return gqlOrders(p, balance.account, balance.Symbol+config.Currency)
+			}},
+		},
+	})
+
+	accountType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Account",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(pkg.AccountHoldings).Account, nil
+			}},
+			"master":        &graphql.Field{Type: graphql.String},
+			"subAccount":    &graphql.Field{Type: graphql.String},
+			"totalValue":    &graphql.Field{Type: graphql.Float},
+			"dailyPnl":      &graphql.Field{Type: graphql.Float},
+			"unrealizedPnl": &graphql.Field{Type: graphql.Float},
+			"realizedPnl":   &graphql.Field{Type: graphql.Float},
+			"error": &graphql.Field{Type: graphql.String, Description: "why the account couldn't be fetched", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if err := p.Source.(pkg.AccountHoldings).Err; err != "" {
+					return err, nil
+				}
+				return nil, nil
+			}},
+			"balances": &graphql.Field{Type: gqlList(balanceType), Args: graphql.FieldConfigArgument{"assets": gqlAssetsArg}, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return gqlBalances(p, p.Source.(pkg.AccountHoldings))
+			}},
+			"warnings": &graphql.Field{Type: graphql.NewNonNull(gqlList(warningType)), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(pkg.AccountHoldings).Warnings, nil
+			}},
+			"freshness": &graphql.Field{Type: freshnessType, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(pkg.AccountHoldings).Freshness, nil
+			}},
+		},
+	})
+
+	pointType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "EquityPoint",
+		Fields: graphql.Fields{
+			"time":        gqlTimeField("", func(e pkg.EquityPoint) int64 { return e.Timestamp }),
+			"value":       &graphql.Field{Type: graphql.Float},
+			"netInvested": &graphql.Field{Type: graphql.Float},
+		},
+	})
+	weightType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Weight",
+		Fields: graphql.Fields{
+			"asset":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"weight": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+		},
+	})
+	seriesType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PerformanceSeries",
+		Description: "daily value of the portfolio, or of a benchmark bought with the same cash flows",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"weights": &graphql.Field{Type: gqlList(weightType), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				var weights []map[string]interface{}
+				for asset, weight := range p.Source.(pkg.PerformanceSeries).Weights {
+					weights = append(weights, map[string]interface{}{"asset": asset, "weight": weight})
+				}
+				return weights, nil
+			}},
+			"points": &graphql.Field{Type: gqlList(pointType), Args: graphql.FieldConfigArgument{"from": gqlFromArg, "to": gqlToArg}, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				filter, err := gqlFilter(p)
+				if err != nil {
+					return nil, err
+				}
+				var points []pkg.EquityPoint
+				for _, point := range p.Source.(pkg.PerformanceSeries).Points {
+					if (filter.From == 0 || point.Timestamp >= filter.From) && (filter.To == 0 || point.Timestamp <= filter.To) {
+						points = append(points, point)
+					}
+				}
+				return points, nil
+			}},
+			"finalValue":     &graphql.Field{Type: graphql.Float},
+			"netInvested":    &graphql.Field{Type: graphql.Float},
+			"profit":         &graphql.Field{Type: graphql.Float},
+			"return":         &graphql.Field{Type: graphql.Float},
+			"relativeReturn": &graphql.Field{Type: graphql.Float},
+		},
+	})
+	performanceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Performance",
+		Fields: graphql.Fields{
+			"currency":   &graphql.Field{Type: graphql.String},
+			"portfolio":  &graphql.Field{Type: seriesType},
+			"benchmarks": &graphql.Field{Type: gqlList(seriesType)},
+			"warnings":   &graphql.Field{Type: graphql.NewNonNull(gqlList(warningType)), Description: "pairs left out of the portfolio and assets dropped from a benchmark for lack of a price history"},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"accounts": &graphql.Field{Type: gqlList(accountType), Description: "every account and sub-account", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return loadAccountsHoldings(p.Context, config.Currency), nil
+			}},
+			"account": &graphql.Field{Type: accountType, Args: graphql.FieldConfigArgument{"name": gqlAccountArg}, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				holdings, err := gqlHoldings(p, p.Args["name"].(string))
+				if err != nil {
+					return nil, err
+				}
+				return holdings, nil
+			}},
+			"balances": &graphql.Field{Type: gqlList(balanceType), Args: graphql.FieldConfigArgument{"account": gqlAccountArg, "assets": gqlAssetsArg}, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				holdings, err := gqlHoldings(p, p.Args["account"].(string))
+				if err != nil {
+					return nil, err
+				}
+				return gqlBalances(p, holdings)
+			}},
+			"trades": &graphql.Field{Type: gqlList(tradeType), Args: withAssets(tradesArgs), Description: "stored trades, API and imported, oldest first", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return gqlTrades(p, p.Args["account"].(string))
+			}},
+			"lots": &graphql.Field{Type: gqlList(lotType), Args: withAssets(lotsArgs), Description: "realized lots, in the order they were sold", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return gqlLots(p, p.Args["account"].(string))
+			}},
+			"orders": &graphql.Field{Type: gqlList(orderType), Args: func() graphql.FieldConfigArgument {
+				args := graphql.FieldConfigArgument{
+					"symbol":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String), Description: "pair, e.g. BTCUSDT"},
+					"account": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: pkg.AllAccounts, Description: "account name, or all for the orders of every account"},
+				}
+				for name, arg := range ordersArgs {
+					args[name] = arg
+				}
+				return args
+			}(), Description: "orders of a pair, oldest first, from Binance", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return gqlOrders(p, p.Args["account"].(string), strings.ToUpper(p.Args["symbol"].(string)))
+			}},
+			"performance": &graphql.Field{Type: performanceType, Description: "the portfolio against benchmarks bought with the same cash flows", Args: graphql.FieldConfigArgument{
+				"account": gqlAccountArg,
+				"benchmarks": &graphql.ArgumentConfig{Type: gqlList(graphql.String), DefaultValue: []interface{}{"BTC", "ETH"},
+					Description: "an asset (\"BTC\"), a top-N basket by market cap (\"top10\") or weights (\"BTC:60,ETH:40\")"},
+			}, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				var specs []pkg.BenchmarkSpec
+				for _, benchmark := range gqlStrings(p, "benchmarks") {
+					spec, err := pkg.ParseBenchmarkSpec(p.Context, benchmark, config.Currency)
+					if err != nil {
+						return nil, gqlInvalid(err)
+					}
+					specs = append(specs, spec)
+				}
+				account := p.Args["account"].(string)
+				if _, err := gqlHoldings(p, account); err != nil {
+					return nil, err
+				}
+				assetToTrades := accountAssetTrades(account)
+				if len(pkg.FilterTrades(assetToTrades, pkg.ExportFilter{})) == 0 {
+					return nil, gqlError{pkg.ErrorNotFound, fmt.Errorf("no trades to compare against")}
+				}
+				comparison, err := pkg.CompareToBenchmarks(p.Context, config.Currency, assetToTrades, specs)
+				if err != nil {
+					return nil, gqlUpstream(err)
+				}
+				return comparison, nil
+			}},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// graphQLHandler answers GraphQL queries sent as JSON ({"query",
+// "variables", "operationName"}) in a POST, or as the same query params in
+// a GET.
+func graphQLHandler(schema graphql.Schema) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request struct {
+			Query         string                 `json:"query"`
+			Variables     map[string]interface{} `json:"variables"`
+			OperationName string                 `json:"operationName"`
+		}
+		if c.Request().Method == http.MethodGet {
+			request.Query, request.OperationName = c.QueryParam("query"), c.QueryParam("operationName")
+			if variables := c.QueryParam("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+					return gqlRequestError(c, pkg.ErrorInvalidParameter, fmt.Errorf("variables: %w", err))
+				}
+			}
+		} else if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+			return gqlRequestError(c, pkg.ErrorInvalidParameter, fmt.Errorf("body: %w", err))
+		}
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  request.Query,
+			VariableValues: request.Variables,
+			OperationName:  request.OperationName,
+			Context:        c.Request().Context(),
+		})
+		// a query that didn't parse or validate has no data at all
+		if result.Data == nil && result.HasErrors() {
+			return c.JSON(400, result)
+		}
+		return c.JSON(200, result)
+	}
+}
+
+// gqlRequestError answers a request that can't be run at all, e.g. one that
+// isn't a GraphQL request.
+func gqlRequestError(c echo.Context, code pkg.ErrorCode, err error) error {
+	return c.JSON(code.Status(), graphql.Result{Errors: []gqlerrors.FormattedError{{
+		Message:    err.Error(),
+		Locations:  []location.SourceLocation{},
+		Extensions: gqlError{code, err}.Extensions(),
+	}}})
+}
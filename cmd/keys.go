@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"goland.local/binance-portfolio/pkg"
+)
+
+// secretPrompt reads passphrases and keys without echoing them when stdin is
+// a terminal, or line by line when it's piped, e.g. from a password manager.
+type secretPrompt struct {
+	reader   *bufio.Reader
+	terminal bool
+}
+
+func newSecretPrompt() *secretPrompt {
+	return &secretPrompt{reader: bufio.NewReader(os.Stdin), terminal: isTerminal(int(os.Stdin.Fd()))}
+}
+
+func (p *secretPrompt) read(label string) (string, error) {
+	if p.terminal {
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+		if restore, err := disableEcho(int(os.Stdin.Fd())); err == nil {
+			defer restore()
+		}
+		defer fmt.Fprintln(os.Stderr)
+	}
+	line, err := p.reader.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", fmt.Errorf("reading %s: %w", strings.ToLower(label), err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// keystorePassphrase reads storage.keystore_passphrase_file when it's set and
+// asks otherwise, twice when confirm is set for a new keystore.
+func keystorePassphrase(prompt *secretPrompt, confirm bool) (string, error) {
+	if config.KeystorePassphraseFile != "" {
+		data, err := os.ReadFile(config.KeystorePassphraseFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if !confirm {
+		return prompt.read("Keystore passphrase")
+	}
+	passphrase, err := prompt.read("New keystore passphrase")
+	if err != nil {
+		return "", err
+	}
+	repeated, err := prompt.read("Repeat passphrase")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != repeated {
+		return "", errors.New("passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+// unlockKeystore applies the keys in the keystore, when there is one, to
+// config. It's done once at startup; the passphrase isn't kept.
+func unlockKeystore(prompt *secretPrompt) error {
+	if _, err := os.Stat(config.KeystorePath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	passphrase, err := keystorePassphrase(prompt, false)
+	if err != nil {
+		return fmt.Errorf("keystore %s is locked: %w (set storage.keystore_passphrase_file to start without a terminal)", config.KeystorePath, err)
+	}
+	keystore, err := pkg.OpenKeystore(config.KeystorePath, passphrase)
+	if err != nil {
+		return err
+	}
+	for _, name := range config.ApplyKeystore(keystore) {
+		log.Warnf("%s: using the keystore's, delete the plaintext ones from config.toml or .env", name)
+	}
+	return nil
+}
+
+// openOrCreateKeystore opens the keystore, creating an empty one when create
+// is set and there's none yet.
+func openOrCreateKeystore(prompt *secretPrompt, create bool) (*pkg.Keystore, error) {
+	if _, err := os.Stat(config.KeystorePath); errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return nil, fmt.Errorf("no keystore at %s, add a key with: keys add <name>", config.KeystorePath)
+		}
+		passphrase, err := keystorePassphrase(prompt, true)
+		if err != nil {
+			return nil, err
+		}
+		return pkg.CreateKeystore(config.KeystorePath, passphrase)
+	}
+	passphrase, err := keystorePassphrase(prompt, false)
+	if err != nil {
+		return nil, err
+	}
+	return pkg.OpenKeystore(config.KeystorePath, passphrase)
+}
+
+func printKeysUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: portfolio keys <command>
+
+Manages the API keys in the encrypted keystore (%s).
+
+Commands:
+  list           names of the stored keys, masked
+  add <name>     store the keys of an account (%s or one of accounts.<name>), or %s for the CCData key;
+                 -private-key key.pem for an RSA or Ed25519 API key instead of a secret key
+  remove <name>  delete the keys of name
+  import         move the keys set in config.toml or the environment into the keystore
+`, config.KeystorePath, pkg.MainAccount, pkg.CCDataCredential)
+}
+
+func runKeysCommand(args []string) {
+	if len(args) == 0 {
+		printKeysUsage()
+		os.Exit(2)
+	}
+	prompt := newSecretPrompt()
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("keys list", flag.ExitOnError)
+		jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+		flags.Parse(args[1:])
+		keystore, err := openOrCreateKeystore(prompt, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		type keyInfo struct {
+			Name   string `json:"name"`
+			APIKey string `json:"api_key"`
+			Type   string `json:"type,omitempty"`
+		}
+		var keys []keyInfo
+		table := pkg.ExportTable{Columns: []string{"name", "api_key", "type"}}
+		for _, credential := range keystore.Credentials() {
+			keys = append(keys, keyInfo{credential.Name, pkg.MaskKey(credential.APIKey), credential.Type()})
+			table.Rows = append(table.Rows, []interface{}{credential.Name, pkg.MaskKey(credential.APIKey), credential.Type()})
+		}
+		if *jsonOutput {
+			printJSON(keys)
+			return
+		}
+		printTable(os.Stdout, table)
+	case "add":
+		flags := flag.NewFlagSet("keys add", flag.ExitOnError)
+		privateKeyPath := flags.String("private-key", "", "PEM private key of an RSA or Ed25519 API key, instead of a secret key")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			printKeysUsage()
+			os.Exit(2)
+		}
+		credential := pkg.Credential{Name: flags.Arg(0)}
+		if *privateKeyPath != "" {
+			privateKey, err := os.ReadFile(*privateKeyPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			credential.PrivateKey = string(privateKey)
+		}
+		keystore, err := openOrCreateKeystore(prompt, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if credential.APIKey, err = prompt.read("API key"); err != nil {
+			log.Fatal(err)
+		}
+		if credential.Name != pkg.CCDataCredential && credential.PrivateKey == "" {
+			if credential.SecretKey, err = prompt.read("Secret key"); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := keystore.Set(credential); err != nil {
+			log.Fatal(err)
+		}
+		if err := keystore.Save(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Saved %s %s to %s\n", credential, credential.Type(), keystore.Path())
+	case "remove":
+		if len(args) != 2 {
+			printKeysUsage()
+			os.Exit(2)
+		}
+		keystore, err := openOrCreateKeystore(prompt, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !keystore.Remove(args[1]) {
+			log.Fatalf("No keys named %s in %s", args[1], keystore.Path())
+		}
+		if err := keystore.Save(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Removed %s from %s\n", args[1], keystore.Path())
+	case "import":
+		credentials := config.PlaintextCredentials()
+		if len(credentials) == 0 {
+			log.Fatal("No keys in config.toml or the environment to import")
+		}
+		keystore, err := openOrCreateKeystore(prompt, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, credential := range credentials {
+			if err := keystore.Set(credential); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := keystore.Save(); err != nil {
+			log.Fatal(err)
+		}
+		for _, credential := range credentials {
+			fmt.Printf("Imported %s\n", credential)
+		}
+		fmt.Println("Now delete the keys from config.toml and .env.")
+	default:
+		printKeysUsage()
+		os.Exit(2)
+	}
+}
@@ -1,130 +1,349 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"html/template"
-	"io"
-	"strings"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/labstack/echo/v4"
 	log "github.com/sirupsen/logrus"
 	"goland.local/binance-portfolio/pkg"
 )
 
-type Template struct {
-	tmpls *template.Template
-}
-
-func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	return t.tmpls.ExecuteTemplate(w, name, data)
-}
-
-type HeaderTr struct {
-	Name  string
-	Icon  string
-	Class string
-}
-
-type TableSection struct {
-	Header []HeaderTr
-}
-
-type IndexPage struct {
-	Email        string
-	ErrorMsgs    map[string]string
-	TableSection TableSection
-}
-
 var ErrorGenericResp = errors.New("error fetching data or pair doesn't exist for this user")
 
-var walletBalancesInMemory []*pkg.WalletBalance
+var config pkg.Config
+var walletBalancesInMemory memoryCache[[]*pkg.WalletBalance]
 var portfolioBalancesInMemory []*pkg.PortfolioBalance
-var assetToTradesInMemory = make(map[string][]pkg.Trade)
+var tradeStore *pkg.TradeStore
+var accountTradeStores = make(map[string]*pkg.TradeStore)
+var accountHoldingsInMemory memoryCache[[]pkg.AccountHoldings]
+var assetToOrdersInMemory keyedCache[[]pkg.Order]
+
+// commands are the subcommands of the binary; without one it serves the web
+// dashboard.
+var commands = map[string]func(ctx context.Context, args []string){
+	"serve":     runServeCommand,
+	"sync":      runSyncCommand,
+	"holdings":  runHoldingsCommand,
+	"trades":    runTradesCommand,
+	"pnl":       runPNLCommand,
+	"export":    runExportCommand,
+	"statement": runStatementCommand,
+	"accounts":  runAccountsCommand,
+	"watch":     runWatchCommand,
+	"keys":      func(_ context.Context, args []string) { runKeysCommand(args) },
+	"users":     func(_ context.Context, args []string) { runUsersCommand(args) },
+	"tokens":    func(_ context.Context, args []string) { runTokensCommand(args) },
+}
 
 func main() {
 	var err error
 	envFile := ".env"
-	err = godotenv.Load(envFile)
-	if err != nil {
-		log.Fatal(fmt.Sprintf("You must provide an %s file to continue - ", envFile), err)
+	// .env is optional, settings can also come from config.toml, the
+	// environment or flags
+	if err = godotenv.Load(envFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(fmt.Sprintf("Error reading %s - ", envFile), err)
 	}
 
-	e := echo.New()
+	globalFlags := flag.NewFlagSet("portfolio", flag.ExitOnError)
+	globalFlags.Usage = printUsage
+	configValues := pkg.ConfigFlags(globalFlags)
+	globalFlags.Parse(os.Args[1:])
+	config, err = pkg.LoadConfig(globalFlags, configValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	pkg.UnsetSecretEnv()
+	// the dashboard's holdings are at most one refresh old
+	accountHoldingsInMemory.setTTL(config.DashboardRefresh)
 
-	e.Renderer = &Template{
-		tmpls: template.Must(template.ParseGlob("views/*.html")),
+	args := globalFlags.Args()
+	level := log.InfoLevel
+	if len(args) > 0 && args[0] != "serve" {
+		// keep stdout for the output and stderr quiet enough for cron
+		level = log.WarnLevel
+	}
+	if config.LogLevel != "" {
+		level, _ = log.ParseLevel(config.LogLevel)
 	}
+	pkg.SetLogging(level, config.LogFormat)
 
-	e.Static("/src", "src")
+	// these manage the keystore and the users, so they don't need the keys
+	if len(args) > 0 && (args[0] == "keys" || args[0] == "users" || args[0] == "tokens") {
+		commands[args[0]](context.Background(), args[1:])
+		return
+	}
+	if err = unlockKeystore(newSecretPrompt()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = config.CheckCredentials(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	pkg.SetConfig(config)
 
-	e.GET("/orders", func(c echo.Context) error {
-		symbol := c.QueryParam("symbol")
-		limit := c.QueryParam("limit")
-		if strings.TrimSpace(symbol) == "" {
-			return c.JSON(400, map[string]interface{}{"Err": "strange input", "Data": nil})
+	for _, account := range pkg.Accounts() {
+		store, err := pkg.OpenTradeStore(pkg.AccountTradeStorePath(config.TradeStorePath, account.Name))
+		if err != nil {
+			log.Fatalf("Error opening the trade store of %s - %v", account.Name, err)
 		}
-		if strings.TrimSpace(limit) == "" {
-			limit = "1000"
+		accountTradeStores[account.Name] = store
+	}
+	tradeStore = accountTradeStores[pkg.MainAccount]
+
+	// the first Ctrl-C cancels whatever is in flight, a second one kills
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if len(args) == 0 {
+		runServeCommand(ctx, nil)
+		return
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		printUsage()
+		if args[0] != "help" {
+			os.Exit(2)
 		}
-		var data []pkg.Order
-		data, err = pkg.GetAllOrders(symbol, limit)
-		return c.JSON(200, data)
+		return
+	}
+	command(ctx, args[1:])
+}
+
+// cachedWalletBalances returns the main account's wallet, fetched once and
+// kept in memory until an order changes it.
+func cachedWalletBalances(ctx context.Context, currency string) ([]*pkg.WalletBalance, error) {
+	return walletBalancesInMemory.get(func() ([]*pkg.WalletBalance, error) {
+		return pkg.GetWalletBalancesAndCCData(ctx, currency)
 	})
+}
 
-	e.GET("/trades", func(c echo.Context) error {
-		symbol := c.QueryParam("symbol")
-		limit := c.QueryParam("limit")
-		if strings.TrimSpace(symbol) == "" {
-			return c.JSON(400, map[string]interface{}{"Err": "strange input", "Data": nil})
+// loadAssetTrades makes sure the wallet and the trades of every held asset
+// are in memory, going to the APIs for whatever is missing.
+func loadAssetTrades(ctx context.Context, currency string) (map[string][]pkg.Trade, error) {
+	walletBalances, err := cachedWalletBalances(ctx, currency)
+	if err != nil {
+		return tradeStore.AssetTrades(), err
+	}
+	_, err = pkg.GetPortfolioBalancesAndCCData(ctx, currency, walletBalances, tradeStore)
+	return tradeStore.AssetTrades(), err
+}
+
+// loadAccountAssetTrades returns the wallet and the stored trades of
+// account, or of every account merged for all, once its holdings synced the
+// trades of what it holds. A sub-account has a wallet but no trades.
+func loadAccountAssetTrades(ctx context.Context, currency string, account string) ([]*pkg.WalletBalance, map[string][]pkg.Trade, error) {
+	holdings, err := pkg.SelectHoldings(loadAccountsHoldings(ctx, currency), account)
+	if err != nil {
+		return nil, nil, err
+	}
+	if holdings.Err != "" {
+		return nil, nil, errors.New(holdings.Err)
+	}
+	return holdings.Wallet, accountAssetTrades(account), nil
+}
+
+// accountTradeStore returns the trade store of account, the main one when
+// it's empty.
+func accountTradeStore(account string) (*pkg.TradeStore, error) {
+	if account == "" {
+		account = pkg.MainAccount
+	}
+	if store := accountTradeStores[account]; store != nil {
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown account %q, trades belong to an account with its own keys, not %s or a sub-account", account, pkg.AllAccounts)
+}
+
+// accountLedger returns the ledger entries of account, or of every account
+// oldest first for all.
+func accountLedger(account string) ([]pkg.LedgerEntry, error) {
+	if account != "" && account != pkg.AllAccounts {
+		store, err := accountTradeStore(account)
+		if err != nil {
+			return nil, err
 		}
-		if strings.TrimSpace(limit) == "" {
-			limit = "1000"
+		return store.Ledger(), nil
+	}
+	var ledger []pkg.LedgerEntry
+	for _, account := range pkg.Accounts() {
+		if store := accountTradeStores[account.Name]; store != nil {
+			ledger = append(ledger, store.Ledger()...)
 		}
-		var data []pkg.Trade
-		data, err = pkg.GetTradesList(symbol, limit)
-		return c.JSON(200, data)
+	}
+	sort.SliceStable(ledger, func(i, j int) bool {
+		return ledger[i].Time < ledger[j].Time
 	})
+	return ledger, nil
+}
 
-	e.GET("/portfolio", func(c echo.Context) error {
-		var err error
-		var balances []*pkg.PortfolioBalance
-		currency := "USDT"
-		if len(walletBalancesInMemory) != 0 {
-			log.Info("[getWalletBalancesAndCCData]: Getting from memory")
-		} else {
-			walletBalances, err := pkg.GetWalletBalancesAndCCData(currency)
-			if err != nil {
-				return c.JSON(200, pkg.RESTResp[[]*pkg.WalletBalance]{Data: walletBalancesInMemory})
-			}
-			walletBalancesInMemory = walletBalances
+// loadAccountsHoldings fetches the holdings of every account, keeping them in
+// memory for a dashboard refresh once all accounts answered with every price
+// and trade. The main account's wallet also fills walletBalancesInMemory,
+// which orders, rebalancing and reports use.
+func loadAccountsHoldings(ctx context.Context, currency string) []pkg.AccountHoldings {
+	if holdings, ok := accountHoldingsInMemory.cached(); ok {
+		log.Info("[loadAccountsHoldings]: Getting from memory")
+		return holdings
+	}
+	holdings := pkg.GetAccountsHoldings(ctx, currency, accountTradeStores)
+	complete := true
+	for _, entry := range holdings {
+		if entry.Err != "" {
+			log.Errorf("%s: Error getting holdings: %s", entry.Account, entry.Err)
+			complete = false
+			continue
+		}
+		complete = complete && !entry.Partial()
+		if entry.Account == pkg.MainAccount {
+			walletBalancesInMemory.fill(entry.Wallet)
+		}
+	}
+	if complete {
+		accountHoldingsInMemory.set(holdings)
+	}
+	return holdings
+}
+
+// accountAssetTrades returns the stored trades of account, or of every
+// account for all. Sub-accounts have none.
+func accountAssetTrades(account string) map[string][]pkg.Trade {
+	if account != "" && account != pkg.AllAccounts {
+		if store := accountTradeStores[account]; store != nil {
+			return store.AssetTrades()
 		}
+		return nil
+	}
+	assetToTrades := make(map[string][]pkg.Trade)
+	for _, store := range accountTradeStores {
+		for symbol, trades := range store.AssetTrades() {
+			assetToTrades[symbol] = append(assetToTrades[symbol], trades...)
+		}
+	}
+	return assetToTrades
+}
 
-		balances, err = pkg.GetPortfolioBalancesAndCCData(currency, walletBalancesInMemory, assetToTradesInMemory)
+// loadTaxInput gathers the trades of every account, synced for the pairs
+// held and the pairs already in the trade stores (sold out or imported),
+// plus the deposits and withdrawals of every account since
+// config.TaxHistoryStart, or the first trade if that's earlier. Withdrawals
+// into another of the accounts are transfers, not disposals. Pairs that
+// couldn't be synced in full and missing capital history (e.g. a key
+// without SAPI permission) are reported as warnings, not errors.
+func loadTaxInput(ctx context.Context, currency string) (pkg.TaxInput, []string, error) {
+	var input pkg.TaxInput
+	var warnings []string
+	holdings := loadAccountsHoldings(ctx, currency)
+	if consolidated := pkg.ConsolidateHoldings(holdings); consolidated.Err != "" {
+		return input, warnings, errors.New(consolidated.Err)
+	}
+	accountToHoldings := make(map[string]pkg.AccountHoldings)
+	for _, entry := range holdings {
+		accountToHoldings[entry.Account] = entry
+		if entry.Err != "" && accountTradeStores[entry.Account] != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: trades of held assets may be missing, %s", entry.Account, entry.Err))
+		}
+	}
+	for _, account := range pkg.Accounts() {
+		store := accountTradeStores[account.Name]
+		if store == nil {
+			continue
+		}
+		// held pairs that failed with the holdings are tried again
+		pairSet := make(map[string]bool)
+		for pair := range store.AssetTrades() {
+			pairSet[pair] = true
+		}
+		for _, balance := range accountToHoldings[account.Name].Wallet {
+			if balance.Symbol != currency {
+				pairSet[balance.Symbol+currency] = true
+			}
+		}
+		pairs := make([]string, 0, len(pairSet))
+		for pair := range pairSet {
+			pairs = append(pairs, pair)
+		}
+		sort.Strings(pairs)
+		failures := pkg.SyncAccountPairs(ctx, account, pairs, store)
+		for _, pair := range pairs {
+			if err, ok := failures[pair]; ok {
+				warnings = append(warnings, fmt.Sprintf("%s: %s has only the stored trades, %v", account.Name, pair, err))
+			} else if store.Capped(pair) {
+				warnings = append(warnings, fmt.Sprintf("%s: %s is missing its latest trades, too many to fetch at once, sync again for the rest", account.Name, pair))
+			}
+		}
+	}
+	assetToTrades := accountAssetTrades(pkg.AllAccounts)
+	startTime := config.TaxHistoryStart.UnixMilli()
+	for _, trades := range assetToTrades {
+		for _, trade := range trades {
+			startTime = min(startTime, int64(trade.Time))
+		}
+		input.Trades = append(input.Trades, trades...)
+	}
+	now := time.Now().UnixMilli()
+	var histories []pkg.AccountCapitalHistory
+	for _, account := range pkg.Accounts() {
+		history := pkg.AccountCapitalHistory{Account: account.Name}
+		var err error
+		history.Deposits, err = pkg.GetDepositHistoryFor(ctx, account, startTime, now)
 		if err != nil {
-			return c.JSON(400, pkg.RESTResp[[]*pkg.PortfolioBalance]{Data: balances, Err: errors.New("error getting balances")})
+			warnings = append(warnings, fmt.Sprintf("%s: deposits not included: %v", account.Name, err))
 		}
-		return c.JSON(200, pkg.RESTResp[[]*pkg.PortfolioBalance]{Data: balances})
-	})
-	e.GET("/wallet", func(c echo.Context) error {
-		var balances []*pkg.WalletBalance
-		currency := "USDT"
-		if len(walletBalancesInMemory) != 0 {
-			log.Info("[getWalletBalancesAndCCData]: Getting from memory")
-			return c.JSON(200, pkg.RESTResp[[]*pkg.WalletBalance]{Data: walletBalancesInMemory})
-		}
-		balances, err := pkg.GetWalletBalancesAndCCData(currency)
-		walletBalancesInMemory = balances
+		history.Withdrawals, err = pkg.GetWithdrawalHistoryFor(ctx, account, startTime, now)
 		if err != nil {
-			return c.JSON(400, pkg.RESTResp[[]*pkg.WalletBalance]{Data: balances, Err: errors.New("error getting balances")})
+			warnings = append(warnings, fmt.Sprintf("%s: withdrawals not included: %v", account.Name, err))
 		}
-		return c.JSON(200, pkg.RESTResp[[]*pkg.WalletBalance]{Data: balances})
-	})
+		histories = append(histories, history)
+	}
+	input.Deposits, input.Withdrawals, input.Transfers = pkg.MatchInternalTransfers(histories)
+	return input, warnings, nil
+}
 
-	e.GET("/", func(c echo.Context) error {
-		return c.Render(200, "index", IndexPage{})
-	})
-	port := "42000"
-	e.Logger.Fatal(e.Start(fmt.Sprintf(":%s", port)))
+// loadStatement builds the statement of month of account, or of every
+// account for all, from its current balances, its trades and its deposits
+// and withdrawals since the start of the month, leaving out transfers
+// between the accounts.
+func loadStatement(ctx context.Context, currency string, account string, month time.Time) (pkg.PortfolioStatement, error) {
+	accounts, err := pkg.SelectAccounts(account)
+	if err != nil {
+		return pkg.PortfolioStatement{}, err
+	}
+	walletBalances, assetToTrades, err := loadAccountAssetTrades(ctx, currency, account)
+	if err != nil {
+		return pkg.PortfolioStatement{}, err
+	}
+	now := time.Now()
+	input := pkg.StatementInput{WalletBalances: walletBalances, AssetToTrades: assetToTrades}
+	var warnings []string
+	var histories []pkg.AccountCapitalHistory
+	for _, account := range accounts {
+		history := pkg.AccountCapitalHistory{Account: account.Name}
+		history.Deposits, err = pkg.GetDepositHistoryFor(ctx, account, month.UnixMilli(), now.UnixMilli())
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: deposits not included: %v", account.Name, err))
+		}
+		history.Withdrawals, err = pkg.GetWithdrawalHistoryFor(ctx, account, month.UnixMilli(), now.UnixMilli())
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: withdrawals not included: %v", account.Name, err))
+		}
+		histories = append(histories, history)
+	}
+	input.Deposits, input.Withdrawals, _ = pkg.MatchInternalTransfers(histories)
+	statement := pkg.BuildStatement(ctx, month, currency, input, pkg.NewHistoricalPricer(currency), now)
+	statement.Warnings = append(warnings, statement.Warnings...)
+	return statement, nil
 }
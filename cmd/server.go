@@ -0,0 +1,575 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"goland.local/binance-portfolio/pkg"
+)
+
+type Template struct {
+	tmpls *template.Template
+}
+
+func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	return t.tmpls.ExecuteTemplate(w, name, data)
+}
+
+type HeaderTr struct {
+	Name  string
+	Icon  string
+	Class string
+}
+
+type TableSection struct {
+	Header []HeaderTr
+}
+
+type IndexPage struct {
+	Email          string
+	ErrorMsgs      map[string]string
+	TableSection   TableSection
+	Currency       string
+	RefreshSeconds int
+}
+
+type OrderPrefill struct {
+	Side     string
+	Type     string
+	Quantity string
+	Price    string
+}
+
+type AssetPage struct {
+	Symbol   string
+	Currency string
+	ReadOnly bool
+	Balance  *pkg.WalletBalance
+	Prefill  OrderPrefill
+}
+
+func runServeCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	port := flags.String("port", "", "port to serve the dashboard on, overriding the port of server.address")
+	flags.Parse(args)
+	address := config.ServerAddress
+	if *port != "" {
+		host, _, _ := net.SplitHostPort(address)
+		address = net.JoinHostPort(host, *port)
+	}
+	if config.LocalhostOnly {
+		_, port, _ := net.SplitHostPort(address)
+		address = net.JoinHostPort("127.0.0.1", port)
+	}
+	users, err := pkg.OpenUserStore(config.UsersPath)
+	if err != nil {
+		log.Fatal("Error opening the users - ", err)
+	}
+
+	e := echo.New()
+
+	e.Renderer = &Template{
+		tmpls: template.Must(template.ParseGlob("views/*.html")),
+	}
+
+	// without users nobody could sign in, which is only safe when nobody
+	// else can reach the server either, and nothing can be traded
+	host, _, _ := net.SplitHostPort(address)
+	openWhenEmpty := isLoopback(host) && config.BinanceReadOnly
+	if users.Empty() {
+		switch {
+		case !isLoopback(host):
+			log.Fatalf("No users or API tokens in %s to protect the dashboard on %s: add a user with: users add <name>, or serve on localhost only (server.localhost_only)", config.UsersPath, address)
+		case !config.BinanceReadOnly:
+			log.Fatalf("No users or API tokens in %s to protect trading: add a user with: users add <name>, or keep binance.read_only", config.UsersPath)
+		}
+		log.Warnf("No users or API tokens in %s, anyone on this machine can use the dashboard and the APIs; add a user with: users add <name>", config.UsersPath)
+	}
+	registerAuth(e, users, openWhenEmpty)
+
+	e.Static("/src", "src")
+	registerAPI(e)
+
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		log.Fatal("Error building the GraphQL schema - ", err)
+	}
+	e.Match([]string{http.MethodGet, http.MethodPost}, "/graphql", graphQLHandler(schema))
+
+	e.GET("/orders", func(c echo.Context) error {
+		symbol := c.QueryParam("symbol")
+		limit := c.QueryParam("limit")
+		if strings.TrimSpace(symbol) == "" {
+			return c.JSON(400, map[string]interface{}{"Err": "strange input", "Data": nil})
+		}
+		if strings.TrimSpace(limit) == "" {
+			limit = strconv.Itoa(config.OrderFetchLimit)
+		}
+		data, err := pkg.GetAllOrders(c.Request().Context(), symbol, limit)
+		if err != nil {
+			log.Errorf("%s: Error fetching orders: %v", symbol, err)
+		}
+		if status := c.QueryParam("status"); status != "" {
+			data = pkg.FilterOrdersByStatus(data, strings.Split(strings.ToUpper(status), ",")...)
+		}
+		return c.JSON(200, data)
+	})
+
+	e.GET("/orders/analytics", func(c echo.Context) error {
+		currency := config.Currency
+		var symbols []string
+		if symbol := c.QueryParam("symbol"); strings.TrimSpace(symbol) != "" {
+			symbols = strings.Split(strings.ToUpper(symbol), ",")
+		} else {
+			walletBalances, err := cachedWalletBalances(c.Request().Context(), currency)
+			if err != nil {
+				return c.JSON(400, pkg.RESTResp[*pkg.OrderAnalytics]{Err: "error getting balances"})
+			}
+			for _, balance := range walletBalances {
+				if balance.Symbol != currency {
+					symbols = append(symbols, fmt.Sprintf("%s%s", balance.Symbol, currency))
+				}
+			}
+		}
+		var orders []pkg.Order
+		for _, symbol := range symbols {
+			symbolOrders, err := assetToOrdersInMemory.get(symbol, func() ([]pkg.Order, error) {
+				return pkg.GetAllOrders(c.Request().Context(), symbol, strconv.Itoa(config.OrderFetchLimit))
+			})
+			if err != nil {
+				log.Errorf("%s: Error fetching orders: %v", symbol, err)
+				continue
+			}
+			orders = append(orders, symbolOrders...)
+		}
+		analytics := pkg.AnalyseOrders(c.Request().Context(), orders)
+		return c.JSON(200, pkg.RESTResp[*pkg.OrderAnalytics]{Data: &analytics})
+	})
+
+	e.GET("/orders/analytics/view", func(c echo.Context) error {
+		return c.Render(200, "orders", nil)
+	})
+
+	e.GET("/trades", func(c echo.Context) error {
+		symbol := c.QueryParam("symbol")
+		limit := c.QueryParam("limit")
+		if strings.TrimSpace(symbol) == "" {
+			return c.JSON(400, map[string]interface{}{"Err": "strange input", "Data": nil})
+		}
+		if strings.TrimSpace(limit) == "" {
+			limit = strconv.Itoa(config.TradeFetchLimit)
+		}
+		data, err := pkg.GetTradesList(c.Request().Context(), symbol, limit)
+		if err != nil {
+			log.Errorf("%s: Error fetching trades: %v", symbol, err)
+		}
+		return c.JSON(200, data)
+	})
+
+	e.POST("/trades/import", func(c echo.Context) error {
+		form, err := c.MultipartForm()
+		if err != nil || len(form.File["file"]) == 0 {
+			return c.JSON(400, map[string]interface{}{"Err": "upload one or more export files as \"file\"", "Data": nil})
+		}
+		store, err := accountTradeStore(c.FormValue("account"))
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+		}
+		var results []pkg.ImportResult
+		for _, fileHeader := range form.File["file"] {
+			file, err := fileHeader.Open()
+			if err != nil {
+				return c.JSON(400, pkg.RESTResp[[]pkg.ImportResult]{Data: results, Err: err.Error()})
+			}
+			batch, err := pkg.ParseBinanceExport(fileHeader.Filename, file)
+			file.Close()
+			if err != nil {
+				return c.JSON(400, pkg.RESTResp[[]pkg.ImportResult]{Data: results, Err: err.Error()})
+			}
+			result, err := store.Import(batch)
+			result.File = fileHeader.Filename
+			results = append(results, result)
+			if err != nil {
+				return c.JSON(500, pkg.RESTResp[[]pkg.ImportResult]{Data: results, Err: err.Error()})
+			}
+			log.Infof("[importTrades]: %s: %d trades, %d ledger entries added", result.File, result.Trades, result.Ledger)
+		}
+		return c.JSON(200, pkg.RESTResp[[]pkg.ImportResult]{Data: results})
+	})
+
+	e.GET("/ledger", func(c echo.Context) error {
+		ledger, err := accountLedger(c.QueryParam("account"))
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[[]pkg.LedgerEntry]{Err: err.Error()})
+		}
+		return c.JSON(200, pkg.RESTResp[[]pkg.LedgerEntry]{Data: ledger})
+	})
+
+	// /ratelimit reports how much of the Binance rate limits is in use
+	e.GET("/ratelimit", func(c echo.Context) error {
+		return c.JSON(200, pkg.RESTResp[pkg.RateLimitStatus]{Data: pkg.GetRateLimitStatus()})
+	})
+
+	e.GET("/accounts", func(c echo.Context) error {
+		holdings := loadAccountsHoldings(c.Request().Context(), config.Currency)
+		all := pkg.ConsolidateHoldings(holdings)
+		return c.JSON(200, pkg.RESTResp[[]pkg.AccountHoldings]{Data: holdings, Warnings: all.Warnings, Freshness: all.Freshness})
+	})
+
+	// /portfolio and /wallet take ?account=<name>, <name>/<sub-account email>
+	// or all (the default) for every account merged by asset. Assets missing
+	// a price or trades are in Warnings, the rest of the data still holds
+	e.GET("/portfolio", func(c echo.Context) error {
+		holdings, err := pkg.SelectHoldings(loadAccountsHoldings(c.Request().Context(), config.Currency), c.QueryParam("account"))
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+		}
+		if holdings.Err != "" {
+			return c.JSON(400, pkg.RESTResp[[]*pkg.PortfolioBalance]{Data: holdings.Balances, Err: "error getting balances", Warnings: holdings.Warnings})
+		}
+		return c.JSON(200, pkg.RESTResp[[]*pkg.PortfolioBalance]{Data: holdings.Balances, Warnings: holdings.Warnings, Freshness: holdings.Freshness})
+	})
+	e.GET("/wallet", func(c echo.Context) error {
+		holdings, err := pkg.SelectHoldings(loadAccountsHoldings(c.Request().Context(), config.Currency), c.QueryParam("account"))
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+		}
+		if holdings.Err != "" {
+			return c.JSON(400, pkg.RESTResp[[]*pkg.WalletBalance]{Data: holdings.Wallet, Err: "error getting balances", Warnings: holdings.Warnings})
+		}
+		return c.JSON(200, pkg.RESTResp[[]*pkg.WalletBalance]{Data: holdings.Wallet, Warnings: holdings.Warnings, Freshness: holdings.Freshness})
+	})
+
+	e.GET("/benchmark", func(c echo.Context) error {
+		currency := config.Currency
+		benchmarks := c.QueryParams()["benchmark"]
+		if len(benchmarks) == 0 {
+			benchmarks = []string{"BTC", "ETH"}
+		}
+		var specs []pkg.BenchmarkSpec
+		for _, benchmark := range benchmarks {
+			spec, err := pkg.ParseBenchmarkSpec(c.Request().Context(), benchmark, currency)
+			if err != nil {
+				return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+			}
+			specs = append(specs, spec)
+		}
+		_, assetToTrades, err := loadAccountAssetTrades(c.Request().Context(), currency, c.QueryParam("account"))
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[*pkg.BenchmarkComparison]{Err: err.Error()})
+		}
+		comparison, err := pkg.CompareToBenchmarks(c.Request().Context(), currency, assetToTrades, specs)
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[*pkg.BenchmarkComparison]{Err: err.Error()})
+		}
+		return c.JSON(200, pkg.RESTResp[*pkg.BenchmarkComparison]{Data: &comparison})
+	})
+
+	e.GET("/rebalance", func(c echo.Context) error {
+		currency := config.Currency
+		targetsConfig := c.QueryParam("targets")
+		if strings.TrimSpace(targetsConfig) == "" {
+			targetsConfig = config.RebalanceTargets
+		}
+		targets, err := pkg.ParseAllocationTargets(targetsConfig)
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+		}
+		walletBalances, err := cachedWalletBalances(c.Request().Context(), currency)
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[*pkg.RebalancePlan]{Err: "error getting balances"})
+		}
+		plan, err := pkg.PlanRebalance(c.Request().Context(), currency, walletBalances, targets)
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[*pkg.RebalancePlan]{Err: err.Error()})
+		}
+		return c.JSON(200, pkg.RESTResp[*pkg.RebalancePlan]{Data: &plan})
+	})
+
+	e.GET("/rebalance/view", func(c echo.Context) error {
+		return c.Render(200, "rebalance", nil)
+	})
+
+	placeOrder := func(test bool) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var order pkg.OrderRequest
+			if err := c.Bind(&order); err != nil {
+				return c.JSON(400, map[string]interface{}{"Err": "strange input", "Data": nil})
+			}
+			result, err := pkg.PlaceOrder(c.Request().Context(), order, test)
+			if !test && err == nil {
+				// balances and orders moved, refetch them next time
+				walletBalancesInMemory.reset()
+				accountHoldingsInMemory.reset()
+				assetToOrdersInMemory.delete(order.Symbol)
+			}
+			if errors.Is(err, pkg.ErrReadOnly) {
+				return c.JSON(403, pkg.RESTResp[*pkg.OrderResponse]{Err: err.Error()})
+			}
+			if err != nil {
+				return c.JSON(400, pkg.RESTResp[*pkg.OrderResponse]{Err: err.Error()})
+			}
+			return c.JSON(200, pkg.RESTResp[*pkg.OrderResponse]{Data: &result})
+		}
+	}
+	e.POST("/order", placeOrder(false))
+	e.POST("/order/test", placeOrder(true))
+
+	e.GET("/openOrders", func(c echo.Context) error {
+		currency := config.Currency
+		asset := strings.ToUpper(c.QueryParam("asset"))
+		if strings.TrimSpace(asset) == "" {
+			return c.JSON(400, map[string]interface{}{"Err": "strange input", "Data": nil})
+		}
+		symbol := fmt.Sprintf("%s%s", asset, currency)
+		orders, err := pkg.GetOpenOrders(c.Request().Context(), symbol)
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[*pkg.OpenOrdersPanel]{Err: err.Error()})
+		}
+		price, err := pkg.GetCurrentTickerPrice(c.Request().Context(), symbol)
+		if err != nil {
+			log.Warnf("%s: no current price: %v", symbol, err)
+		}
+		walletBalances, _ := cachedWalletBalances(c.Request().Context(), currency)
+		panel := pkg.BuildOpenOrdersPanel(asset, currency, orders, price, walletBalances, time.Now())
+		return c.JSON(200, pkg.RESTResp[*pkg.OpenOrdersPanel]{Data: &panel})
+	})
+
+	e.DELETE("/openOrders", func(c echo.Context) error {
+		symbol := c.QueryParam("symbol")
+		if strings.TrimSpace(symbol) == "" {
+			return c.JSON(400, map[string]interface{}{"Err": "strange input", "Data": nil})
+		}
+		orders, err := pkg.CancelAllOpenOrders(c.Request().Context(), symbol)
+		if errors.Is(err, pkg.ErrReadOnly) {
+			return c.JSON(403, pkg.RESTResp[[]pkg.Order]{Err: err.Error()})
+		}
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[[]pkg.Order]{Err: err.Error()})
+		}
+		walletBalancesInMemory.reset()
+		accountHoldingsInMemory.reset()
+		assetToOrdersInMemory.delete(symbol)
+		return c.JSON(200, pkg.RESTResp[[]pkg.Order]{Data: orders})
+	})
+
+	e.DELETE("/order", func(c echo.Context) error {
+		symbol := c.QueryParam("symbol")
+		orderId, err := strconv.ParseInt(c.QueryParam("orderId"), 10, 64)
+		if strings.TrimSpace(symbol) == "" || err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": "strange input", "Data": nil})
+		}
+		order, err := pkg.CancelOrder(c.Request().Context(), symbol, orderId)
+		if errors.Is(err, pkg.ErrReadOnly) {
+			return c.JSON(403, pkg.RESTResp[*pkg.Order]{Err: err.Error()})
+		}
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[*pkg.Order]{Err: err.Error()})
+		}
+		walletBalancesInMemory.reset()
+		accountHoldingsInMemory.reset()
+		assetToOrdersInMemory.delete(symbol)
+		return c.JSON(200, pkg.RESTResp[*pkg.Order]{Data: &order})
+	})
+
+	e.GET("/asset/:symbol", func(c echo.Context) error {
+		currency := config.Currency
+		page := AssetPage{
+			Symbol:   strings.ToUpper(c.Param("symbol")),
+			Currency: currency,
+			ReadOnly: pkg.IsReadOnly(),
+			Prefill: OrderPrefill{
+				Side:     c.QueryParam("side"),
+				Type:     c.QueryParam("type"),
+				Quantity: c.QueryParam("quantity"),
+				Price:    c.QueryParam("price"),
+			},
+		}
+		walletBalances, _ := cachedWalletBalances(c.Request().Context(), currency)
+		for _, balance := range walletBalances {
+			if balance.Symbol == page.Symbol {
+				page.Balance = balance
+			}
+		}
+		return c.Render(200, "asset", page)
+	})
+
+	e.GET("/tax/uk", func(c echo.Context) error {
+		input, warnings, err := loadTaxInput(c.Request().Context(), config.Currency)
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[*pkg.UKCapitalGainsReport]{Err: "error getting trades"})
+		}
+		events, eventWarnings := pkg.BuildTaxEvents(c.Request().Context(), input, pkg.NewHistoricalPricer("GBP"))
+		report := pkg.CalculateUKCapitalGains(events)
+		report.Warnings = append(append(warnings, eventWarnings...), report.Warnings...)
+		taxYear := c.QueryParam("year")
+		if taxYear == "" {
+			return c.JSON(200, pkg.RESTResp[*pkg.UKCapitalGainsReport]{Data: &report})
+		}
+		yearReport, ok := report.Year(taxYear)
+		if !ok {
+			return c.JSON(404, pkg.RESTResp[*pkg.UKTaxYearReport]{Err: fmt.Sprintf("no disposals in tax year %s", taxYear)})
+		}
+		if c.QueryParam("format") == "csv" {
+			filename := fmt.Sprintf("uk-capital-gains-%s.csv", strings.ReplaceAll(taxYear, "/", "-"))
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+			c.Response().Header().Set(echo.HeaderContentType, "text/csv")
+			return yearReport.WriteCSV(c.Response())
+		}
+		return c.JSON(200, pkg.RESTResp[*pkg.UKTaxYearReport]{Data: &yearReport})
+	})
+
+	e.GET("/tax/uk/view", func(c echo.Context) error {
+		return c.Render(200, "tax-uk", nil)
+	})
+
+	e.GET("/tax/us", func(c echo.Context) error {
+		method, err := pkg.ParseLotMethod(c.QueryParam("method"))
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+		}
+		input, warnings, err := loadTaxInput(c.Request().Context(), config.Currency)
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[*pkg.USCapitalGainsReport]{Err: "error getting trades"})
+		}
+		events, eventWarnings := pkg.BuildTaxEvents(c.Request().Context(), input, pkg.NewHistoricalPricer("USD"))
+		report := pkg.CalculateUSCapitalGains(events, method)
+		report.Warnings = append(append(warnings, eventWarnings...), report.Warnings...)
+		if c.QueryParam("year") == "" {
+			return c.JSON(200, pkg.RESTResp[*pkg.USCapitalGainsReport]{Data: &report})
+		}
+		taxYear, err := strconv.Atoi(c.QueryParam("year"))
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": "strange input", "Data": nil})
+		}
+		yearReport, ok := report.Year(taxYear)
+		if !ok {
+			return c.JSON(404, pkg.RESTResp[*pkg.USTaxYearReport]{Err: fmt.Sprintf("no disposals in tax year %d", taxYear)})
+		}
+		switch format := c.QueryParam("format"); format {
+		case "8949", "schedule-d":
+			filename := fmt.Sprintf("form-%s-%d-%s.csv", format, taxYear, strings.ToLower(string(method)))
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+			c.Response().Header().Set(echo.HeaderContentType, "text/csv")
+			if format == "8949" {
+				return yearReport.Write8949CSV(c.Response())
+			}
+			return yearReport.WriteScheduleDCSV(c.Response())
+		}
+		return c.JSON(200, pkg.RESTResp[*pkg.USTaxYearReport]{Data: &yearReport})
+	})
+
+	e.GET("/tax/us/view", func(c echo.Context) error {
+		return c.Render(200, "tax-us", nil)
+	})
+
+	e.GET("/export/:dataset", func(c echo.Context) error {
+		currency := config.Currency
+		format, err := pkg.ParseExportFormat(c.QueryParam("format"))
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+		}
+		filter, err := parseExportFilter(c)
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+		}
+		var table pkg.ExportTable
+		switch c.Param("dataset") {
+		case "holdings":
+			walletBalances, err := cachedWalletBalances(c.Request().Context(), currency)
+			if err != nil {
+				return c.JSON(400, map[string]interface{}{"Err": "error getting balances", "Data": nil})
+			}
+			table = pkg.HoldingsTable(walletBalances, filter)
+		case "stats":
+			if _, err := loadAssetTrades(c.Request().Context(), currency); err != nil {
+				return c.JSON(400, map[string]interface{}{"Err": "error getting balances", "Data": nil})
+			}
+			walletBalances, _ := walletBalancesInMemory.cached()
+			balances, err := pkg.GetPortfolioBalancesAndCCData(c.Request().Context(), currency, walletBalances, tradeStore)
+			if err != nil {
+				return c.JSON(400, map[string]interface{}{"Err": "error getting balances", "Data": nil})
+			}
+			table = pkg.PortfolioStatsTable(balances, filter)
+		case "trades", "lots":
+			assetToTrades, err := loadAssetTrades(c.Request().Context(), currency)
+			if err != nil {
+				return c.JSON(400, map[string]interface{}{"Err": "error getting trades", "Data": nil})
+			}
+			if c.Param("dataset") == "trades" {
+				table = pkg.TradesTable(assetToTrades, filter)
+				break
+			}
+			method, err := pkg.ParseLotMethod(c.QueryParam("method"))
+			if err != nil {
+				return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+			}
+			table = pkg.RealizedLotsTable(pkg.RealizedPNLLots(assetToTrades, method), filter)
+		default:
+			return c.JSON(404, map[string]interface{}{"Err": "unknown dataset, use holdings, stats, trades or lots", "Data": nil})
+		}
+		filename := fmt.Sprintf("%s-%s.%s", table.Name, time.Now().Format("20060102"), format)
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+		c.Response().Header().Set(echo.HeaderContentType, format.ContentType())
+		return table.Write(c.Response(), format)
+	})
+
+	e.GET("/statement", func(c echo.Context) error {
+		currency := config.Currency
+		month, err := pkg.ParseStatementMonth(c.QueryParam("month"), time.Now())
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{"Err": err.Error(), "Data": nil})
+		}
+		statement, err := loadStatement(c.Request().Context(), currency, c.QueryParam("account"), month)
+		if err != nil {
+			return c.JSON(400, pkg.RESTResp[*pkg.PortfolioStatement]{Err: err.Error()})
+		}
+		if c.QueryParam("format") == "json" {
+			return c.JSON(200, pkg.RESTResp[*pkg.PortfolioStatement]{Data: &statement})
+		}
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("statement-%s.pdf", statement.Month)))
+		c.Response().Header().Set(echo.HeaderContentType, "application/pdf")
+		return statement.WritePDF(c.Response())
+	})
+
+	e.GET("/", func(c echo.Context) error {
+		return c.Render(200, "index", IndexPage{Currency: config.Currency, RefreshSeconds: int(config.DashboardRefresh.Seconds())})
+	})
+
+	// handlers run under serverCtx, so shutting down also abandons the
+	// Binance and CCData calls still in flight
+	serverCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	e.Server.BaseContext = func(net.Listener) context.Context { return serverCtx }
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- e.Start(address) }()
+	select {
+	case err := <-serveErr:
+		e.Logger.Fatal(err)
+	case <-ctx.Done():
+	}
+	log.Info("Shutting down")
+	cancelRequests()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Error("Error shutting down - ", err)
+	}
+}
+
+// parseExportFilter reads the asset=BTC,ETH and from/to (YYYY-MM-DD, both
+// inclusive, UTC) query params of the export endpoints.
+func parseExportFilter(c echo.Context) (pkg.ExportFilter, error) {
+	return pkg.NewExportFilter(c.QueryParam("asset"), c.QueryParam("from"), c.QueryParam("to"))
+}
@@ -0,0 +1,27 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("watch is only supported on Linux and macOS terminals")
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 80, 24, nil
+}
+
+// isTerminal is false so secrets are read as plain lines from stdin.
+func isTerminal(fd int) bool {
+	return false
+}
+
+func disableEcho(fd int) (func(), error) {
+	return nil, errors.New("not supported on this platform")
+}
+
+var resizeSignals []os.Signal
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"goland.local/binance-portfolio/pkg"
+)
+
+// newPassword asks for a password twice.
+func newPassword(prompt *secretPrompt) (string, error) {
+	password, err := prompt.read("Password")
+	if err != nil {
+		return "", err
+	}
+	repeated, err := prompt.read("Repeat password")
+	if err != nil {
+		return "", err
+	}
+	if password != repeated {
+		return "", errors.New("passwords don't match")
+	}
+	return password, nil
+}
+
+func openUserStore() *pkg.UserStore {
+	users, err := pkg.OpenUserStore(config.UsersPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return users
+}
+
+func printUsersUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: portfolio users <command>
+
+Manages who can sign in to the dashboard (%s).
+
+Commands:
+  list             names of the users
+  add <name>       add a user, asking for the password
+  passwd <name>    change the password of a user
+  remove <name>    delete a user, signing them out
+`, config.UsersPath)
+}
+
+func runUsersCommand(args []string) {
+	if len(args) == 0 {
+		printUsersUsage()
+		os.Exit(2)
+	}
+	users := openUserStore()
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("users list", flag.ExitOnError)
+		jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+		flags.Parse(args[1:])
+		type userInfo struct {
+			Name      string    `json:"name"`
+			CreatedAt time.Time `json:"created_at"`
+		}
+		var list []userInfo
+		table := pkg.ExportTable{Columns: []string{"name", "created_at"}}
+		for _, user := range users.Users() {
+			list = append(list, userInfo{user.Name, user.CreatedAt})
+			table.Rows = append(table.Rows, []interface{}{user.Name, user.CreatedAt})
+		}
+		if *jsonOutput {
+			printJSON(list)
+			return
+		}
+		printTable(os.Stdout, table)
+	case "add", "passwd":
+		if len(args) != 2 {
+			printUsersUsage()
+			os.Exit(2)
+		}
+		exists := users.HasUser(args[1])
+		if args[0] == "add" && exists {
+			log.Fatalf("%s already exists, change the password with: users passwd %s", args[1], args[1])
+		}
+		if args[0] == "passwd" && !exists {
+			log.Fatalf("No user named %s in %s", args[1], users.Path())
+		}
+		password, err := newPassword(newSecretPrompt())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := users.SetPassword(args[1], password); err != nil {
+			log.Fatal(err)
+		}
+		if err := users.Save(); err != nil {
+			log.Fatal(err)
+		}
+		if args[0] == "add" {
+			fmt.Printf("Added %s to %s\n", args[1], users.Path())
+		} else {
+			fmt.Printf("Changed the password of %s\n", args[1])
+		}
+	case "remove":
+		if len(args) != 2 {
+			printUsersUsage()
+			os.Exit(2)
+		}
+		if !users.RemoveUser(args[1]) {
+			log.Fatalf("No user named %s in %s", args[1], users.Path())
+		}
+		if err := users.Save(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Removed %s from %s\n", args[1], users.Path())
+	default:
+		printUsersUsage()
+		os.Exit(2)
+	}
+}
+
+func printTokensUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: portfolio tokens <command>
+
+Manages the bearer tokens of the JSON APIs (%s).
+
+Commands:
+  list                               names and scopes of the tokens
+  add [-scopes read,trade] <name>    create a token, printed once; read (the default) for
+                                     /api/v1, /graphql and the dashboard's GET routes,
+                                     trade for placing and cancelling orders and importing trades
+  remove <name>                      revoke a token, at once for a running serve
+`, config.UsersPath)
+}
+
+func runTokensCommand(args []string) {
+	if len(args) == 0 {
+		printTokensUsage()
+		os.Exit(2)
+	}
+	users := openUserStore()
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("tokens list", flag.ExitOnError)
+		jsonOutput := flags.Bool("json", false, "print JSON instead of a table")
+		flags.Parse(args[1:])
+		type tokenInfo struct {
+			Name      string    `json:"name"`
+			Scopes    []string  `json:"scopes"`
+			CreatedAt time.Time `json:"created_at"`
+		}
+		var list []tokenInfo
+		table := pkg.ExportTable{Columns: []string{"name", "scopes", "created_at"}}
+		for _, token := range users.Tokens() {
+			list = append(list, tokenInfo{token.Name, token.Scopes, token.CreatedAt})
+			table.Rows = append(table.Rows, []interface{}{token.Name, strings.Join(token.Scopes, ","), token.CreatedAt})
+		}
+		if *jsonOutput {
+			printJSON(list)
+			return
+		}
+		printTable(os.Stdout, table)
+	case "add":
+		flags := flag.NewFlagSet("tokens add", flag.ExitOnError)
+		scopesFlag := flags.String("scopes", pkg.ScopeRead, "comma-separated scopes: read, trade")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			printTokensUsage()
+			os.Exit(2)
+		}
+		scopes, err := pkg.ParseScopes(*scopesFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		token, err := users.AddToken(flags.Arg(0), scopes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := users.Save(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "Added %s (%s) to %s. Keep the token, it isn't shown again:\n", flags.Arg(0), strings.Join(scopes, ","), users.Path())
+		fmt.Println(token)
+	case "remove":
+		if len(args) != 2 {
+			printTokensUsage()
+			os.Exit(2)
+		}
+		if !users.RemoveToken(args[1]) {
+			log.Fatalf("No token named %s in %s", args[1], users.Path())
+		}
+		if err := users.Save(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Removed %s from %s\n", args[1], users.Path())
+	default:
+		printTokensUsage()
+		os.Exit(2)
+	}
+}
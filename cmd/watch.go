@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"goland.local/binance-portfolio/pkg"
+)
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiDim     = "\x1b[2m"
+	ansiReverse = "\x1b[7m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	// ansiFg resets the foreground only, so a colored cell doesn't end the
+	// reverse video of the selected row
+	ansiFg = "\x1b[39m"
+)
+
+type watchColumn struct {
+	title string
+	width int
+	// sortValue orders the rows by the column; nil sorts by asset name
+	sortValue func(balance *pkg.PortfolioBalance) float64
+	cell      func(balance *pkg.PortfolioBalance) (string, string)
+}
+
+func signColor(value float64) string {
+	switch {
+	case value > 0:
+		return ansiGreen
+	case value < 0:
+		return ansiRed
+	}
+	return ""
+}
+
+func priceFlagColor(flag string) string {
+	switch flag {
+	case "UP":
+		return ansiGreen
+	case "DOWN":
+		return ansiRed
+	}
+	return ""
+}
+
+func formatQty(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+var watchColumns = []watchColumn{
+	{"ASSET", 8, nil, func(b *pkg.PortfolioBalance) (string, string) {
+		return b.Symbol, ansiBold
+	}},
+	{"QTY", 16, func(b *pkg.PortfolioBalance) float64 { return b.Free + b.Locked }, func(b *pkg.PortfolioBalance) (string, string) {
+		return formatQty(b.Free + b.Locked), ""
+	}},
+	{"PRICE", 14, func(b *pkg.PortfolioBalance) float64 { return b.Price }, func(b *pkg.PortfolioBalance) (string, string) {
+		return formatQty(b.Price), priceFlagColor(b.PriceFlag)
+	}},
+	{"24H %", 8, func(b *pkg.PortfolioBalance) float64 { return b.PriceChangePercent }, func(b *pkg.PortfolioBalance) (string, string) {
+		return fmt.Sprintf("%+.2f", b.PriceChangePercent), signColor(b.PriceChangePercent)
+	}},
+	{"VALUE", 12, func(b *pkg.PortfolioBalance) float64 { return b.TradeStats.TotalValue }, func(b *pkg.PortfolioBalance) (string, string) {
+		return fmt.Sprintf("%.2f", b.TradeStats.TotalValue), ""
+	}},
+	{"AVG BUY", 14, func(b *pkg.PortfolioBalance) float64 { return b.TradeStats.AvgBuyPrice }, func(b *pkg.PortfolioBalance) (string, string) {
+		return formatQty(b.TradeStats.AvgBuyPrice), ""
+	}},
+	{"UNREALIZED", 12, func(b *pkg.PortfolioBalance) float64 { return b.TradeStats.UnrealizedPNL }, func(b *pkg.PortfolioBalance) (string, string) {
+		return fmt.Sprintf("%.2f", b.TradeStats.UnrealizedPNL), signColor(b.TradeStats.UnrealizedPNL)
+	}},
+	{"REALIZED", 12, func(b *pkg.PortfolioBalance) float64 { return b.TradeStats.RealizedPNL }, func(b *pkg.PortfolioBalance) (string, string) {
+		return fmt.Sprintf("%.2f", b.TradeStats.RealizedPNL), signColor(b.TradeStats.RealizedPNL)
+	}},
+	{"ALLOC %", 8, func(b *pkg.PortfolioBalance) float64 { return b.TradeStats.PortfolioAllocation }, func(b *pkg.PortfolioBalance) (string, string) {
+		return fmt.Sprintf("%.2f", b.TradeStats.PortfolioAllocation), ""
+	}},
+}
+
+// tradeColumns are the columns of pkg.TradesTable shown in the drilldown.
+var tradeColumns = []struct {
+	title string
+	width int
+	index int
+}{
+	{"TIME", 19, 0}, {"SYMBOL", 10, 1}, {"SIDE", 4, 4}, {"PRICE", 14, 5}, {"QTY", 14, 6}, {"QUOTE QTY", 14, 7}, {"FEE", 12, 8}, {"", 6, 9}, {"SOURCE", 6, 13},
+}
+
+// pad fits text in width, right-aligned unless left is set.
+func pad(text string, width int, left bool) string {
+	if len(text) > width {
+		return text[:width]
+	}
+	if left {
+		return text + strings.Repeat(" ", width-len(text))
+	}
+	return strings.Repeat(" ", width-len(text)) + text
+}
+
+type watchSnapshot struct {
+	balances []*pkg.PortfolioBalance
+	err      error
+	at       time.Time
+}
+
+// watchView is the state of the watch screen. It is only touched from the
+// loop in runWatchCommand, refreshes hand their results over a channel.
+type watchView struct {
+	currency   string
+	account    string
+	interval   time.Duration
+	balances   []*pkg.PortfolioBalance
+	updated    time.Time
+	err        error
+	refreshing bool
+	sortColumn int
+	ascending  bool
+	selected   int
+	offset     int
+	// asset is the holding drilled into, empty on the holdings table
+	asset       string
+	trades      pkg.ExportTable
+	tradeOffset int
+	width       int
+	height      int
+}
+
+func (v *watchView) selectedSymbol() string {
+	if v.selected < len(v.balances) {
+		return v.balances[v.selected].Symbol
+	}
+	return ""
+}
+
+func (v *watchView) sort() {
+	symbol := v.selectedSymbol()
+	column := watchColumns[v.sortColumn]
+	sort.SliceStable(v.balances, func(i, j int) bool {
+		a, b := v.balances[i], v.balances[j]
+		if column.sortValue == nil {
+			if v.ascending {
+				return a.Symbol < b.Symbol
+			}
+			return a.Symbol > b.Symbol
+		}
+		if v.ascending {
+			return column.sortValue(a) < column.sortValue(b)
+		}
+		return column.sortValue(a) > column.sortValue(b)
+	})
+	v.selected = 0
+	for i, balance := range v.balances {
+		if balance.Symbol == symbol {
+			v.selected = i
+		}
+	}
+}
+
+func (v *watchView) apply(snapshot watchSnapshot) {
+	v.refreshing = false
+	v.err = snapshot.err
+	if snapshot.err != nil && len(snapshot.balances) == 0 {
+		return
+	}
+	// the selection follows the asset, or starts at the top
+	symbol := v.selectedSymbol()
+	v.balances = snapshot.balances
+	v.updated = snapshot.at
+	v.sort()
+	v.selected = 0
+	for i, balance := range v.balances {
+		if balance.Symbol == symbol {
+			v.selected = i
+		}
+	}
+	if v.asset != "" {
+		v.loadTrades()
+	}
+}
+
+// loadTrades reads the trades of the drilled-into asset from the store,
+// newest first.
+func (v *watchView) loadTrades() {
+	filter, _ := pkg.NewExportFilter(v.asset, "", "")
+	v.trades = pkg.TradesTable(accountAssetTrades(v.account), filter)
+	for i, j := 0, len(v.trades.Rows)-1; i < j; i, j = i+1, j-1 {
+		v.trades.Rows[i], v.trades.Rows[j] = v.trades.Rows[j], v.trades.Rows[i]
+	}
+}
+
+// pageSize is the number of table rows below the title and header and
+// above the status and help lines.
+func (v *watchView) pageSize() int {
+	return max(v.height-5, 1)
+}
+
+func (v *watchView) handleKey(key string) {
+	rows := len(v.balances)
+	position := &v.selected
+	if v.asset != "" {
+		rows = len(v.trades.Rows)
+		position = &v.tradeOffset
+	}
+	switch key {
+	case "\x1b[A", "\x1bOA", "k":
+		*position--
+	case "\x1b[B", "\x1bOB", "j":
+		*position++
+	case "\x1b[5~":
+		*position -= v.pageSize()
+	case "\x1b[6~", " ":
+		*position += v.pageSize()
+	case "\x1b[H", "\x1bOH", "g":
+		*position = 0
+	case "\x1b[F", "\x1bOF", "G":
+		*position = rows - 1
+	case "\r", "\n", "\x1b[C", "\x1bOC", "l":
+		if v.asset == "" && v.selected < len(v.balances) {
+			v.asset = v.selectedSymbol()
+			v.tradeOffset = 0
+			v.loadTrades()
+		}
+	case "\x1b", "\x7f", "\x1b[D", "\x1bOD", "h":
+		v.asset = ""
+	case "s":
+		v.sortColumn = (v.sortColumn + 1) % len(watchColumns)
+		v.sort()
+	case "S":
+		v.ascending = !v.ascending
+		v.sort()
+	default:
+		if len(key) == 1 && key[0] >= '1' && int(key[0]-'1') < len(watchColumns) {
+			if column := int(key[0] - '1'); column == v.sortColumn {
+				v.ascending = !v.ascending
+			} else {
+				v.sortColumn = column
+				v.ascending = watchColumns[column].sortValue == nil
+			}
+			v.sort()
+		}
+	}
+	*position = max(min(*position, rows-1), 0)
+}
+
+func (v *watchView) status(now time.Time) string {
+	switch {
+	case v.refreshing:
+		return "refreshing..."
+	case v.updated.IsZero():
+		return "loading..."
+	}
+	next := v.interval - now.Sub(v.updated)
+	return fmt.Sprintf("updated %s, next refresh in %ds", v.updated.Format(time.TimeOnly), max(int(next.Seconds()), 0))
+}
+
+func (v *watchView) render(w io.Writer, now time.Time) {
+	var lines []string
+	if v.asset == "" {
+		lines = v.renderHoldings()
+	} else {
+		lines = v.renderTrades()
+	}
+	for len(lines) < v.height-2 {
+		lines = append(lines, "")
+	}
+	lines = lines[:max(v.height-2, 0)]
+	if v.err != nil {
+		lines = append(lines, ansiRed+pad(v.err.Error(), v.width, true)+ansiReset)
+	} else {
+		lines = append(lines, ansiDim+pad(v.status(now), v.width, true)+ansiReset)
+	}
+	help := "up/down select  enter trades  1-9 sort  S reverse  r refresh  q quit"
+	if v.asset != "" {
+		help = "up/down scroll  esc back  r refresh  q quit"
+	}
+	lines = append(lines, ansiReverse+pad(help, v.width, true)+ansiReset)
+
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	for i, line := range lines {
+		screen.WriteString(line)
+		screen.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			screen.WriteString("\r\n")
+		}
+	}
+	io.WriteString(w, screen.String())
+}
+
+// fittingColumns is how many of the widths, plus a space each, fit in the
+// terminal.
+func (v *watchView) fittingColumns(widths []int) int {
+	used := 0
+	for i, width := range widths {
+		if used += width + 1; used > v.width {
+			return max(i, 1)
+		}
+	}
+	return len(widths)
+}
+
+func (v *watchView) renderHoldings() []string {
+	var totalValue, dailyPNL, unrealized, realized float64
+	for _, balance := range v.balances {
+		totalValue += balance.TradeStats.TotalValue
+		dailyPNL += balance.TradeStats.DailyPNL
+		unrealized += balance.TradeStats.UnrealizedPNL
+		realized += balance.TradeStats.RealizedPNL
+	}
+	lines := []string{
+		fmt.Sprintf("%sPortfolio%s  value %s%.2f %s%s  24h %s%+.2f%s  unrealized %s%+.2f%s  realized %s%+.2f%s",
+			ansiBold, ansiReset, ansiBold, totalValue, v.currency, ansiReset,
+			signColor(dailyPNL), dailyPNL, ansiReset, signColor(unrealized), unrealized, ansiReset, signColor(realized), realized, ansiReset),
+		"",
+	}
+
+	var widths []int
+	for _, column := range watchColumns {
+		widths = append(widths, column.width)
+	}
+	columns := watchColumns[:v.fittingColumns(widths)]
+	var header strings.Builder
+	for i, column := range columns {
+		title := column.title
+		if i == v.sortColumn {
+			arrow := "v"
+			if v.ascending {
+				arrow = "^"
+			}
+			title = arrow + title
+		}
+		header.WriteString(pad(title, column.width, i == 0) + " ")
+	}
+	lines = append(lines, ansiBold+header.String()+ansiReset)
+
+	page := v.pageSize()
+	if v.selected < v.offset {
+		v.offset = v.selected
+	}
+	if v.selected >= v.offset+page {
+		v.offset = v.selected - page + 1
+	}
+	v.offset = max(min(v.offset, len(v.balances)-page), 0)
+	for i := v.offset; i < len(v.balances) && i < v.offset+page; i++ {
+		var row strings.Builder
+		if i == v.selected {
+			row.WriteString(ansiReverse)
+		}
+		for j, column := range columns {
+			text, color := column.cell(v.balances[i])
+			text = pad(text, column.width, j == 0)
+			if color != "" {
+				text = color + text + ansiFg + "\x1b[22m"
+			}
+			row.WriteString(text + " ")
+		}
+		row.WriteString(ansiReset)
+		lines = append(lines, row.String())
+	}
+	if len(v.balances) == 0 && !v.updated.IsZero() {
+		lines = append(lines, ansiDim+"no holdings"+ansiReset)
+	}
+	return lines
+}
+
+func (v *watchView) renderTrades() []string {
+	var balance *pkg.PortfolioBalance
+	for _, b := range v.balances {
+		if b.Symbol == v.asset {
+			balance = b
+		}
+	}
+	title := fmt.Sprintf("%s%s%s  %d trades", ansiBold, v.asset, ansiReset, len(v.trades.Rows))
+	if balance != nil {
+		stats := balance.TradeStats
+		title += fmt.Sprintf("  qty %s  price %s%s%s  avg buy %s  unrealized %s%+.2f%s  realized %s%+.2f%s",
+			formatQty(balance.Free+balance.Locked), priceFlagColor(balance.PriceFlag), formatQty(balance.Price), ansiReset,
+			formatQty(stats.AvgBuyPrice), signColor(stats.UnrealizedPNL), stats.UnrealizedPNL, ansiReset, signColor(stats.RealizedPNL), stats.RealizedPNL, ansiReset)
+	}
+	lines := []string{title, ""}
+
+	var widths []int
+	for _, column := range tradeColumns {
+		widths = append(widths, column.width)
+	}
+	columns := tradeColumns[:v.fittingColumns(widths)]
+	var header strings.Builder
+	for i, column := range columns {
+		header.WriteString(pad(column.title, column.width, i < 3) + " ")
+	}
+	lines = append(lines, ansiBold+header.String()+ansiReset)
+
+	page := v.pageSize()
+	v.tradeOffset = max(min(v.tradeOffset, len(v.trades.Rows)-page), 0)
+	for _, values := range v.trades.Rows[v.tradeOffset:min(v.tradeOffset+page, len(v.trades.Rows))] {
+		var row strings.Builder
+		for i, column := range columns {
+			text := formatCell(values[column.index])
+			if column.index == 9 {
+				text = " " + text
+			}
+			text = pad(text, column.width, i < 3 || column.index == 9)
+			if column.index == 4 {
+				color := ansiRed
+				if values[4] == "BUY" {
+					color = ansiGreen
+				}
+				text = color + text + ansiFg
+			}
+			row.WriteString(text + " ")
+		}
+		lines = append(lines, row.String())
+	}
+	if len(v.trades.Rows) == 0 {
+		lines = append(lines, ansiDim+"no trades in the store for "+v.asset+", run sync or import the trade history"+ansiReset)
+	}
+	return lines
+}
+
+func (v *watchView) resize(fd int) {
+	width, height, err := terminalSize(fd)
+	if err != nil || width == 0 || height == 0 {
+		width, height = 80, 24
+	}
+	v.width, v.height = width, height
+}
+
+// runWatchCommand shows the holdings in a full-screen table that refreshes
+// balances and prices every -interval.
+func runWatchCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", config.WatchRefresh, "how often to refresh balances and prices")
+	account := flags.String("account", pkg.AllAccounts, "account name, name/sub-account email, or all")
+	flags.Parse(args)
+	if *interval < time.Second {
+		log.Fatal("-interval must be at least 1s")
+	}
+
+	fd := int(os.Stdin.Fd())
+	restore, err := makeRaw(fd)
+	if err != nil {
+		log.Fatal("watch needs an interactive terminal - ", err)
+	}
+	// anything logged would tear through the screen, errors go to the status
+	// line instead
+	log.SetOutput(io.Discard)
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l\x1b[2J")
+	defer func() {
+		os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+		restore()
+	}()
+
+	view := &watchView{currency: config.Currency, account: *account, interval: *interval, sortColumn: 4}
+	view.resize(fd)
+
+	// quitting abandons a refresh still fetching trades
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan watchSnapshot, 1)
+	refresh := func() {
+		if view.refreshing {
+			return
+		}
+		view.refreshing = true
+		go func() {
+			holdings, err := pkg.SelectHoldings(pkg.GetAccountsHoldings(ctx, view.currency, accountTradeStores), view.account)
+			if err == nil && holdings.Err != "" {
+				err = errors.New(holdings.Err)
+			} else if err == nil && holdings.Partial() {
+				var problems []string
+				for _, warning := range holdings.Warnings {
+					if warning.Level != pkg.LevelInfo {
+						problems = append(problems, warning.String())
+					}
+				}
+				err = errors.New(strings.Join(problems, "; "))
+			}
+			results <- watchSnapshot{balances: holdings.Balances, err: err, at: time.Now()}
+		}()
+	}
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, resizeSignals...)...)
+	defer signal.Stop(signals)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	clock := time.NewTicker(time.Second)
+	defer clock.Stop()
+
+	refresh()
+	for {
+		view.render(os.Stdout, time.Now())
+		select {
+		case key, ok := <-keys:
+			switch {
+			case !ok, key == "q", key == "\x03":
+				return
+			case key == "r":
+				refresh()
+			default:
+				view.handleKey(key)
+			}
+		case snapshot := <-results:
+			view.apply(snapshot)
+		case <-ticker.C:
+			refresh()
+		case <-clock.C:
+		case sig := <-signals:
+			if sig == os.Interrupt || sig == syscall.SIGTERM {
+				return
+			}
+			view.resize(fd)
+			os.Stdout.WriteString("\x1b[2J")
+		}
+	}
+}
+
+// readKeys splits terminal input into keys, keeping escape sequences such as
+// the arrow keys ("\x1b[A") together.
+func readKeys(r io.Reader, keys chan<- string) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		input := string(buf[:n])
+		for input != "" {
+			key := input[:1]
+			if len(input) > 2 && (strings.HasPrefix(input, "\x1b[") || strings.HasPrefix(input, "\x1bO")) {
+				end := 2
+				for end < len(input)-1 && (input[end] < 0x40 || input[end] > 0x7e) {
+					end++
+				}
+				key = input[:end+1]
+			}
+			keys <- key
+			input = input[len(key):]
+		}
+	}
+}
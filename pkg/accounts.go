@@ -0,0 +1,324 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MainAccount is the name of the account configured with binance.api_key,
+// the one signed requests that don't name an account use.
+const MainAccount = "main"
+
+// AllAccounts selects the consolidated view of every account.
+const AllAccounts = "all"
+
+// Account is a set of Binance API credentials, see Signer. SubAccounts are emails of
+// sub-accounts whose balances are read with the master account's keys
+// through the SAPI sub-account endpoints.
+type Account struct {
+	Name        string   `json:"name"`
+	APIKey      string   `json:"-"`
+	SecretKey   string   `json:"-"`
+	SubAccounts []string `json:"sub_accounts,omitempty"`
+	// PrivateKey is the PEM private key of an RSA or Ed25519 API key, used
+	// instead of SecretKey
+	PrivateKey string `json:"-"`
+}
+
+// DefaultAccount is the main account.
+func DefaultAccount() Account {
+	if len(config.Accounts) > 0 {
+		return config.Accounts[0]
+	}
+	return Account{Name: MainAccount, APIKey: config.BinanceAPIKey, SecretKey: config.BinanceSecretKey, PrivateKey: config.BinancePrivateKey}
+}
+
+// Accounts returns the configured accounts, the main one first.
+func Accounts() []Account {
+	if len(config.Accounts) > 0 {
+		return config.Accounts
+	}
+	return []Account{DefaultAccount()}
+}
+
+// AccountTradeStorePath is where the trades of account are kept: path itself
+// for the main account, "trades-store.<name>.json" next to it for others.
+func AccountTradeStorePath(path string, account string) string {
+	if account == MainAccount {
+		return path
+	}
+	extension := filepath.Ext(path)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(path, extension), account, extension)
+}
+
+// flexFloat decodes a JSON number or a number in a string; the sub-account
+// endpoints have used both over their versions.
+type flexFloat float64
+
+func (f *flexFloat) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseFloat(strings.Trim(string(data), `"`), 64)
+	*f = flexFloat(value)
+	return err
+}
+
+// GetSubAccountBalances returns the spot balances of the sub-account email
+// of master.
+func GetSubAccountBalances(ctx context.Context, master Account, email string) ([]Balance, error) {
+	params := neturl.Values{}
+	params.Set("email", email)
+	body, err := doSignedRequestFor(ctx, master, http.MethodGet, "/sapi/v4/sub-account/assets", params)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Balances []struct {
+			Asset  string    `json:"asset"`
+			Free   flexFloat `json:"free"`
+			Locked flexFloat `json:"locked"`
+		} `json:"balances"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	var balances []Balance
+	for _, balance := range result.Balances {
+		if balance.Free == 0 && balance.Locked == 0 {
+			continue
+		}
+		balances = append(balances, Balance{Asset: balance.Asset, Free: float64(balance.Free), Locked: float64(balance.Locked)})
+	}
+	return balances, nil
+}
+
+// AccountHoldings are the balances and PNL of one account or sub-account.
+type AccountHoldings struct {
+	Account       string              `json:"account"`
+	Master        string              `json:"master,omitempty"`
+	SubAccount    string              `json:"sub_account,omitempty"`
+	TotalValue    float64             `json:"total_value"`
+	DailyPNL      float64             `json:"daily_pnl"`
+	UnrealizedPNL float64             `json:"unrealized_pnl"`
+	RealizedPNL   float64             `json:"realized_pnl"`
+	Balances      []*PortfolioBalance `json:"balances"`
+	Wallet        []*WalletBalance    `json:"-"`
+	Report
+	Err string `json:"error,omitempty"`
+}
+
+func (h *AccountHoldings) total() {
+	h.TotalValue, h.DailyPNL, h.UnrealizedPNL, h.RealizedPNL = 0, 0, 0, 0
+	for _, balance := range h.Balances {
+		h.TotalValue += balance.TradeStats.TotalValue
+		h.DailyPNL += balance.TradeStats.DailyPNL
+		h.UnrealizedPNL += balance.TradeStats.UnrealizedPNL
+		h.RealizedPNL += balance.TradeStats.RealizedPNL
+	}
+}
+
+// GetAccountsHoldings fetches every account and sub-account independently:
+// one failing is reported on its entry and doesn't hide the others. The
+// prices and the trades of an account are fetched at the same time; assets
+// whose price or trades failed are warnings on the entry. tradeStores holds
+// the store of each account by name.
+func GetAccountsHoldings(ctx context.Context, currency string, tradeStores map[string]*TradeStore) []AccountHoldings {
+	var holdings []AccountHoldings
+	for _, account := range Accounts() {
+		entry := AccountHoldings{Account: account.Name}
+		balances, err := GetAccountBalancesFor(ctx, account)
+		if err == nil {
+			entry.fetched(SourceBalances, time.Now())
+			assets := make([]string, len(balances))
+			for i, balance := range balances {
+				assets[i] = balance.Asset
+			}
+			failures := make(chan map[string]error, 1)
+			go func() {
+				failures <- SyncAccountTrades(ctx, currency, account, assets, tradeStores[account.Name])
+			}()
+			var pricing Report
+			entry.Wallet, pricing, err = PriceBalances(ctx, currency, balances)
+			tradeFailures := <-failures
+			if err == nil {
+				var trades Report
+				entry.Balances, trades = buildPortfolioBalances(currency, entry.Wallet, tradeStores[account.Name], tradeFailures)
+				entry.merge("", pricing)
+				entry.merge("", trades)
+			}
+		}
+		if err != nil {
+			entry.Err = err.Error()
+		}
+		entry.total()
+		holdings = append(holdings, entry)
+
+		for _, email := range account.SubAccounts {
+			entry := AccountHoldings{Account: account.Name + "/" + email, Master: account.Name, SubAccount: email}
+			balances, err := GetSubAccountBalances(ctx, account, email)
+			var pricing Report
+			if err == nil {
+				entry.fetched(SourceBalances, time.Now())
+				entry.Wallet, pricing, err = PriceBalances(ctx, currency, balances)
+			}
+			if err == nil {
+				entry.Balances, _ = GetAccountPortfolioBalances(ctx, currency, account, entry.Wallet, nil)
+				entry.merge("", pricing)
+				entry.warn("", LevelInfo, "no trade history for sub-accounts, add it as its own account with its API key for PNL")
+			}
+			if err != nil {
+				entry.Err = err.Error()
+			}
+			entry.total()
+			holdings = append(holdings, entry)
+		}
+	}
+	return holdings
+}
+
+// SelectAccounts returns the account named account, or every account for
+// all or "". Sub-accounts ("master/email") are refused: a call signed with
+// the master's key answers for the master.
+func SelectAccounts(account string) ([]Account, error) {
+	accounts := Accounts()
+	if account == "" || account == AllAccounts {
+		return accounts, nil
+	}
+	var names []string
+	for _, candidate := range accounts {
+		if candidate.Name == account {
+			return []Account{candidate}, nil
+		}
+		for _, email := range candidate.SubAccounts {
+			if account == candidate.Name+"/"+email {
+				return nil, fmt.Errorf("%s is a sub-account, add it as its own account with its API key for this", account)
+			}
+		}
+		names = append(names, candidate.Name)
+	}
+	return nil, fmt.Errorf("unknown account %q, use %s or one of %s", account, AllAccounts, strings.Join(names, ", "))
+}
+
+// SelectHoldings returns the holdings of the account (or "master/email"
+// sub-account) named account, or of every account merged by asset for "all".
+func SelectHoldings(holdings []AccountHoldings, account string) (AccountHoldings, error) {
+	if account == "" || account == AllAccounts {
+		return ConsolidateHoldings(holdings), nil
+	}
+	var names []string
+	for _, entry := range holdings {
+		if entry.Account == account {
+			return entry, nil
+		}
+		names = append(names, entry.Account)
+	}
+	return AccountHoldings{}, fmt.Errorf("unknown account %q, use %s or one of %s", account, AllAccounts, strings.Join(names, ", "))
+}
+
+// ConsolidateHoldings merges the balances of every account by asset. The
+// average buy price is weighted by the quantity held in each account.
+// Accounts that failed are left out with an error warning; if all of them
+// did the result is an error. The warnings of the others are kept, with
+// their account, and the oldest fetch time of each source.
+func ConsolidateHoldings(holdings []AccountHoldings) AccountHoldings {
+	consolidated := AccountHoldings{Account: AllAccounts}
+	symbolToBalance := make(map[string]*PortfolioBalance)
+	symbolToWallet := make(map[string]*WalletBalance)
+	symbolToCost := make(map[string]float64)
+	symbolToCostQty := make(map[string]float64)
+	var failed []string
+	for _, entry := range holdings {
+		if entry.Err != "" {
+			failed = append(failed, fmt.Sprintf("%s not included: %s", entry.Account, entry.Err))
+			consolidated.Warnings = append(consolidated.Warnings, AssetWarning{Account: entry.Account, Level: LevelError, Message: "not included, " + entry.Err})
+			continue
+		}
+		consolidated.merge(entry.Account, entry.Report)
+		for _, wallet := range entry.Wallet {
+			merged, ok := symbolToWallet[wallet.Symbol]
+			if !ok {
+				copied := *wallet
+				symbolToWallet[wallet.Symbol] = &copied
+				consolidated.Wallet = append(consolidated.Wallet, &copied)
+				continue
+			}
+			merged.Free += wallet.Free
+			merged.Locked += wallet.Locked
+			merged.QuoteValue += wallet.QuoteValue
+		}
+		for _, balance := range entry.Balances {
+			stats := balance.TradeStats
+			if stats.AvgBuyPrice > 0 {
+				symbolToCost[balance.Symbol] += stats.AvgBuyPrice * (balance.Free + balance.Locked)
+				symbolToCostQty[balance.Symbol] += balance.Free + balance.Locked
+			}
+			merged, ok := symbolToBalance[balance.Symbol]
+			if !ok {
+				copied := *balance
+				symbolToBalance[balance.Symbol] = &copied
+				consolidated.Balances = append(consolidated.Balances, &copied)
+				continue
+			}
+			merged.Free += balance.Free
+			merged.Locked += balance.Locked
+			merged.QuoteValue += balance.QuoteValue
+			mergeTradeStats(&merged.TradeStats, stats)
+		}
+	}
+	for symbol, balance := range symbolToBalance {
+		if qty := symbolToCostQty[symbol]; qty > 0 {
+			balance.TradeStats.AvgBuyPrice = symbolToCost[symbol] / qty
+		}
+	}
+	setPortfolioAllocations(consolidated.Balances)
+	sort.Slice(consolidated.Balances, func(i, j int) bool {
+		return consolidated.Balances[i].TradeStats.TotalValue > consolidated.Balances[j].TradeStats.TotalValue
+	})
+	sort.Slice(consolidated.Wallet, func(i, j int) bool {
+		return consolidated.Wallet[i].Free > consolidated.Wallet[j].Free
+	})
+	if len(holdings) > 0 && len(failed) == len(holdings) {
+		consolidated.Err = strings.Join(failed, "; ")
+	}
+	consolidated.total()
+	return consolidated
+}
+
+func mergeTradeStats(stats *PortfolioTradeStats, other PortfolioTradeStats) {
+	stats.TotalValue += other.TotalValue
+	stats.DailyPNL += other.DailyPNL
+	stats.UnrealizedPNL += other.UnrealizedPNL
+	stats.RealizedPNL += other.RealizedPNL
+	stats.LiquidityProvider.MakerQty += other.LiquidityProvider.MakerQty
+	stats.LiquidityProvider.TakerQty += other.LiquidityProvider.TakerQty
+	mergeTradeSideStats(&stats.Buy, other.Buy)
+	mergeTradeSideStats(&stats.Sale, other.Sale)
+}
+
+func mergeTradeSideStats(side *PortfolioTradeSideStats, other PortfolioTradeSideStats) {
+	if other.Qty == 0 {
+		return
+	}
+	if side.Qty == 0 {
+		*side = other
+		return
+	}
+	side.TotalCost += other.TotalCost
+	side.TotalGain += other.TotalGain
+	side.Qty += other.Qty
+	if other.Last.Timestamp > side.Last.Timestamp {
+		side.Last = other.Last
+	}
+	if other.Highest.Price > side.Highest.Price {
+		side.Highest = other.Highest
+	}
+	if other.Lowest.Price < side.Lowest.Price {
+		side.Lowest = other.Lowest
+	}
+}
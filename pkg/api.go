@@ -0,0 +1,173 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIResp is the envelope of every /api/v1 answer: Data on success, Error
+// otherwise, Page for lists and Warnings/Freshness for data that is partial
+// or partly stale, see Report.
+type APIResp[T any] struct {
+	Data      T                    `json:"data"`
+	Error     *APIError            `json:"error,omitempty"`
+	Page      *Page                `json:"page,omitempty"`
+	Warnings  []AssetWarning       `json:"warnings,omitempty"`
+	Freshness map[string]time.Time `json:"freshness,omitempty"`
+}
+
+// ErrorCode says what went wrong with an /api/v1 request, each with its own
+// HTTP status.
+type ErrorCode string
+
+const (
+	ErrorInvalidParameter ErrorCode = "invalid_parameter"
+	ErrorUnauthorized     ErrorCode = "unauthorized"
+	ErrorForbidden        ErrorCode = "forbidden"
+	ErrorNotFound         ErrorCode = "not_found"
+	ErrorMethodNotAllowed ErrorCode = "method_not_allowed"
+	ErrorRateLimited      ErrorCode = "rate_limited"
+	ErrorUpstream         ErrorCode = "upstream_error"
+	ErrorUpstreamTimeout  ErrorCode = "upstream_timeout"
+	ErrorInternal         ErrorCode = "internal_error"
+)
+
+// ErrorCodes lists every ErrorCode, for the OpenAPI document.
+var ErrorCodes = []ErrorCode{ErrorInvalidParameter, ErrorUnauthorized, ErrorForbidden, ErrorNotFound, ErrorMethodNotAllowed, ErrorRateLimited, ErrorUpstream, ErrorUpstreamTimeout, ErrorInternal}
+
+func (c ErrorCode) Status() int {
+	switch c {
+	case ErrorInvalidParameter:
+		return http.StatusBadRequest
+	case ErrorUnauthorized:
+		return http.StatusUnauthorized
+	case ErrorForbidden:
+		return http.StatusForbidden
+	case ErrorNotFound:
+		return http.StatusNotFound
+	case ErrorMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case ErrorRateLimited:
+		return http.StatusTooManyRequests
+	case ErrorUpstream:
+		return http.StatusBadGateway
+	case ErrorUpstreamTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// UpstreamErrorCode classifies an error from the Binance or CCData clients:
+// Binance's -11xx errors are about the request's parameters, the rest is
+// their failure rather than the caller's.
+func UpstreamErrorCode(err error) ErrorCode {
+	var binanceErr BinanceError
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return ErrorRateLimited
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorUpstreamTimeout
+	case errors.As(err, &binanceErr) && binanceErr.Code == ErrCodeTooManyRequests:
+		return ErrorRateLimited
+	case errors.As(err, &binanceErr) && binanceErr.Code <= -1100 && binanceErr.Code > -1200:
+		return ErrorInvalidParameter
+	}
+	return ErrorUpstream
+}
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// Page is where a list answer sits in the whole list, Total items long.
+type Page struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	Total  int `json:"total"`
+}
+
+// ParsePage reads the limit (100 by default, up to 1000) and offset query
+// params of a list.
+func ParsePage(limit string, offset string) (Page, error) {
+	page := Page{Limit: defaultPageLimit}
+	var err error
+	if strings.TrimSpace(limit) != "" {
+		if page.Limit, err = strconv.Atoi(strings.TrimSpace(limit)); err != nil || page.Limit < 1 || page.Limit > maxPageLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+	if strings.TrimSpace(offset) != "" {
+		if page.Offset, err = strconv.Atoi(strings.TrimSpace(offset)); err != nil || page.Offset < 0 {
+			return page, errors.New("offset must be 0 or more")
+		}
+	}
+	return page, nil
+}
+
+// Paginate returns the items of page, and page with the total filled in.
+func Paginate[T any](items []T, page Page) ([]T, *Page) {
+	page.Total = len(items)
+	start := min(page.Offset, len(items))
+	end := min(start+page.Limit, len(items))
+	return append([]T{}, items[start:end]...), &page
+}
+
+// FilterTrades flattens assetToTrades to the trades filter keeps, oldest
+// first. The assets of the filter match the symbol, base or quote asset.
+func FilterTrades(assetToTrades map[string][]Trade, filter ExportFilter) []Trade {
+	var trades []Trade
+	for symbol, symbolTrades := range assetToTrades {
+		base, quote := SplitSymbol(symbol)
+		if !filter.asset(symbol, base, quote) {
+			continue
+		}
+		for _, trade := range symbolTrades {
+			if filter.time(int64(trade.Time)) {
+				trades = append(trades, trade)
+			}
+		}
+	}
+	sortTrades(trades)
+	return trades
+}
+
+// FilterOrders keeps the orders placed in the time range of filter.
+func FilterOrders(orders []Order, filter ExportFilter) []Order {
+	var filteredOrders []Order
+	for _, order := range orders {
+		if filter.time(int64(order.Time)) {
+			filteredOrders = append(filteredOrders, order)
+		}
+	}
+	return filteredOrders
+}
+
+// FilterBalances keeps the balances of the assets of filter.
+func FilterBalances[T interface{ asset() string }](balances []T, filter ExportFilter) []T {
+	var filtered []T
+	for _, balance := range balances {
+		if filter.asset(balance.asset()) {
+			filtered = append(filtered, balance)
+		}
+	}
+	return filtered
+}
+
+func (b *WalletBalance) asset() string {
+	return b.Symbol
+}
+
+func (b *PortfolioBalance) asset() string {
+	return b.Symbol
+}
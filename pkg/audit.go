@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var auditMu sync.Mutex
+
+type OrderAuditEntry struct {
+	Timestamp   time.Time    `json:"timestamp"`
+	Action      string       `json:"action"`
+	Test        bool         `json:"test"`
+	Order       OrderRequest `json:"order"`
+	Status      string       `json:"status"`
+	OrderId     int64        `json:"order_id,omitempty"`
+	OrderStatus string       `json:"order_status,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+func orderAuditLogPath() string {
+	return config.OrderAuditLog
+}
+
+// AuditOrder appends entry as one JSON line to the local order audit log.
+// Failing to audit is logged loudly but never hides the order result.
+func AuditOrder(entry OrderAuditEntry) {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Error("[AuditOrder]: error encoding entry: ", err)
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	file, err := os.OpenFile(orderAuditLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Error("[AuditOrder]: error opening audit log: ", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Error("[AuditOrder]: error writing audit log: ", err)
+	}
+}
@@ -0,0 +1,387 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scopes of an API token: read for every request that only reads, trade for
+// the ones that place or cancel orders or change the trade store. Dashboard
+// sessions have both.
+const (
+	ScopeRead  = "read"
+	ScopeTrade = "trade"
+)
+
+var Scopes = []string{ScopeRead, ScopeTrade}
+
+// MinPasswordLength is enforced when a user's password is set.
+const MinPasswordLength = 10
+
+const (
+	passwordCost = 12
+	tokenPrefix  = "bpt_"
+)
+
+var userNamePattern = regexp.MustCompile(`^[a-z0-9_.@-]{1,64}$`)
+
+// User can log in to the dashboard. Only the bcrypt hash of the password is
+// kept.
+type User struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// APIToken is a bearer token for the JSON APIs. Only the SHA-256 of the token
+// is kept: tokens are random, so a slow hash adds nothing.
+type APIToken struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HasScope reports whether the token grants scope.
+func (t APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+type userStoreData struct {
+	Users  []User     `json:"users"`
+	Tokens []APIToken `json:"tokens"`
+}
+
+// UserStore is the file of dashboard users and API tokens. Changes made by
+// the users and tokens commands are picked up by a running server, so a
+// removed token stops working without a restart.
+type UserStore struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	data    userStoreData
+}
+
+// dummyHash is compared against for unknown users, so that a login takes as
+// long whether or not the user exists.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), passwordCost)
+	return hash
+})
+
+// OpenUserStore reads the users and tokens at path, an empty store when
+// there's no file yet.
+func OpenUserStore(path string) (*UserStore, error) {
+	store := &UserStore{path: path}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *UserStore) load() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.data, s.modTime = userStoreData{}, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var data userStoreData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.data, s.modTime = data, info.ModTime()
+	return nil
+}
+
+// refresh rereads the file when it changed since it was read. A file that
+// became unreadable keeps the last good users and tokens.
+func (s *UserStore) refresh() {
+	info, err := os.Stat(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		s.data, s.modTime = userStoreData{}, time.Time{}
+	case err == nil && !info.ModTime().Equal(s.modTime):
+		if err := s.load(); err != nil {
+			log.Errorf("Error reloading %s, keeping the users and tokens read before - %v", s.path, err)
+		}
+	}
+}
+
+// Path is the file the store is saved to.
+func (s *UserStore) Path() string {
+	return s.path
+}
+
+// Empty reports whether there is no user and no token, i.e. nobody could
+// sign in.
+func (s *UserStore) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	return len(s.data.Users) == 0 && len(s.data.Tokens) == 0
+}
+
+// Users returns the users sorted by name.
+func (s *UserStore) Users() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := slices.Clone(s.data.Users)
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// Tokens returns the tokens sorted by name.
+func (s *UserStore) Tokens() []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := slices.Clone(s.data.Tokens)
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens
+}
+
+// SetPassword adds user name, or changes the password of an existing one.
+// It returns whether the user was added.
+func (s *UserStore) SetPassword(name string, password string) (bool, error) {
+	if !userNamePattern.MatchString(name) {
+		return false, fmt.Errorf("%q: user names are up to 64 lowercase letters, digits, _ . @ and -", name)
+	}
+	if len(password) < MinPasswordLength {
+		return false, fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if len(password) > 72 {
+		return false, errors.New("password must be at most 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.data.Users {
+		if s.data.Users[i].Name == name {
+			s.data.Users[i].PasswordHash = string(hash)
+			return false, nil
+		}
+	}
+	s.data.Users = append(s.data.Users, User{Name: name, PasswordHash: string(hash), CreatedAt: time.Now().UTC()})
+	return true, nil
+}
+
+// HasUser reports whether user name exists.
+func (s *UserStore) HasUser(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	return slices.ContainsFunc(s.data.Users, func(user User) bool { return user.Name == name })
+}
+
+// RemoveUser deletes user name, reporting whether it existed.
+func (s *UserStore) RemoveUser(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := len(s.data.Users)
+	s.data.Users = slices.DeleteFunc(s.data.Users, func(user User) bool { return user.Name == name })
+	return len(s.data.Users) != count
+}
+
+// Authenticate reports whether password is the password of user name.
+func (s *UserStore) Authenticate(name string, password string) bool {
+	s.mu.Lock()
+	s.refresh()
+	var hash []byte
+	found := false
+	for _, user := range s.data.Users {
+		if user.Name == name {
+			hash, found = []byte(user.PasswordHash), true
+		}
+	}
+	s.mu.Unlock()
+	if !found {
+		hash = dummyHash()
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil && found
+}
+
+// ParseScopes reads a comma-separated list of scopes.
+func ParseScopes(value string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(value, ",") {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" || slices.Contains(scopes, scope) {
+			continue
+		}
+		if !slices.Contains(Scopes, scope) {
+			return nil, fmt.Errorf("%q is not a scope, use %s", scope, strings.Join(Scopes, " or "))
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is needed")
+	}
+	return scopes, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(size int) (string, error) {
+	bytes := make([]byte, size)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// AddToken creates token name with scopes and returns it. It can't be shown
+// again: only its hash is stored.
+func (s *UserStore) AddToken(name string, scopes []string) (string, error) {
+	if !userNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%q: token names are up to 64 lowercase letters, digits, _ . @ and -", name)
+	}
+	random, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	token := tokenPrefix + random
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.data.Tokens {
+		if existing.Name == name {
+			return "", fmt.Errorf("there is already a token named %s, remove it first", name)
+		}
+	}
+	s.data.Tokens = append(s.data.Tokens, APIToken{Name: name, Hash: hashToken(token), Scopes: scopes, CreatedAt: time.Now().UTC()})
+	return token, nil
+}
+
+// RemoveToken deletes token name, reporting whether it existed.
+func (s *UserStore) RemoveToken(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := len(s.data.Tokens)
+	s.data.Tokens = slices.DeleteFunc(s.data.Tokens, func(token APIToken) bool { return token.Name == name })
+	return len(s.data.Tokens) != count
+}
+
+// LookupToken returns the stored token matching token.
+func (s *UserStore) LookupToken(token string) (APIToken, bool) {
+	hash := []byte(hashToken(token))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	for _, stored := range s.data.Tokens {
+		if subtle.ConstantTimeCompare([]byte(stored.Hash), hash) == 1 {
+			return stored, true
+		}
+	}
+	return APIToken{}, false
+}
+
+// Save replaces the file, readable by the owner only.
+func (s *UserStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// Session is a signed-in dashboard user. Requests that change something
+// must send CSRFToken back, which a cross-site page can't read.
+type Session struct {
+	ID        string
+	User      string
+	CSRFToken string
+	Expires   time.Time
+}
+
+// Sessions are the dashboard sessions, kept in memory: restarting the server
+// signs everyone out.
+type Sessions struct {
+	mu       sync.Mutex
+	lifetime time.Duration
+	sessions map[string]Session
+}
+
+func NewSessions(lifetime time.Duration) *Sessions {
+	return &Sessions{lifetime: lifetime, sessions: make(map[string]Session)}
+}
+
+// Create starts a session for user.
+func (s *Sessions) Create(user string) (Session, error) {
+	id, err := randomToken(32)
+	if err != nil {
+		return Session{}, err
+	}
+	csrfToken, err := randomToken(32)
+	if err != nil {
+		return Session{}, err
+	}
+	session := Session{ID: id, User: user, CSRFToken: csrfToken, Expires: time.Now().Add(s.lifetime)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, existing := range s.sessions {
+		if now.After(existing.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.ID] = session
+	return session, nil
+}
+
+// Get returns the unexpired session id.
+func (s *Sessions) Get(id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.Expires) {
+		delete(s.sessions, id)
+		return Session{}, false
+	}
+	return session, true
+}
+
+// Delete ends session id.
+func (s *Sessions) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
@@ -0,0 +1,338 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const dayMs = int64(24 * time.Hour / time.Millisecond)
+
+type BenchmarkSpec struct {
+	Name    string             `json:"name"`
+	Weights map[string]float64 `json:"weights"`
+}
+
+type CashFlow struct {
+	Timestamp int64
+	Amount    float64
+}
+
+type EquityPoint struct {
+	Timestamp   int64   `json:"timestamp"`
+	Value       float64 `json:"value"`
+	NetInvested float64 `json:"net_invested"`
+}
+
+type PerformanceSeries struct {
+	Name           string             `json:"name"`
+	Weights        map[string]float64 `json:"weights,omitempty"`
+	Points         []EquityPoint      `json:"points"`
+	FinalValue     float64            `json:"final_value"`
+	NetInvested    float64            `json:"net_invested"`
+	Profit         float64            `json:"profit"`
+	Return         float64            `json:"return"`
+	RelativeReturn float64            `json:"relative_return"`
+}
+
+type BenchmarkComparison struct {
+	Currency   string              `json:"currency"`
+	Portfolio  PerformanceSeries   `json:"portfolio"`
+	Benchmarks []PerformanceSeries `json:"benchmarks"`
+	// Warnings are the pairs left out of the portfolio and the assets
+	// dropped from a benchmark for lack of a price history.
+	Warnings []AssetWarning `json:"warnings,omitempty"`
+}
+
+func dayStart(ts int64) int64 {
+	return ts - ts%dayMs
+}
+
+// ParseBenchmarkSpec understands a single asset ("BTC"), a market-cap-weighted
+// top-N basket ("top10") or a user-defined basket ("BTC:60,ETH:40").
+func ParseBenchmarkSpec(ctx context.Context, spec string, currency string) (BenchmarkSpec, error) {
+	spec = strings.ToUpper(strings.TrimSpace(spec))
+	if spec == "" {
+		return BenchmarkSpec{}, fmt.Errorf("empty benchmark")
+	}
+	if strings.HasPrefix(spec, "TOP") {
+		size, err := strconv.Atoi(strings.TrimPrefix(spec, "TOP"))
+		if err != nil || size <= 0 {
+			return BenchmarkSpec{}, fmt.Errorf("invalid top-N benchmark %q", spec)
+		}
+		return topMarketCapBasket(ctx, size, currency)
+	}
+	if !strings.Contains(spec, ":") {
+		return BenchmarkSpec{Name: spec, Weights: map[string]float64{spec: 1}}, nil
+	}
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(spec, ",") {
+		asset, weight, ok := strings.Cut(part, ":")
+		if !ok {
+			return BenchmarkSpec{}, fmt.Errorf("invalid basket entry %q", part)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+		if err != nil || value <= 0 {
+			return BenchmarkSpec{}, fmt.Errorf("invalid weight for %s", asset)
+		}
+		weights[strings.TrimSpace(asset)] += value
+	}
+	return BenchmarkSpec{Name: spec, Weights: normaliseWeights(weights)}, nil
+}
+
+func topMarketCapBasket(ctx context.Context, size int, currency string) (BenchmarkSpec, error) {
+	// over-fetch so that skipping stablecoins still leaves size assets
+	assets, err := GetCCDataTopAssetsByMarketCap(ctx, size*2, config.CCDataAPIKey)
+	if err != nil {
+		return BenchmarkSpec{}, err
+	}
+	weights := make(map[string]float64)
+	for _, asset := range assets {
+		if len(weights) == size {
+			break
+		}
+		if isCashEquivalent(asset.Symbol, currency) || asset.CirculatingMktCapUSD <= 0 {
+			continue
+		}
+		weights[asset.Symbol] = asset.CirculatingMktCapUSD
+	}
+	if len(weights) == 0 {
+		return BenchmarkSpec{}, fmt.Errorf("no assets returned for top%d", size)
+	}
+	return BenchmarkSpec{Name: fmt.Sprintf("TOP%d", size), Weights: normaliseWeights(weights)}, nil
+}
+
+// isCashEquivalent reports whether asset is cash rather than an asset to
+// hold in a benchmark.
+func isCashEquivalent(asset string, currency string) bool {
+	return asset == currency || config.IsCashAsset(asset) || config.IsUSDStablecoin(asset) || config.IsFiat(asset)
+}
+
+func normaliseWeights(weights map[string]float64) map[string]float64 {
+	var total float64
+	for _, weight := range weights {
+		total += weight
+	}
+	normalised := make(map[string]float64, len(weights))
+	for asset, weight := range weights {
+		normalised[asset] = weight / total
+	}
+	return normalised
+}
+
+// portfolioCashFlows turns trades into daily net cash flows in the quote
+// currency: buying an asset puts money in, selling takes it out.
+func portfolioCashFlows(assetToTrades map[string][]Trade) []CashFlow {
+	daily := make(map[int64]float64)
+	for _, trades := range assetToTrades {
+		for _, trade := range trades {
+			quoteQty, _ := strconv.ParseFloat(trade.QuoteQty, 64)
+			if !trade.IsBuyer {
+				quoteQty = -quoteQty
+			}
+			daily[dayStart(int64(trade.Time))] += quoteQty
+		}
+	}
+	var flows []CashFlow
+	for day, amount := range daily {
+		flows = append(flows, CashFlow{Timestamp: day, Amount: amount})
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		return flows[i].Timestamp < flows[j].Timestamp
+	})
+	return flows
+}
+
+type priceHistory struct {
+	currency string
+	closes   map[string]map[int64]float64
+}
+
+func (p *priceHistory) load(ctx context.Context, asset string, start int64) error {
+	if _, ok := p.closes[asset]; ok {
+		return nil
+	}
+	closes, err := GetDailyCloses(ctx, fmt.Sprintf("%s%s", asset, p.currency), start)
+	if err != nil {
+		return err
+	}
+	p.closes[asset] = closes
+	return nil
+}
+
+// close returns the close of asset on day, carrying the last known close
+// forward over gaps.
+func (p *priceHistory) close(asset string, day int64) float64 {
+	closes := p.closes[asset]
+	for ts := day; ts >= day-30*dayMs; ts -= dayMs {
+		if price, ok := closes[ts]; ok {
+			return price
+		}
+	}
+	return 0
+}
+
+func summariseSeries(series *PerformanceSeries, flows []CashFlow) {
+	var deposited, withdrawn float64
+	for _, flow := range flows {
+		if flow.Amount > 0 {
+			deposited += flow.Amount
+		} else {
+			withdrawn -= flow.Amount
+		}
+	}
+	if len(series.Points) > 0 {
+		series.FinalValue = series.Points[len(series.Points)-1].Value
+	}
+	series.NetInvested = deposited - withdrawn
+	series.Profit = series.FinalValue + withdrawn - deposited
+	if deposited > 0 {
+		series.Return = series.Profit / deposited * 100
+	}
+}
+
+// CompareToBenchmarks builds the equity curve of the traded portfolio and
+// replays the same cash flows into each benchmark basket.
+func CompareToBenchmarks(ctx context.Context, currency string, assetToTrades map[string][]Trade, specs []BenchmarkSpec) (BenchmarkComparison, error) {
+	comparison := BenchmarkComparison{Currency: currency}
+	// imported history can hold pairs in other quotes, which can't be replayed
+	quotedTrades := make(map[string][]Trade)
+	for instrument, trades := range assetToTrades {
+		if _, quote := SplitSymbol(instrument); quote == currency {
+			quotedTrades[instrument] = trades
+		}
+	}
+	assetToTrades = quotedTrades
+	var start int64
+	for _, trades := range assetToTrades {
+		for _, trade := range trades {
+			if day := dayStart(int64(trade.Time)); start == 0 || day < start {
+				start = day
+			}
+		}
+	}
+	if start == 0 {
+		return comparison, fmt.Errorf("no trades to compare against")
+	}
+	prices := &priceHistory{currency: currency, closes: make(map[string]map[int64]float64)}
+	// a pair without price history is left out of the cash flows as well,
+	// or its buys would count as money invested that is worth nothing
+	instruments := make([]string, 0, len(assetToTrades))
+	for instrument := range assetToTrades {
+		instruments = append(instruments, instrument)
+	}
+	sort.Strings(instruments)
+	for _, instrument := range instruments {
+		asset := strings.TrimSuffix(instrument, currency)
+		if err := prices.load(ctx, asset, start); err != nil {
+			log.Warnf("%s: no price history: %v", instrument, err)
+			comparison.Warnings = append(comparison.Warnings, AssetWarning{Asset: asset, Level: LevelError, Message: fmt.Sprintf("%s trades left out, no price history: %v", instrument, err)})
+			delete(assetToTrades, instrument)
+		}
+	}
+	flows := portfolioCashFlows(assetToTrades)
+	if len(flows) == 0 {
+		return comparison, fmt.Errorf("no trades with a price history to compare against")
+	}
+	start = flows[0].Timestamp
+	today := dayStart(time.Now().UnixMilli())
+
+	holdings := make(map[string]float64)
+	type qtyChange struct {
+		asset string
+		qty   float64
+	}
+	dailyChanges := make(map[int64][]qtyChange)
+	for instrument, trades := range assetToTrades {
+		asset := strings.TrimSuffix(instrument, currency)
+		for _, trade := range trades {
+			qty, _ := strconv.ParseFloat(trade.Qty, 64)
+			if !trade.IsBuyer {
+				qty = -qty
+			}
+			if trade.CommissionAsset == asset {
+				commission, _ := strconv.ParseFloat(trade.Commission, 64)
+				qty -= commission
+			}
+			day := dayStart(int64(trade.Time))
+			dailyChanges[day] = append(dailyChanges[day], qtyChange{asset: asset, qty: qty})
+		}
+	}
+
+	flowIndex := 0
+	netInvested := 0.0
+	comparison.Portfolio.Name = "Portfolio"
+	for day := start; day <= today; day += dayMs {
+		for flowIndex < len(flows) && flows[flowIndex].Timestamp == day {
+			netInvested += flows[flowIndex].Amount
+			flowIndex++
+		}
+		for _, change := range dailyChanges[day] {
+			holdings[change.asset] += change.qty
+		}
+		value := 0.0
+		for asset, qty := range holdings {
+			value += qty * prices.close(asset, day)
+		}
+		comparison.Portfolio.Points = append(comparison.Portfolio.Points, EquityPoint{Timestamp: day, Value: value, NetInvested: netInvested})
+	}
+	summariseSeries(&comparison.Portfolio, flows)
+
+	for _, spec := range specs {
+		series := PerformanceSeries{Name: spec.Name, Weights: make(map[string]float64)}
+		for asset, weight := range spec.Weights {
+			if err := prices.load(ctx, asset, start); err != nil {
+				log.Warnf("%s: dropped from benchmark %s: %v", asset, spec.Name, err)
+				comparison.Warnings = append(comparison.Warnings, AssetWarning{Asset: asset, Level: LevelWarning, Message: fmt.Sprintf("dropped from benchmark %s, no price history: %v", spec.Name, err)})
+				continue
+			}
+			series.Weights[asset] = weight
+		}
+		if len(series.Weights) == 0 {
+			continue
+		}
+		series.Weights = normaliseWeights(series.Weights)
+		units := make(map[string]float64)
+		flowIndex = 0
+		netInvested = 0.0
+		for day := start; day <= today; day += dayMs {
+			value := 0.0
+			for asset, qty := range units {
+				value += qty * prices.close(asset, day)
+			}
+			for flowIndex < len(flows) && flows[flowIndex].Timestamp == day {
+				flow := flows[flowIndex].Amount
+				netInvested += flow
+				flowIndex++
+				if flow >= 0 {
+					for asset, weight := range series.Weights {
+						if price := prices.close(asset, day); price > 0 {
+							units[asset] += flow * weight / price
+						}
+					}
+					value += flow
+					continue
+				}
+				if value <= 0 {
+					continue
+				}
+				// withdrawals sell the basket pro rata
+				fraction := min(1, -flow/value)
+				for asset := range units {
+					units[asset] *= 1 - fraction
+				}
+				value *= 1 - fraction
+			}
+			series.Points = append(series.Points, EquityPoint{Timestamp: day, Value: value, NetInvested: netInvested})
+		}
+		summariseSeries(&series, flows)
+		series.RelativeReturn = comparison.Portfolio.Return - series.Return
+		comparison.Benchmarks = append(comparison.Benchmarks, series)
+	}
+	return comparison, nil
+}
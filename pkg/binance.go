@@ -1,18 +1,40 @@
 package pkg
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var binanceBaseURL = "https://api.binance.com/api/v3"
+var binanceHost = "https://api.binance.com"
+var binanceBaseURL = binanceHost + "/api/v3"
+
+// ErrReadOnly is returned for any call that would change the account while
+// the read-only safety switch is on.
+var ErrReadOnly = errors.New("read-only mode: set binance.read_only = false (or BINANCE_READ_ONLY=false) to place or cancel orders")
+
+// SetBinanceHost points the client at another Binance compatible host, e.g.
+// the testnet or the local fake server in cmd/fakebinance.
+func SetBinanceHost(host string) {
+	binanceHost = strings.TrimSuffix(host, "/")
+	binanceBaseURL = binanceHost + "/api/v3"
+}
+
+// IsReadOnly reports whether the read-only safety switch is on. It is on
+// unless binance.read_only is explicitly set to false.
+func IsReadOnly() bool {
+	return config.BinanceReadOnly
+}
 
 type Order struct {
 	Symbol                  string `json:"symbol"`
@@ -90,61 +112,63 @@ type Balance struct {
 	Price  float64 `json:"price"`
 }
 
-func GetAllOrders(symbol string, limit string) ([]Order, error) {
-	startTs := time.Now()
-	var err error
+func GetAllOrders(ctx context.Context, symbol string, limit string) ([]Order, error) {
+	return GetAllOrdersFor(ctx, DefaultAccount(), symbol, limit)
+}
+
+// GetAccountsOrders fetches the orders of symbol of every account in
+// accounts, oldest first.
+func GetAccountsOrders(ctx context.Context, accounts []Account, symbol string, limit string) ([]Order, error) {
 	var orders []Order
-	endpoint := "/allOrders"
-	apiKey, secretKey := getApiAndSecretKeys()
-	timestamp := getTs()
-	queryString := fmt.Sprintf("symbol=%s&limit=%s&timestamp=%s", symbol, limit, timestamp)
-	signature := signParams(queryString, secretKey)
-	url := fmt.Sprintf("%s%s?%s&signature=%s", binanceBaseURL, endpoint, queryString, signature)
-	log.Info("[getAllOrders]: ", url)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return orders, err
+	for _, account := range accounts {
+		accountOrders, err := GetAllOrdersFor(ctx, account, symbol, limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", account.Name, err)
+		}
+		orders = append(orders, accountOrders...)
 	}
-	req.Header.Add("X-MBX-APIKEY", apiKey)
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return orders, err
+	if len(accounts) > 1 {
+		slices.SortStableFunc(orders, func(a Order, b Order) int {
+			return a.Time - b.Time
+		})
 	}
-	defer resp.Body.Close()
-	log.Infof("[GetAllOrders]: took: %v seconds", time.Since(startTs).Seconds())
-	var body []byte
-	body, err = io.ReadAll(resp.Body)
+	return orders, nil
+}
+
+func GetAllOrdersFor(ctx context.Context, account Account, symbol string, limit string) ([]Order, error) {
+	var orders []Order
+	params := neturl.Values{}
+	params.Set("symbol", symbol)
+	params.Set("limit", limit)
+	body, err := doSignedRequestFor(ctx, account, http.MethodGet, "/api/v3/allOrders", params)
 	if err != nil {
 		return orders, err
 	}
-	if resp.StatusCode != 200 {
-		return orders, errors.New("")
-	}
 	if err := json.Unmarshal(body, &orders); err != nil {
 		log.Error("error decoding JSON", err)
 		return orders, err
 	}
+	return orders, nil
+}
+
+// FilterOrdersByStatus keeps the orders whose status is one of statuses.
+func FilterOrdersByStatus(orders []Order, statuses ...string) []Order {
 	var filteredOrders []Order
 	for _, order := range orders {
-		if order.Status != "FILLED" {
-			continue
+		if slices.Contains(statuses, order.Status) {
+			filteredOrders = append(filteredOrders, order)
 		}
-		filteredOrders = append(filteredOrders, order)
 	}
-	return filteredOrders, nil
+	return filteredOrders
 }
 
-func Get24HoursTickerPrice(symbol string) (float64, float64, error) {
-	startTs := time.Now()
+func Get24HoursTickerPrice(ctx context.Context, symbol string) (float64, float64, error) {
 	url := fmt.Sprintf("%s/ticker/24hr?symbol=%s", binanceBaseURL, symbol)
-	log.Info("[get24HoursTickerPrice]: ", url)
-	resp, err := http.Get(url)
+	resp, err := binanceClient.Get(ctx, url)
 	if err != nil {
 		return 0, 0, err
 	}
 	defer resp.Body.Close()
-	log.Infof("[Get24HoursTickerPrice]: took: %v seconds", time.Since(startTs).Seconds())
 	var stats struct {
 		PriceChange string `json:"priceChange"`
 		LastPrice   string `json:"lastPrice"`
@@ -165,40 +189,29 @@ func Get24HoursTickerPrice(symbol string) (float64, float64, error) {
 	return priceChange, lastPrice, nil
 }
 
-func GetAccountBalances() ([]Balance, error) {
-	startTs := time.Now()
-	var err error
-	var balances []Balance
-	endpoint := "/account"
-	apiKey, secretKey := getApiAndSecretKeys()
-	timestamp := getTs()
-	queryString := fmt.Sprintf("omitZeroBalances=true&timestamp=%s", timestamp)
-	signature := signParams(queryString, secretKey)
-	url := fmt.Sprintf("%s%s?%s&signature=%s", binanceBaseURL, endpoint, queryString, signature)
-	log.Info("[GetAccountBalances]: ", url)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return balances, err
-	}
-	req.Header.Add("X-MBX-APIKEY", apiKey)
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return balances, err
-	}
-	defer resp.Body.Close()
-	log.Infof("[GetCCDataCurrentTickerPrice]: took: %v seconds", time.Since(startTs).Seconds())
-	var body []byte
-	body, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return balances, err
-	}
-	if resp.StatusCode != 200 {
-		return balances, errors.New("")
-	}
+func GetAccountInfo(ctx context.Context) (AccountInfo, error) {
+	return GetAccountInfoFor(ctx, DefaultAccount())
+}
 
+func GetAccountInfoFor(ctx context.Context, account Account) (AccountInfo, error) {
 	var result AccountInfo
+	params := neturl.Values{}
+	params.Set("omitZeroBalances", "true")
+	body, err := doSignedRequestFor(ctx, account, http.MethodGet, "/api/v3/account", params)
+	if err != nil {
+		return result, err
+	}
 	err = json.Unmarshal(body, &result)
+	return result, err
+}
+
+func GetAccountBalances(ctx context.Context) ([]Balance, error) {
+	return GetAccountBalancesFor(ctx, DefaultAccount())
+}
+
+func GetAccountBalancesFor(ctx context.Context, account Account) ([]Balance, error) {
+	var balances []Balance
+	result, err := GetAccountInfoFor(ctx, account)
 	if err != nil {
 		return balances, err
 	}
@@ -217,37 +230,11 @@ func GetAccountBalances() ([]Balance, error) {
 	return balances, nil
 }
 
-func GetAccountBalance(asset string) (float64, error) {
-	startTs := time.Now()
-	timestamp := time.Now().UnixMilli()
-	queryString := fmt.Sprintf("omitZeroBalances=true&timestamp=%d", timestamp)
-	apiKey, secretKey := getApiAndSecretKeys()
-	signature := signParams(queryString, secretKey)
-
-	url := fmt.Sprintf("%s/account?%s&signature=%s", binanceBaseURL, queryString, signature)
-	log.Info("[getAccountBalance]: ", url)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("X-MBX-APIKEY", apiKey)
-
-	resp, err := http.DefaultClient.Do(req)
+func GetAccountBalance(ctx context.Context, asset string) (float64, error) {
+	result, err := GetAccountInfo(ctx)
 	if err != nil {
 		return 0, err
 	}
-	defer resp.Body.Close()
-	log.Infof("[GetAccountBalance]: took: %v seconds", time.Since(startTs).Seconds())
-	body, _ := io.ReadAll(resp.Body)
-	var result struct {
-		Balances []struct {
-			Asset  string `json:"asset"`
-			Free   string `json:"free"`
-			Locked string `json:"locked"`
-		} `json:"balances"`
-	}
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return 0, err
-	}
-
 	for _, balance := range result.Balances {
 		if balance.Asset == asset {
 			freeBalance, err := strconv.ParseFloat(balance.Free, 64)
@@ -260,16 +247,13 @@ func GetAccountBalance(asset string) (float64, error) {
 	return 0, fmt.Errorf("asset %s not found in account", asset)
 }
 
-func GetCurrentTickerPrice(symbol string) (float64, error) {
-	startTs := time.Now()
+func GetCurrentTickerPrice(ctx context.Context, symbol string) (float64, error) {
 	url := fmt.Sprintf("%s/ticker/price?symbol=%s", binanceBaseURL, symbol)
-	log.Info("[getCurrentTickerPrice]: ", url)
-	resp, err := http.Get(url)
+	resp, err := binanceClient.Get(ctx, url)
 	if err != nil {
 		return 0, err
 	}
 	defer resp.Body.Close()
-	log.Infof("[GetCurrentTickerPrice]: took: %v seconds", time.Since(startTs).Seconds())
 	var result struct {
 		Price string `json:"price"`
 	}
@@ -285,47 +269,551 @@ func GetCurrentTickerPrice(symbol string) (float64, error) {
 	return price, nil
 }
 
-func GetTradesList(symbol string, limit string) ([]Trade, error) {
-	startTs := time.Now()
-	var err error
+func GetTradesList(ctx context.Context, symbol string, limit string) ([]Trade, error) {
+	return GetTradesListFor(ctx, DefaultAccount(), symbol, limit)
+}
+
+func GetTradesListFor(ctx context.Context, account Account, symbol string, limit string) ([]Trade, error) {
 	var trades []Trade
-	endpoint := "/myTrades"
-	apiKey, secretKey := getApiAndSecretKeys()
-	timestamp := getTs()
-	queryString := fmt.Sprintf("symbol=%s&limit=%s&timestamp=%s", symbol, limit, timestamp)
-	signature := signParams(queryString, secretKey)
-	url := fmt.Sprintf("%s%s?%s&signature=%s", binanceBaseURL, endpoint, queryString, signature)
-	log.Info("[getTradesList]: ", url)
-	req, err := http.NewRequest("GET", url, nil)
+	params := neturl.Values{}
+	params.Set("symbol", symbol)
+	params.Set("limit", limit)
+	body, err := doSignedRequestFor(ctx, account, http.MethodGet, "/api/v3/myTrades", params)
 	if err != nil {
 		return trades, err
 	}
+	if err := json.Unmarshal(body, &trades); err != nil {
+		log.Error("error decoding JSON", err)
+		return trades, err
+	}
+	return trades, nil
+}
+
+// GetTradeHistoryFor pages /myTrades of symbol by fromId, from trade fromID
+// on, portfolio.trade_fetch_limit trades a page, until a page comes back
+// short or maxPages were read. more is true when the last page was full:
+// there may be later trades.
+func GetTradeHistoryFor(ctx context.Context, account Account, symbol string, fromID int64, maxPages int) (trades []Trade, more bool, err error) {
+	limit := config.TradeFetchLimit
+	for page := 0; page < maxPages; page++ {
+		params := neturl.Values{}
+		params.Set("symbol", symbol)
+		params.Set("fromId", strconv.FormatInt(fromID, 10))
+		params.Set("limit", strconv.Itoa(limit))
+		body, err := doSignedRequestFor(ctx, account, http.MethodGet, "/api/v3/myTrades", params)
+		if err != nil {
+			return trades, false, err
+		}
+		var pageTrades []Trade
+		if err := json.Unmarshal(body, &pageTrades); err != nil {
+			log.Error("error decoding JSON", err)
+			return trades, false, err
+		}
+		trades = append(trades, pageTrades...)
+		if len(pageTrades) < limit {
+			return trades, false, nil
+		}
+		fromID = pageTrades[len(pageTrades)-1].ID + 1
+	}
+	return trades, true, nil
+}
+
+type Kline struct {
+	OpenTime  int64
+	Open      float64
+	High      float64
+	Low       float64
+	Close     float64
+	Volume    float64
+	CloseTime int64
+}
+
+func GetKlines(ctx context.Context, symbol string, interval string, startTime int64, limit int) ([]Kline, error) {
+	var klines []Kline
+	url := fmt.Sprintf("%s/klines?symbol=%s&interval=%s&startTime=%d&limit=%d", binanceBaseURL, symbol, interval, startTime, limit)
+	resp, err := binanceClient.Get(ctx, url)
+	if err != nil {
+		return klines, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return klines, err
+	}
+	if resp.StatusCode != 200 {
+		return klines, errors.New(string(body))
+	}
+	var rows [][]interface{}
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return klines, err
+	}
+	for _, row := range rows {
+		if len(row) < 7 {
+			continue
+		}
+		openTime, _ := row[0].(float64)
+		closeTime, _ := row[6].(float64)
+		kline := Kline{OpenTime: int64(openTime), CloseTime: int64(closeTime)}
+		kline.Open, _ = strconv.ParseFloat(fmt.Sprint(row[1]), 64)
+		kline.High, _ = strconv.ParseFloat(fmt.Sprint(row[2]), 64)
+		kline.Low, _ = strconv.ParseFloat(fmt.Sprint(row[3]), 64)
+		kline.Close, _ = strconv.ParseFloat(fmt.Sprint(row[4]), 64)
+		kline.Volume, _ = strconv.ParseFloat(fmt.Sprint(row[5]), 64)
+		klines = append(klines, kline)
+	}
+	return klines, nil
+}
+
+// GetDailyCloses returns the daily close price of symbol keyed by the UTC
+// midnight (in ms) of each day, starting from the day of startTime.
+func GetDailyCloses(ctx context.Context, symbol string, startTime int64) (map[int64]float64, error) {
+	closes := make(map[int64]float64)
+	from := startTime
+	for {
+		klines, err := GetKlines(ctx, symbol, "1d", from, 1000)
+		if err != nil {
+			return closes, err
+		}
+		for _, kline := range klines {
+			closes[kline.OpenTime] = kline.Close
+		}
+		if len(klines) < 1000 {
+			return closes, nil
+		}
+		from = klines[len(klines)-1].CloseTime + 1
+	}
+}
+
+type SymbolFilter struct {
+	FilterType  string `json:"filterType"`
+	MinPrice    string `json:"minPrice"`
+	MaxPrice    string `json:"maxPrice"`
+	TickSize    string `json:"tickSize"`
+	MinQty      string `json:"minQty"`
+	MaxQty      string `json:"maxQty"`
+	StepSize    string `json:"stepSize"`
+	MinNotional string `json:"minNotional"`
+}
+
+type SymbolInfo struct {
+	Symbol     string         `json:"symbol"`
+	Status     string         `json:"status"`
+	BaseAsset  string         `json:"baseAsset"`
+	QuoteAsset string         `json:"quoteAsset"`
+	OrderTypes []string       `json:"orderTypes"`
+	Filters    []SymbolFilter `json:"filters"`
+}
+
+type ExchangeInfo struct {
+	Timezone   string       `json:"timezone"`
+	ServerTime int64        `json:"serverTime"`
+	RateLimits []RateLimit  `json:"rateLimits"`
+	Symbols    []SymbolInfo `json:"symbols"`
+}
+
+// Filter returns the filter of the given type, e.g. LOT_SIZE or NOTIONAL.
+func (s SymbolInfo) Filter(filterType string) (SymbolFilter, bool) {
+	for _, filter := range s.Filters {
+		if filter.FilterType == filterType {
+			return filter, true
+		}
+	}
+	return SymbolFilter{}, false
+}
+
+func GetExchangeInfo(ctx context.Context, symbols []string) (ExchangeInfo, error) {
+	var result ExchangeInfo
+	quoted := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		quoted[i] = fmt.Sprintf("%q", symbol)
+	}
+	url := fmt.Sprintf("%s/exchangeInfo?symbols=%s", binanceBaseURL, neturl.QueryEscape("["+strings.Join(quoted, ",")+"]"))
+	resp, err := binanceClient.Get(ctx, url)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return result, err
+	}
+	if resp.StatusCode != 200 {
+		return result, errors.New(string(body))
+	}
+	if err = json.Unmarshal(body, &result); err == nil {
+		SetRateLimits(result.RateLimits)
+	}
+	return result, err
+}
+
+type OrderRequest struct {
+	Symbol           string `json:"symbol"`
+	Side             string `json:"side"`
+	Type             string `json:"type"`
+	TimeInForce      string `json:"timeInForce,omitempty"`
+	Quantity         string `json:"quantity,omitempty"`
+	QuoteOrderQty    string `json:"quoteOrderQty,omitempty"`
+	Price            string `json:"price,omitempty"`
+	NewClientOrderId string `json:"newClientOrderId,omitempty"`
+}
+
+type OrderFill struct {
+	Price           string `json:"price"`
+	Qty             string `json:"qty"`
+	Commission      string `json:"commission"`
+	CommissionAsset string `json:"commissionAsset"`
+	TradeId         int64  `json:"tradeId"`
+}
+
+type OrderResponse struct {
+	Symbol              string      `json:"symbol"`
+	OrderId             int64       `json:"orderId"`
+	ClientOrderId       string      `json:"clientOrderId"`
+	TransactTime        int64       `json:"transactTime"`
+	Price               string      `json:"price"`
+	OrigQty             string      `json:"origQty"`
+	ExecutedQty         string      `json:"executedQty"`
+	CummulativeQuoteQty string      `json:"cummulativeQuoteQty"`
+	Status              string      `json:"status"`
+	TimeInForce         string      `json:"timeInForce"`
+	Type                string      `json:"type"`
+	Side                string      `json:"side"`
+	Fills               []OrderFill `json:"fills"`
+	Test                bool        `json:"test"`
+}
+
+type BinanceError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e BinanceError) Error() string {
+	return fmt.Sprintf("binance error %d: %s", e.Code, e.Msg)
+}
+
+// Validate checks the fields Binance needs for MARKET and LIMIT orders.
+func (o OrderRequest) Validate() error {
+	if strings.TrimSpace(o.Symbol) == "" {
+		return errors.New("symbol is required")
+	}
+	if o.Side != "BUY" && o.Side != "SELL" {
+		return errors.New("side must be BUY or SELL")
+	}
+	switch o.Type {
+	case "MARKET":
+		if o.Quantity == "" && o.QuoteOrderQty == "" {
+			return errors.New("MARKET orders need quantity or quoteOrderQty")
+		}
+	case "LIMIT":
+		if o.Quantity == "" || o.Price == "" {
+			return errors.New("LIMIT orders need quantity and price")
+		}
+	default:
+		return errors.New("type must be MARKET or LIMIT")
+	}
+	return nil
+}
+
+func (o OrderRequest) params() neturl.Values {
+	params := neturl.Values{}
+	params.Set("symbol", o.Symbol)
+	params.Set("side", o.Side)
+	params.Set("type", o.Type)
+	params.Set("newOrderRespType", "FULL")
+	if o.Type == "LIMIT" {
+		timeInForce := o.TimeInForce
+		if timeInForce == "" {
+			timeInForce = "GTC"
+		}
+		params.Set("timeInForce", timeInForce)
+		params.Set("price", o.Price)
+	}
+	if o.Quantity != "" {
+		params.Set("quantity", o.Quantity)
+	} else {
+		params.Set("quoteOrderQty", o.QuoteOrderQty)
+	}
+	if o.NewClientOrderId != "" {
+		params.Set("newClientOrderId", o.NewClientOrderId)
+	}
+	return params
+}
+
+// doSignedRequest sends params (plus timestamp, recvWindow and signature) as
+// the body of a POST or as the query string of any other method. endpoint is
+// the full path on the Binance host, e.g. /api/v3/order or /sapi/v1/capital/...
+// A request rejected for its timestamp (-1021) is retried once after syncing
+// with the server time, and one rejected with 429 (-1003) once the rate
+// limiter allows; Binance didn't process either, so that's safe for orders.
+func doSignedRequest(ctx context.Context, method string, endpoint string, params neturl.Values) ([]byte, error) {
+	return doSignedRequestFor(ctx, DefaultAccount(), method, endpoint, params)
+}
+
+func doSignedRequestFor(ctx context.Context, account Account, method string, endpoint string, params neturl.Values) ([]byte, error) {
+	body, err := doSignedRequestOnce(ctx, account, method, endpoint, params)
+	var binanceErr BinanceError
+	if !errors.As(err, &binanceErr) {
+		return body, err
+	}
+	if binanceErr.Code == ErrCodeTooManyRequests {
+		log.Warnf("[doSignedRequest]: %s %s: %v, retrying when the rate limit allows", method, endpoint, err)
+		return doSignedRequestOnce(ctx, account, method, endpoint, params)
+	}
+	if binanceErr.Code != ErrCodeTimestamp {
+		return body, err
+	}
+	offset, syncErr := SyncServerTime(ctx)
+	if syncErr != nil {
+		log.Warnf("[doSignedRequest]: %s %s: %v, and syncing the server time failed - %v", method, endpoint, err, syncErr)
+		return body, err
+	}
+	log.Warnf("[doSignedRequest]: %s %s: %v, retrying with the server time (local clock off by %v)", method, endpoint, err, -offset)
+	return doSignedRequestOnce(ctx, account, method, endpoint, params)
+}
+
+func doSignedRequestOnce(ctx context.Context, account Account, method string, endpoint string, params neturl.Values) ([]byte, error) {
+	apiKey := getApiKey(account)
+	unsigned, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s%s?%s", binanceHost, endpoint, params.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = binanceLimiter.reserveAhead(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	params.Set("timestamp", getTs(ctx))
+	if !params.Has("recvWindow") {
+		params.Set("recvWindow", strconv.FormatInt(config.BinanceRecvWindow.Milliseconds(), 10))
+	}
+	payload, err := signPayload(account, params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s%s", binanceHost, endpoint)
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(payload)
+	} else {
+		url = fmt.Sprintf("%s?%s", url, payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("X-MBX-APIKEY", apiKey)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	if method == http.MethodPost {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := binanceClient.Do(req)
 	if err != nil {
-		return trades, err
+		return nil, err
 	}
 	defer resp.Body.Close()
-	log.Infof("[GetTradesList]: took: %v seconds", time.Since(startTs).Seconds())
-	var body []byte
-	body, err = io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return trades, err
+		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		return trades, errors.New(string(body))
+		var binanceErr BinanceError
+		if json.Unmarshal(respBody, &binanceErr) == nil && binanceErr.Code != 0 {
+			return respBody, binanceErr
+		}
+		return respBody, errors.New(string(respBody))
 	}
-	if err := json.Unmarshal(body, &trades); err != nil {
-		log.Error("error decoding JSON", err)
-		return trades, err
+	return respBody, nil
+}
+
+// PlaceOrder submits order to /order, or to /order/test when test is true.
+// Test orders are validated by Binance but never reach the matching engine,
+// so they are allowed in read-only mode. Every attempt is audited.
+func PlaceOrder(ctx context.Context, order OrderRequest, test bool) (OrderResponse, error) {
+	result := OrderResponse{Symbol: order.Symbol, Side: order.Side, Type: order.Type, Test: test}
+	audit := OrderAuditEntry{Action: "new", Order: order, Test: test}
+	if err := order.Validate(); err != nil {
+		audit.Status, audit.Error = "invalid", err.Error()
+		AuditOrder(audit)
+		return result, err
 	}
-	// var filteredTrades []Trade
-	// for _, trade := range trades {
-	// 	// if order.Status != "FILLED" {
-	// 	// 	continue
-	// 	// }
-	// 	filteredTrades = append(filteredTrades, trade)
-	// }
-	return trades, nil
+	if !test && IsReadOnly() {
+		audit.Status, audit.Error = "blocked", ErrReadOnly.Error()
+		AuditOrder(audit)
+		return result, ErrReadOnly
+	}
+	endpoint := "/api/v3/order"
+	if test {
+		endpoint = "/api/v3/order/test"
+	}
+	body, err := doSignedRequest(ctx, http.MethodPost, endpoint, order.params())
+	if err != nil {
+		audit.Status, audit.Error = "rejected", err.Error()
+		AuditOrder(audit)
+		return result, err
+	}
+	if !test {
+		if err := json.Unmarshal(body, &result); err != nil {
+			audit.Status, audit.Error = "unknown", err.Error()
+			AuditOrder(audit)
+			return result, err
+		}
+	}
+	audit.Status, audit.OrderId, audit.OrderStatus = "accepted", result.OrderId, result.Status
+	AuditOrder(audit)
+	return result, nil
+}
+
+func GetOpenOrders(ctx context.Context, symbol string) ([]Order, error) {
+	var orders []Order
+	params := neturl.Values{}
+	if symbol != "" {
+		params.Set("symbol", symbol)
+	}
+	body, err := doSignedRequest(ctx, http.MethodGet, "/api/v3/openOrders", params)
+	if err != nil {
+		return orders, err
+	}
+	err = json.Unmarshal(body, &orders)
+	return orders, err
+}
+
+// CancelOrder cancels a single working order. Like placing orders it is
+// blocked in read-only mode and audited.
+func CancelOrder(ctx context.Context, symbol string, orderId int64) (Order, error) {
+	var order Order
+	audit := OrderAuditEntry{Action: "cancel", Order: OrderRequest{Symbol: symbol}, OrderId: orderId}
+	if IsReadOnly() {
+		audit.Status, audit.Error = "blocked", ErrReadOnly.Error()
+		AuditOrder(audit)
+		return order, ErrReadOnly
+	}
+	params := neturl.Values{}
+	params.Set("symbol", symbol)
+	params.Set("orderId", strconv.FormatInt(orderId, 10))
+	body, err := doSignedRequest(ctx, http.MethodDelete, "/api/v3/order", params)
+	if err != nil {
+		audit.Status, audit.Error = "rejected", err.Error()
+		AuditOrder(audit)
+		return order, err
+	}
+	err = json.Unmarshal(body, &order)
+	audit.Status, audit.OrderStatus = "accepted", order.Status
+	AuditOrder(audit)
+	return order, err
+}
+
+// CancelAllOpenOrders cancels every working order on symbol.
+func CancelAllOpenOrders(ctx context.Context, symbol string) ([]Order, error) {
+	var orders []Order
+	audit := OrderAuditEntry{Action: "cancel_all", Order: OrderRequest{Symbol: symbol}}
+	if IsReadOnly() {
+		audit.Status, audit.Error = "blocked", ErrReadOnly.Error()
+		AuditOrder(audit)
+		return orders, ErrReadOnly
+	}
+	params := neturl.Values{}
+	params.Set("symbol", symbol)
+	body, err := doSignedRequest(ctx, http.MethodDelete, "/api/v3/openOrders", params)
+	if err != nil {
+		audit.Status, audit.Error = "rejected", err.Error()
+		AuditOrder(audit)
+		return orders, err
+	}
+	err = json.Unmarshal(body, &orders)
+	audit.Status = "accepted"
+	AuditOrder(audit)
+	return orders, err
+}
+
+type Deposit struct {
+	Id           string `json:"id"`
+	Amount       string `json:"amount"`
+	Coin         string `json:"coin"`
+	Network      string `json:"network"`
+	Status       int    `json:"status"`
+	Address      string `json:"address"`
+	TxId         string `json:"txId"`
+	InsertTime   int64  `json:"insertTime"`
+	TransferType int    `json:"transferType"`
+}
+
+type Withdrawal struct {
+	Id             string `json:"id"`
+	Amount         string `json:"amount"`
+	TransactionFee string `json:"transactionFee"`
+	Coin           string `json:"coin"`
+	Status         int    `json:"status"`
+	Address        string `json:"address"`
+	TxId           string `json:"txId"`
+	ApplyTime      string `json:"applyTime"`
+	Network        string `json:"network"`
+	TransferType   int    `json:"transferType"`
+	CompleteTime   string `json:"completeTime"`
+}
+
+// ApplyTimestamp parses the "2006-01-02 15:04:05" UTC time the SAPI returns.
+func (w Withdrawal) ApplyTimestamp() int64 {
+	applyTime, err := time.Parse(time.DateTime, w.ApplyTime)
+	if err != nil {
+		return 0
+	}
+	return applyTime.UnixMilli()
+}
+
+// capitalHistoryWindow is the longest range the capital history endpoints
+// accept in one call.
+const capitalHistoryWindow = 90 * 24 * time.Hour
+
+// GetDepositHistory returns the successful deposits between startTime and
+// endTime (ms), walking the range in 90 day windows.
+func GetDepositHistory(ctx context.Context, startTime int64, endTime int64) ([]Deposit, error) {
+	return GetDepositHistoryFor(ctx, DefaultAccount(), startTime, endTime)
+}
+
+func GetDepositHistoryFor(ctx context.Context, account Account, startTime int64, endTime int64) ([]Deposit, error) {
+	var deposits []Deposit
+	for from := startTime; from < endTime; from += capitalHistoryWindow.Milliseconds() {
+		to := min(from+capitalHistoryWindow.Milliseconds()-1, endTime)
+		params := neturl.Values{}
+		params.Set("status", "1")
+		params.Set("startTime", strconv.FormatInt(from, 10))
+		params.Set("endTime", strconv.FormatInt(to, 10))
+		params.Set("limit", "1000")
+		body, err := doSignedRequestFor(ctx, account, http.MethodGet, "/sapi/v1/capital/deposit/hisrec", params)
+		if err != nil {
+			return deposits, err
+		}
+		var window []Deposit
+		if err := json.Unmarshal(body, &window); err != nil {
+			return deposits, err
+		}
+		deposits = append(deposits, window...)
+	}
+	return deposits, nil
+}
+
+// GetWithdrawalHistory returns the completed withdrawals between startTime and
+// endTime (ms), walking the range in 90 day windows.
+func GetWithdrawalHistory(ctx context.Context, startTime int64, endTime int64) ([]Withdrawal, error) {
+	return GetWithdrawalHistoryFor(ctx, DefaultAccount(), startTime, endTime)
+}
+
+func GetWithdrawalHistoryFor(ctx context.Context, account Account, startTime int64, endTime int64) ([]Withdrawal, error) {
+	var withdrawals []Withdrawal
+	for from := startTime; from < endTime; from += capitalHistoryWindow.Milliseconds() {
+		to := min(from+capitalHistoryWindow.Milliseconds()-1, endTime)
+		params := neturl.Values{}
+		params.Set("status", "6")
+		params.Set("startTime", strconv.FormatInt(from, 10))
+		params.Set("endTime", strconv.FormatInt(to, 10))
+		params.Set("limit", "1000")
+		body, err := doSignedRequestFor(ctx, account, http.MethodGet, "/sapi/v1/capital/withdraw/history", params)
+		if err != nil {
+			return withdrawals, err
+		}
+		var window []Withdrawal
+		if err := json.Unmarshal(body, &window); err != nil {
+			return withdrawals, err
+		}
+		withdrawals = append(withdrawals, window...)
+	}
+	return withdrawals, nil
 }
@@ -1,17 +1,20 @@
 package pkg
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"time"
-
-	log "github.com/sirupsen/logrus"
+	"strings"
 )
 
 var ccDataBaseURL = "https://data-api.ccdata.io"
 
+func SetCCDataBaseURL(baseURL string) {
+	ccDataBaseURL = strings.TrimSuffix(baseURL, "/")
+}
+
 type CCDataResponse struct {
 	Data map[string]CCDataSpotInstrumentData `json:"Data"`
 }
@@ -29,17 +32,27 @@ type CCDataSpotInstrumentData struct {
 	CurrentDayChangePercentage float64 `json:"CURRENT_DAY_CHANGE_PERCENTAGE"`
 }
 
-func GetCCDataCurrentTickerPrice(instruments, apiKey string) (CCDataResponse, error) {
-	startTs := time.Now()
-	url := fmt.Sprintf("%s/spot/v1/latest/tick?market=binance&instruments=%s&apply_mapping=false&groups=ID,VALUE,CURRENT_DAY&api_key=%s", ccDataBaseURL, instruments, apiKey)
-	log.Info("[GetCCDataCurrentTickerPrice]: ", url)
+// ccDataGet sends the API key as a header rather than in the URL, which
+// would put it in logs and in net/http errors.
+func ccDataGet(ctx context.Context, url string, apiKey string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Apikey "+apiKey)
+	}
+	return ccDataClient.Do(req)
+}
+
+func GetCCDataCurrentTickerPrice(ctx context.Context, instruments, apiKey string) (CCDataResponse, error) {
+	url := fmt.Sprintf("%s/spot/v1/latest/tick?market=binance&instruments=%s&apply_mapping=false&groups=ID,VALUE,CURRENT_DAY", ccDataBaseURL, instruments)
 	data := CCDataResponse{}
-	resp, err := http.Get(url)
+	resp, err := ccDataGet(ctx, url, apiKey)
 	if err != nil {
 		return data, err
 	}
 	defer resp.Body.Close()
-	log.Infof("[GetCCDataCurrentTickerPrice]: took: %v seconds", time.Since(startTs).Seconds())
 	if resp.StatusCode != 200 {
 		return data, errors.New("error fetching from ccdata.io")
 	}
@@ -50,3 +63,33 @@ func GetCCDataCurrentTickerPrice(instruments, apiKey string) (CCDataResponse, er
 	}
 	return result, nil
 }
+
+type CCDataTopListResponse struct {
+	Data struct {
+		List []CCDataAssetMarketCap `json:"LIST"`
+	} `json:"Data"`
+}
+
+type CCDataAssetMarketCap struct {
+	Symbol               string  `json:"SYMBOL"`
+	CirculatingMktCapUSD float64 `json:"CIRCULATING_MKT_CAP_USD"`
+}
+
+func GetCCDataTopAssetsByMarketCap(ctx context.Context, size int, apiKey string) ([]CCDataAssetMarketCap, error) {
+	url := fmt.Sprintf("%s/asset/v1/top/list?page=1&page_size=%d&sort_by=CIRCULATING_MKT_CAP_USD&sort_direction=DESC&groups=ID,MKT_CAP", ccDataBaseURL, size)
+	var assets []CCDataAssetMarketCap
+	resp, err := ccDataGet(ctx, url, apiKey)
+	if err != nil {
+		return assets, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return assets, errors.New("error fetching from ccdata.io")
+	}
+	var result CCDataTopListResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return assets, err
+	}
+	return result.Data.List, nil
+}
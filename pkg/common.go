@@ -1,14 +1,12 @@
 package pkg
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"fmt"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -20,6 +18,10 @@ type stas[T float64 | int] interface{}
 type RESTResp[T interface{} | map[string]interface{}] struct {
 	Data T
 	Err  interface{}
+	// Warnings and Freshness come with data that is partial or partly
+	// stale, see Report
+	Warnings  []AssetWarning       `json:",omitempty"`
+	Freshness map[string]time.Time `json:",omitempty"`
 }
 
 type WalletBalance struct {
@@ -77,23 +79,11 @@ type PortfolioTradeStats struct {
 	LiquidityProvider   LiquidityProviderStats
 }
 
-func signParams(message, secret string) string {
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(message))
-	return hex.EncodeToString(mac.Sum(nil))
-}
-
-func getApiAndSecretKeys() (string, string) {
-	apiKey := os.Getenv("BINANCE_API_KEY")
-	secretKey := os.Getenv("BINANCE_SECRET_KEY")
-	if apiKey == "" {
+func getApiKey(account Account) string {
+	if account.APIKey == "" {
 		log.Fatal("You obviously didn't read the Readme.md! :( BINANCE_API_KEY and BINANCE_SECRET_KEY are required!")
 	}
-	return apiKey, secretKey
-}
-
-func getTs() string {
-	return strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	return account.APIKey
 }
 
 func calculateTradeCosts(trades []Trade) PortfolioTradeStats {
@@ -217,40 +207,127 @@ func calculateRealizedPNL(trades []Trade, avgBuyPrice float64) (float64, error)
 	return realizedPNL, nil
 }
 
-func GetWalletBalancesAndCCData(currency string) ([]*WalletBalance, error) {
-	var err error
-	var balances []Balance
-	var portfolioBalances []*WalletBalance
-	balances, err = GetAccountBalances()
+func newPortfolioBalance(balance *WalletBalance, currency string, tradeStats PortfolioTradeStats) *PortfolioBalance {
+	return &PortfolioBalance{
+		Symbol:             balance.Symbol,
+		QuoteSymbol:        currency,
+		Free:               balance.Free,
+		Locked:             balance.Locked,
+		QuoteValue:         balance.QuoteValue,
+		Price:              balance.Price,
+		PriceFlag:          balance.PriceFlag,
+		PriceChangeValue:   balance.PriceChangeValue,
+		PriceChangePercent: balance.PriceChangePercent,
+		TradeStats:         tradeStats,
+	}
+}
+
+// fillPNLStats works out the value, average buy price and PNL of a holding
+// from the trades of its pair. The quote currency itself counts at face value.
+func fillPNLStats(stats *PortfolioTradeStats, trades []Trade, balance *WalletBalance, currency string) {
+	qty := balance.Free + balance.Locked
+	if balance.Price == 0 {
+		if balance.Symbol == currency {
+			stats.TotalValue = qty
+		}
+		return
+	}
+	stats.TotalValue = qty * balance.Price
+	stats.DailyPNL = qty * balance.PriceChangeValue
+	var boughtQty, boughtCost float64
+	for _, trade := range trades {
+		if !trade.IsBuyer {
+			continue
+		}
+		price, _ := strconv.ParseFloat(trade.Price, 64)
+		tradeQty, _ := strconv.ParseFloat(trade.Qty, 64)
+		boughtQty += tradeQty
+		boughtCost += price * tradeQty
+	}
+	if boughtQty == 0 {
+		return
+	}
+	stats.AvgBuyPrice = boughtCost / boughtQty
+	stats.UnrealizedPNL = (balance.Price - stats.AvgBuyPrice) * qty
+	stats.RealizedPNL, _ = calculateRealizedPNL(trades, stats.AvgBuyPrice)
+}
+
+func GetWalletBalancesAndCCData(ctx context.Context, currency string) ([]*WalletBalance, error) {
+	balances, err := GetAccountBalances(ctx)
 	if err != nil {
-		return portfolioBalances, err
+		return nil, err
+	}
+	walletBalances, report, err := PriceBalances(ctx, currency, balances)
+	for _, warning := range report.Warnings {
+		log.Warn(warning)
 	}
+	return walletBalances, err
+}
+
+// lastPrices keeps the latest CCData tick of every instrument and when it
+// was fetched, for PriceBalances to fall back on while CCData fails.
+var lastPrices = struct {
+	sync.Mutex
+	ticks map[string]CCDataSpotInstrumentData
+	at    map[string]time.Time
+}{ticks: make(map[string]CCDataSpotInstrumentData), at: make(map[string]time.Time)}
+
+// PriceBalances values balances in currency with the CCData spot prices.
+// Cash assets are kept at face value. An asset CCData has no price for
+// keeps the last one fetched, with a warning, or is left at zero with an
+// error; only if CCData fails and no asset has an earlier price is the
+// result an error.
+func PriceBalances(ctx context.Context, currency string, balances []Balance) ([]*WalletBalance, Report, error) {
+	var report Report
+	var portfolioBalances []*WalletBalance
 	var ccDataInstruments []string
 	for _, balance := range balances {
-		if balance.Asset == "USDT" || balance.Asset == "GBP" || balance.Asset == "USD" || balance.Asset == currency {
+		if config.IsCashAsset(balance.Asset) || balance.Asset == currency {
 			continue
 		}
 		ccDataInstruments = append(ccDataInstruments, fmt.Sprintf("%s-%s", balance.Asset, currency))
 	}
-	spotResponse, err := GetCCDataCurrentTickerPrice(strings.Join(ccDataInstruments, ","), os.Getenv("CC_API_KEY"))
-	if err != nil {
-		return portfolioBalances, err
+	spotResponse, err := GetCCDataCurrentTickerPrice(ctx, strings.Join(ccDataInstruments, ","), config.CCDataAPIKey)
+	now := time.Now()
+	lastPrices.Lock()
+	defer lastPrices.Unlock()
+	if err == nil {
+		report.fetched(SourcePrices, now)
+		for instrument, tick := range spotResponse.Data {
+			lastPrices.ticks[instrument], lastPrices.at[instrument] = tick, now
+		}
 	}
+	recovered := false
 	for _, balance := range balances {
-		if balance.Asset == "USDT" || balance.Asset == "GBP" || balance.Asset == "USD" || balance.Asset == currency {
+		if config.IsCashAsset(balance.Asset) || balance.Asset == currency {
 			portfolioBalances = append(portfolioBalances, &WalletBalance{
 				Symbol: balance.Asset,
 				Free:   balance.Free,
+				Locked: balance.Locked,
 			})
 			continue
 		}
 		instrument := fmt.Sprintf("%s-%s", balance.Asset, currency)
-		currentInstrument := spotResponse.Data[instrument]
+		currentInstrument, ok := spotResponse.Data[instrument]
+		if !ok {
+			reason := "no price from CCData"
+			if err != nil {
+				reason = fmt.Sprintf("CCData: %v", err)
+			}
+			if tick, found := lastPrices.ticks[instrument]; found {
+				currentInstrument, recovered = tick, true
+				report.fetched(SourcePrices, lastPrices.at[instrument])
+				report.warn(balance.Asset, LevelWarning, "price from %s, %s", lastPrices.at[instrument].UTC().Format(time.DateTime), reason)
+			} else {
+				report.warn(balance.Asset, LevelError, "no price, %s", reason)
+			}
+		}
 		assetValue := balance.Free * currentInstrument.Price
 		portfolioBalances = append(portfolioBalances, &WalletBalance{
 			Symbol:             balance.Asset,
 			QuoteSymbol:        currency,
 			Free:               balance.Free,
+			Locked:             balance.Locked,
 			QuoteValue:         assetValue,
 			Price:              currentInstrument.Price,
 			PriceFlag:          currentInstrument.PriceFlag,
@@ -258,45 +335,137 @@ func GetWalletBalancesAndCCData(currency string) ([]*WalletBalance, error) {
 			PriceChangePercent: currentInstrument.CurrentDayChangePercentage,
 		})
 	}
+	if err != nil && !recovered {
+		return nil, report, err
+	}
 	sort.Slice(portfolioBalances, func(i, j int) bool {
 		return portfolioBalances[i].Free > portfolioBalances[j].Free
 	})
-	return portfolioBalances, nil
+	return portfolioBalances, report, nil
+}
+
+func GetPortfolioBalancesAndCCData(ctx context.Context, currency string, walletBalances []*WalletBalance, tradeStore *TradeStore) ([]*PortfolioBalance, error) {
+	balances, report := GetAccountPortfolioBalances(ctx, currency, DefaultAccount(), walletBalances, tradeStore)
+	for _, warning := range report.Warnings {
+		log.Warn(warning)
+	}
+	return balances, nil
+}
+
+// GetAccountPortfolioBalances adds the trade stats of account to its wallet
+// balances, syncing tradeStore from the API on first use. Without a store,
+// e.g. for a sub-account, the stats only cover the current value. Pairs
+// whose trades couldn't be fetched are warnings of the report.
+func GetAccountPortfolioBalances(ctx context.Context, currency string, account Account, walletBalances []*WalletBalance, tradeStore *TradeStore) ([]*PortfolioBalance, Report) {
+	assets := make([]string, len(walletBalances))
+	for i, balance := range walletBalances {
+		assets[i] = balance.Symbol
+	}
+	failures := SyncAccountTrades(ctx, currency, account, assets, tradeStore)
+	return buildPortfolioBalances(currency, walletBalances, tradeStore, failures)
+}
+
+// maxTradePages bounds the /myTrades pages of one sync of a pair; the next
+// sync goes on from the last stored trade.
+const maxTradePages = 100
+
+// SyncAccountTrades fetches the trades of the pairs of assets in currency
+// that tradeStore hasn't synced yet, portfolio.fetch_workers at a time, and
+// returns the errors of the pairs that failed.
+func SyncAccountTrades(ctx context.Context, currency string, account Account, assets []string, tradeStore *TradeStore) map[string]error {
+	var pairs []string
+	for _, asset := range assets {
+		if asset != currency {
+			pairs = append(pairs, asset+currency)
+		}
+	}
+	return SyncAccountPairs(ctx, account, pairs, tradeStore)
+}
+
+// SyncAccountPairs is SyncAccountTrades for any pairs, e.g. the ones of
+// imported trades or of assets sold since. Each pair is fetched from the
+// trade after the last one stored.
+func SyncAccountPairs(ctx context.Context, account Account, pairs []string, tradeStore *TradeStore) map[string]error {
+	failures := make(map[string]error)
+	if tradeStore == nil {
+		return failures
+	}
+	var unsynced []string
+	for _, pair := range pairs {
+		if tradeStore.Synced(pair) {
+			log.Infof("%s: fetching from store.", pair)
+			continue
+		}
+		log.Warnf("%s: not synced. fetching API", pair)
+		unsynced = append(unsynced, pair)
+	}
+	pairs = unsynced
+	_, errs := fetchAll(ctx, pairs, config.FetchWorkers, config.BinanceRequestTimeout, func(ctx context.Context, pair string) (struct{}, error) {
+		trades, more, err := GetTradeHistoryFor(ctx, account, pair, tradeStore.NextTradeID(pair), maxTradePages)
+		if err != nil {
+			return struct{}{}, fmt.Errorf("fetching trades: %w", err)
+		}
+		if more {
+			log.Warnf("%s: more than %d pages of trades, the rest is fetched on the next sync", pair, maxTradePages)
+		}
+		if err := tradeStore.SyncTrades(pair, trades, more); err != nil {
+			return struct{}{}, fmt.Errorf("saving trades: %w", err)
+		}
+		return struct{}{}, nil
+	})
+	for i, err := range errs {
+		if err != nil {
+			log.Infof("%s: %v", pairs[i], err)
+			failures[pairs[i]] = err
+		}
+	}
+	return failures
 }
 
-func GetPortfolioBalancesAndCCData(currency string, walletBalances []*WalletBalance, assetToTradesInMemory map[string][]Trade) ([]*PortfolioBalance, error) {
-	var err error
+// buildPortfolioBalances works out the stats of each wallet balance from the
+// stored trades. A pair in failures keeps whatever trades were stored
+// before; with none, its PNL is left out and an error says why.
+func buildPortfolioBalances(currency string, walletBalances []*WalletBalance, tradeStore *TradeStore, failures map[string]error) ([]*PortfolioBalance, Report) {
 	var portfolioBalances []*PortfolioBalance
+	var report Report
 	for _, balance := range walletBalances {
-		binanceInstrument := fmt.Sprintf("%s%s", balance.Symbol, currency)
-		if _, ok := assetToTradesInMemory[binanceInstrument]; !ok {
-			log.Warnf("%s: not in memory. fetching API", binanceInstrument)
-			var assetTrades []Trade
-			assetTrades, err = GetTradesList(binanceInstrument, "1000")
-			if err != nil {
-				log.Errorf("%s: Error fetching trades: %v", binanceInstrument, err)
-				continue
+		pair := balance.Symbol + currency
+		var storedTrades []Trade
+		if tradeStore != nil {
+			storedTrades = tradeStore.Trades(pair)
+			report.fetched(SourceTrades, tradeStore.SyncedAt(pair))
+		}
+		if err, failed := failures[pair]; failed {
+			if len(storedTrades) == 0 {
+				report.warn(balance.Symbol, LevelError, "no PNL, %v", err)
+			} else {
+				report.warn(balance.Symbol, LevelWarning, "PNL from stored trades, %v", err)
 			}
-			assetToTradesInMemory[binanceInstrument] = assetTrades
-		} else {
-			log.Infof("%s: fetching from memory.", binanceInstrument)
+		} else if tradeStore != nil && tradeStore.Capped(pair) {
+			report.warn(balance.Symbol, LevelWarning, "PNL without the latest trades, more than %d pages to fetch, sync again for the rest", maxTradePages)
 		}
-		assetTradesMemStore := assetToTradesInMemory[binanceInstrument]
-		tradeStats := calculateTradeCosts(assetTradesMemStore)
-		portfolioBalances = append(portfolioBalances, &PortfolioBalance{
-			Symbol:             balance.Symbol,
-			QuoteSymbol:        currency,
-			Free:               balance.Free,
-			QuoteValue:         balance.QuoteValue,
-			Price:              balance.Price,
-			PriceFlag:          balance.PriceFlag,
-			PriceChangeValue:   balance.PriceChangeValue,
-			PriceChangePercent: balance.PriceChangePercent,
-			TradeStats:         tradeStats,
-		})
+		tradeStats := PortfolioTradeStats{}
+		if tradeStore != nil {
+			tradeStats = calculateTradeCosts(storedTrades)
+		}
+		fillPNLStats(&tradeStats, storedTrades, balance, currency)
+		portfolioBalances = append(portfolioBalances, newPortfolioBalance(balance, currency, tradeStats))
 	}
+	setPortfolioAllocations(portfolioBalances)
 	sort.Slice(portfolioBalances, func(i, j int) bool {
 		return portfolioBalances[i].Free > portfolioBalances[j].Free
 	})
-	return portfolioBalances, nil
+	return portfolioBalances, report
+}
+
+func setPortfolioAllocations(portfolioBalances []*PortfolioBalance) {
+	var totalValue float64
+	for _, balance := range portfolioBalances {
+		totalValue += balance.TradeStats.TotalValue
+	}
+	for _, balance := range portfolioBalances {
+		if totalValue > 0 {
+			balance.TradeStats.PortfolioAllocation = balance.TradeStats.TotalValue / totalValue * 100
+		}
+	}
 }
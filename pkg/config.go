@@ -0,0 +1,687 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	neturl "net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config holds the settings of the app. LoadConfig layers them from the
+// defaults, a TOML file, environment variables and command-line flags, each
+// overriding the one before.
+type Config struct {
+	ServerAddress string
+	// LocalhostOnly serves on 127.0.0.1 whatever the host of ServerAddress
+	LocalhostOnly bool
+	// SessionLifetime is how long a dashboard login lasts
+	SessionLifetime time.Duration
+	// UsersPath is the file of dashboard users and API tokens
+	UsersPath  string
+	Currency   string
+	CashAssets []string
+	// FiatCurrencies aren't assets for capital gains
+	FiatCurrencies []string
+	// USDStablecoins are valued at one dollar, Binance having no USD pairs
+	USDStablecoins  []string
+	TradeFetchLimit int
+	// OrderFetchLimit is how many of a pair's latest orders are fetched
+	OrderFetchLimit int
+	// FetchWorkers is how many pairs' trades are fetched at once
+	FetchWorkers int
+	// TaxHistoryStart is where the tax reports start reading deposits and
+	// withdrawals, so coins deposited before the first trade have a cost
+	TaxHistoryStart  time.Time
+	DashboardRefresh time.Duration
+	WatchRefresh     time.Duration
+	BinanceHost      string
+	BinanceAPIKey    string
+	BinanceSecretKey string
+	BinanceReadOnly  bool
+	// BinancePrivateKey is the PEM key of an RSA or Ed25519 API key, read
+	// from binance.private_key_file
+	BinancePrivateKey string
+	BinanceWSAPIURL   string
+	// BinanceRecvWindow is how long after its timestamp a signed request
+	// is valid
+	BinanceRecvWindow time.Duration
+	// BinanceTimeSync is how often to resync with the server time, 0 for
+	// only after a -1021 error
+	BinanceTimeSync time.Duration
+	// BinanceRequestTimeout bounds each call to Binance, and each pair's
+	// trades, rate limit waits included
+	BinanceRequestTimeout time.Duration
+	// BinanceSubAccounts are sub-account emails of the main account
+	BinanceSubAccounts []string
+	// Accounts are the main account followed by the [accounts.<name>] ones
+	Accounts      []Account
+	CCDataBaseURL string
+	CCDataAPIKey  string
+	// CCDataRequestTimeout bounds each call to CCData
+	CCDataRequestTimeout time.Duration
+	TradeStorePath       string
+	OrderAuditLog        string
+	RebalanceTargets     string
+	// KeystorePath is the encrypted credentials file, unlocked at startup
+	// when it exists
+	KeystorePath string
+	// LogLevel is empty unless set: serve then logs at info, the other
+	// commands at warn
+	LogLevel  string
+	LogFormat string
+	// KeystorePassphraseFile holds the passphrase for running without a
+	// terminal; otherwise it's asked for
+	KeystorePassphraseFile string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ServerAddress:         ":42000",
+		SessionLifetime:       12 * time.Hour,
+		UsersPath:             "users.json",
+		Currency:              "USDT",
+		CashAssets:            []string{"USDT", "GBP", "USD"},
+		FiatCurrencies:        []string{"GBP", "USD", "EUR", "TRY", "BRL", "AUD"},
+		USDStablecoins:        []string{"USDT", "USDC", "BUSD", "FDUSD", "TUSD", "DAI"},
+		TradeFetchLimit:       1000,
+		OrderFetchLimit:       1000,
+		FetchWorkers:          8,
+		TaxHistoryStart:       time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC),
+		DashboardRefresh:      50 * time.Second,
+		WatchRefresh:          15 * time.Second,
+		BinanceHost:           "https://api.binance.com",
+		BinanceWSAPIURL:       "wss://ws-api.binance.com:443/ws-api/v3",
+		BinanceRecvWindow:     5 * time.Second,
+		BinanceTimeSync:       10 * time.Minute,
+		BinanceRequestTimeout: time.Minute,
+		BinanceReadOnly:       true,
+		CCDataBaseURL:         "https://data-api.ccdata.io",
+		CCDataRequestTimeout:  30 * time.Second,
+		TradeStorePath:        "trades-store.json",
+		OrderAuditLog:         "orders-audit.jsonl",
+		KeystorePath:          "keystore.json",
+		LogFormat:             "text",
+	}
+}
+
+var config = DefaultConfig()
+
+// SetConfig makes c the configuration used by the API clients.
+func SetConfig(c Config) {
+	config = c
+	SetBinanceHost(c.BinanceHost)
+	SetCCDataBaseURL(c.CCDataBaseURL)
+}
+
+// IsCashAsset reports whether asset is held as cash, i.e. valued at face
+// value rather than priced against the base currency.
+func (c Config) IsCashAsset(asset string) bool {
+	return asset == c.Currency || slices.Contains(c.CashAssets, asset)
+}
+
+// IsFiat reports whether asset is a fiat currency.
+func (c Config) IsFiat(asset string) bool {
+	return slices.Contains(c.FiatCurrencies, asset)
+}
+
+// IsUSDStablecoin reports whether asset is worth one dollar.
+func (c Config) IsUSDStablecoin(asset string) bool {
+	return slices.Contains(c.USDStablecoins, asset)
+}
+
+var assetPattern = regexp.MustCompile(`^[A-Z0-9]{2,12}$`)
+
+func parseAsset(value string) (string, error) {
+	asset := strings.ToUpper(strings.TrimSpace(value))
+	if !assetPattern.MatchString(asset) {
+		return "", fmt.Errorf("%q is not an asset symbol", value)
+	}
+	return asset, nil
+}
+
+// parseAssets reads a comma-separated list of assets.
+func parseAssets(value string) ([]string, error) {
+	var assets []string
+	for _, part := range strings.Split(value, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		asset, err := parseAsset(part)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+func parseFetchLimit(value string) (int, error) {
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > 1000 {
+		return 0, fmt.Errorf("%q is not a number between 1 and 1000", value)
+	}
+	return limit, nil
+}
+
+func parseRefresh(value string) (time.Duration, error) {
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration like 30s or 2m", value)
+	}
+	if interval < time.Second {
+		return 0, fmt.Errorf("%s is shorter than 1s", interval)
+	}
+	return interval, nil
+}
+
+func parseHTTPURL(value string) (string, error) {
+	url, err := neturl.Parse(value)
+	if err != nil || (url.Scheme != "http" && url.Scheme != "https") || url.Host == "" {
+		return "", fmt.Errorf("%q is not an http(s) URL", value)
+	}
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+func parseEmails(value string) ([]string, error) {
+	var emails []string
+	for _, email := range strings.Split(value, ",") {
+		if email = strings.TrimSpace(email); email == "" {
+			continue
+		}
+		if !strings.Contains(email, "@") {
+			return nil, fmt.Errorf("%q is not a sub-account email", email)
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+// readPrivateKeyFile returns the PEM private key in path once it's known to
+// be a usable RSA or Ed25519 key.
+func readPrivateKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ParsePrivateKeySigner(data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func parsePath(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", errors.New("path is empty")
+	}
+	return value, nil
+}
+
+// configSetting ties a Config field to its key in the file, its environment
+// variable and its flag. Secrets have no flag so they don't end up in the
+// shell history or the process list.
+type configSetting struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var configSettings = []configSetting{
+	{"server.address", "SERVER_ADDRESS", "addr", "host:port to serve the dashboard on", func(c *Config, value string) error {
+		host, port, err := net.SplitHostPort(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a host:port address", value)
+		}
+		if number, err := strconv.Atoi(port); err != nil || number < 0 || number > 65535 {
+			return fmt.Errorf("%q is not a port", port)
+		}
+		c.ServerAddress = net.JoinHostPort(host, port)
+		return nil
+	}},
+	{"server.localhost_only", "SERVER_LOCALHOST_ONLY", "localhost", "serve on 127.0.0.1 only, whatever the host of server.address", func(c *Config, value string) error {
+		localhostOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		c.LocalhostOnly = localhostOnly
+		return nil
+	}},
+	{"server.session_lifetime", "SESSION_LIFETIME", "session-lifetime", "how long a dashboard login lasts", func(c *Config, value string) (err error) {
+		c.SessionLifetime, err = parseRefresh(value)
+		return err
+	}},
+	{"portfolio.currency", "BASE_CURRENCY", "currency", "asset the portfolio is valued in", func(c *Config, value string) (err error) {
+		c.Currency, err = parseAsset(value)
+		return err
+	}},
+	{"portfolio.cash_assets", "CASH_ASSETS", "cash", "comma-separated assets held as cash and not priced", func(c *Config, value string) (err error) {
+		c.CashAssets, err = parseAssets(value)
+		return err
+	}},
+	{"portfolio.fiat_currencies", "FIAT_CURRENCIES", "fiat", "comma-separated fiat currencies, not assets for capital gains", func(c *Config, value string) (err error) {
+		c.FiatCurrencies, err = parseAssets(value)
+		return err
+	}},
+	{"portfolio.usd_stablecoins", "USD_STABLECOINS", "usd-stablecoins", "comma-separated stablecoins valued at one dollar", func(c *Config, value string) (err error) {
+		c.USDStablecoins, err = parseAssets(value)
+		return err
+	}},
+	{"portfolio.trade_fetch_limit", "TRADE_FETCH_LIMIT", "trade-limit", "trades per /myTrades request, paged by fromId, 1 to 1000", func(c *Config, value string) (err error) {
+		c.TradeFetchLimit, err = parseFetchLimit(value)
+		return err
+	}},
+	{"portfolio.order_fetch_limit", "ORDER_FETCH_LIMIT", "order-limit", "latest orders fetched per pair, 1 to 1000", func(c *Config, value string) (err error) {
+		c.OrderFetchLimit, err = parseFetchLimit(value)
+		return err
+	}},
+	{"portfolio.fetch_workers", "FETCH_WORKERS", "fetch-workers", "pairs whose trades are fetched at once, 1 to 32", func(c *Config, value string) error {
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers < 1 || workers > 32 {
+			return fmt.Errorf("%q is not a number between 1 and 32", value)
+		}
+		c.FetchWorkers = workers
+		return nil
+	}},
+	{"tax.history_start", "TAX_HISTORY_START", "tax-history-start", "date (YYYY-MM-DD, UTC) the tax reports read deposits and withdrawals from", func(c *Config, value string) error {
+		start, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return fmt.Errorf("%q is not a date like 2020-01-31", value)
+		}
+		c.TaxHistoryStart = start
+		return nil
+	}},
+	{"refresh.dashboard", "DASHBOARD_REFRESH", "dashboard-refresh", "how often the dashboard reloads the portfolio", func(c *Config, value string) (err error) {
+		c.DashboardRefresh, err = parseRefresh(value)
+		return err
+	}},
+	{"refresh.watch", "WATCH_REFRESH", "watch-refresh", "how often the watch command refreshes", func(c *Config, value string) (err error) {
+		c.WatchRefresh, err = parseRefresh(value)
+		return err
+	}},
+	{"binance.host", "BINANCE_HOST", "binance-host", "Binance API host, e.g. the testnet or cmd/fakebinance", func(c *Config, value string) (err error) {
+		c.BinanceHost, err = parseHTTPURL(value)
+		return err
+	}},
+	{"binance.api_key", "BINANCE_API_KEY", "", "", func(c *Config, value string) error {
+		c.BinanceAPIKey = value
+		return nil
+	}},
+	{"binance.secret_key", "BINANCE_SECRET_KEY", "", "", func(c *Config, value string) error {
+		c.BinanceSecretKey = value
+		return nil
+	}},
+	{"binance.private_key_file", "BINANCE_PRIVATE_KEY_FILE", "", "", func(c *Config, value string) (err error) {
+		c.BinancePrivateKey, err = readPrivateKeyFile(value)
+		return err
+	}},
+	{"binance.ws_api_url", "BINANCE_WS_API_URL", "binance-ws-api", "Binance WebSocket API URL", func(c *Config, value string) error {
+		url, err := neturl.Parse(value)
+		if err != nil || (url.Scheme != "ws" && url.Scheme != "wss") || url.Host == "" {
+			return fmt.Errorf("%q is not a ws(s) URL", value)
+		}
+		c.BinanceWSAPIURL = value
+		return nil
+	}},
+	{"binance.recv_window", "BINANCE_RECV_WINDOW", "recv-window", "how long a signed request stays valid, 1ms to 60s", func(c *Config, value string) error {
+		recvWindow, err := time.ParseDuration(value)
+		if err != nil || recvWindow < time.Millisecond || recvWindow > time.Minute {
+			return fmt.Errorf("%q is not a duration between 1ms and 60s", value)
+		}
+		c.BinanceRecvWindow = recvWindow
+		return nil
+	}},
+	{"binance.time_sync", "BINANCE_TIME_SYNC", "time-sync", "how often to sync with the Binance server time, 0 for only after a -1021 error", func(c *Config, value string) error {
+		if value == "0" {
+			c.BinanceTimeSync = 0
+			return nil
+		}
+		interval, err := parseRefresh(value)
+		c.BinanceTimeSync = interval
+		return err
+	}},
+	{"binance.request_timeout", "BINANCE_REQUEST_TIMEOUT", "request-timeout", "how long a Binance call, or fetching a pair's trades, may take, rate limit waits included", func(c *Config, value string) (err error) {
+		c.BinanceRequestTimeout, err = parseRefresh(value)
+		return err
+	}},
+	{"binance.sub_accounts", "BINANCE_SUB_ACCOUNTS", "", "", func(c *Config, value string) (err error) {
+		c.BinanceSubAccounts, err = parseEmails(value)
+		return err
+	}},
+	{"binance.read_only", "BINANCE_READ_ONLY", "read-only", "refuse to place or cancel orders", func(c *Config, value string) error {
+		readOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		c.BinanceReadOnly = readOnly
+		return nil
+	}},
+	{"ccdata.base_url", "CC_BASE_URL", "ccdata-url", "CCData API base URL", func(c *Config, value string) (err error) {
+		c.CCDataBaseURL, err = parseHTTPURL(value)
+		return err
+	}},
+	{"ccdata.request_timeout", "CC_REQUEST_TIMEOUT", "ccdata-timeout", "how long a CCData call may take", func(c *Config, value string) (err error) {
+		c.CCDataRequestTimeout, err = parseRefresh(value)
+		return err
+	}},
+	{"ccdata.api_key", "CC_API_KEY", "", "", func(c *Config, value string) error {
+		c.CCDataAPIKey = value
+		return nil
+	}},
+	{"storage.trade_store", "TRADE_STORE_PATH", "trade-store", "file keeping synced and imported trades", func(c *Config, value string) (err error) {
+		c.TradeStorePath, err = parsePath(value)
+		return err
+	}},
+	{"storage.order_audit_log", "ORDER_AUDIT_LOG", "audit-log", "file every order submission is appended to", func(c *Config, value string) (err error) {
+		c.OrderAuditLog, err = parsePath(value)
+		return err
+	}},
+	{"storage.keystore", "KEYSTORE_PATH", "keystore", "encrypted file with the API keys, see the keys command", func(c *Config, value string) (err error) {
+		c.KeystorePath, err = parsePath(value)
+		return err
+	}},
+	{"storage.keystore_passphrase_file", "KEYSTORE_PASSPHRASE_FILE", "passphrase-file", "file with the keystore passphrase, instead of asking on the terminal", func(c *Config, value string) (err error) {
+		c.KeystorePassphraseFile, err = parsePath(value)
+		return err
+	}},
+	{"storage.users", "USERS_PATH", "users-file", "file with the dashboard users and API tokens, see the users and tokens commands", func(c *Config, value string) (err error) {
+		c.UsersPath, err = parsePath(value)
+		return err
+	}},
+	{"log.level", "LOG_LEVEL", "log-level", "debug, info, warn or error", func(c *Config, value string) error {
+		if _, err := log.ParseLevel(value); err != nil {
+			return fmt.Errorf("%q is not debug, info, warn or error", value)
+		}
+		c.LogLevel = strings.ToLower(value)
+		return nil
+	}},
+	{"log.format", "LOG_FORMAT", "log-format", "text or json", func(c *Config, value string) error {
+		if value != "text" && value != "json" {
+			return fmt.Errorf("%q is not text or json", value)
+		}
+		c.LogFormat = value
+		return nil
+	}},
+	{"rebalance.targets", "REBALANCE_TARGETS", "rebalance-targets", "asset:weight%:tolerance% list, e.g. BTC:50:5,ETH:30:5", func(c *Config, value string) error {
+		if _, err := ParseAllocationTargets(value); err != nil {
+			return err
+		}
+		c.RebalanceTargets = value
+		return nil
+	}},
+}
+
+type configSource struct {
+	name  string
+	value string
+	ok    bool
+}
+
+// ConfigFlags registers -config and a flag per setting on flags. The values
+// are only applied by LoadConfig, so flags left unset don't override the
+// file or the environment.
+func ConfigFlags(flags *flag.FlagSet) map[string]*string {
+	values := map[string]*string{
+		"config": flags.String("config", "", "TOML config file (default config.toml when it exists, or PORTFOLIO_CONFIG)"),
+	}
+	for _, setting := range configSettings {
+		if setting.flag != "" {
+			values[setting.flag] = flags.String(setting.flag, "", fmt.Sprintf("%s (%s, env %s)", setting.usage, setting.key, setting.env))
+		}
+	}
+	return values
+}
+
+// LoadConfig builds the configuration from the defaults, the config file,
+// the environment and the flags that were set, reporting every invalid value
+// with where it came from.
+func LoadConfig(flags *flag.FlagSet, values map[string]*string) (Config, error) {
+	c := DefaultConfig()
+	setFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	path, explicit := os.Getenv("PORTFOLIO_CONFIG"), true
+	if setFlags["config"] {
+		path = *values["config"]
+	} else if path == "" {
+		path, explicit = "config.toml", false
+	}
+	fileValues := make(map[string]string)
+	file, err := os.Open(path)
+	switch {
+	case err == nil:
+		fileValues, err = parseTOML(file, path)
+		file.Close()
+		if err != nil {
+			return c, err
+		}
+	case explicit || !errors.Is(err, os.ErrNotExist):
+		return c, fmt.Errorf("config file: %w", err)
+	}
+
+	var errs []error
+	known := make(map[string]bool)
+	for _, setting := range configSettings {
+		known[setting.key] = true
+		sources := []configSource{
+			{path, fileValues[setting.key], fileValues[setting.key] != ""},
+			// empty variables, e.g. the blank keys in .env, count as unset
+			{setting.env, os.Getenv(setting.env), os.Getenv(setting.env) != ""},
+		}
+		if setting.flag != "" {
+			sources = append(sources, configSource{"-" + setting.flag, *values[setting.flag], setFlags[setting.flag]})
+		}
+		for _, source := range sources {
+			if !source.ok {
+				continue
+			}
+			if err := setting.set(&c, strings.TrimSpace(source.value)); err != nil {
+				errs = append(errs, fmt.Errorf("%s (from %s): %w", setting.key, source.name, err))
+			}
+		}
+	}
+	c.Accounts = []Account{{Name: MainAccount, APIKey: c.BinanceAPIKey, SecretKey: c.BinanceSecretKey, PrivateKey: c.BinancePrivateKey, SubAccounts: c.BinanceSubAccounts}}
+	accounts, accountKeys, accountErrs := loadAccounts(path, fileValues)
+	c.Accounts = append(c.Accounts, accounts...)
+	errs = append(errs, accountErrs...)
+	for key := range fileValues {
+		if !known[key] && !accountKeys[key] {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %s", path, key))
+		}
+	}
+	if len(c.CashAssets) == 0 {
+		errs = append(errs, errors.New("portfolio.cash_assets: at least one asset is needed"))
+	}
+	return c, errors.Join(errs...)
+}
+
+// UnsetSecretEnv removes the API keys from the environment once they're
+// loaded, so child processes don't inherit them. Keys that should never be
+// in the environment belong in the keystore.
+func UnsetSecretEnv() {
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if name == "CC_API_KEY" || (strings.HasPrefix(name, "BINANCE_") && (strings.HasSuffix(name, "_API_KEY") || strings.HasSuffix(name, "_SECRET_KEY"))) {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+var accountNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// loadAccounts reads the accounts besides the main one: [accounts.<name>]
+// tables in the file, and the names in BINANCE_ACCOUNTS with their
+// BINANCE_<NAME>_API_KEY, _SECRET_KEY, _PRIVATE_KEY_FILE and _SUB_ACCOUNTS
+// variables. It also returns the file keys it used.
+func loadAccounts(path string, fileValues map[string]string) ([]Account, map[string]bool, []error) {
+	var names []string
+	seen := make(map[string]bool)
+	addName := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	used := make(map[string]bool)
+	for key := range fileValues {
+		rest, ok := strings.CutPrefix(key, "accounts.")
+		if !ok {
+			continue
+		}
+		name, field, _ := strings.Cut(rest, ".")
+		if field == "api_key" || field == "secret_key" || field == "private_key_file" || field == "sub_accounts" {
+			used[key] = true
+			addName(name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range strings.Split(os.Getenv("BINANCE_ACCOUNTS"), ",") {
+		addName(strings.ToLower(strings.TrimSpace(name)))
+	}
+
+	var accounts []Account
+	var errs []error
+	for _, name := range names {
+		if !accountNamePattern.MatchString(name) || name == MainAccount || name == AllAccounts || name == CCDataCredential {
+			errs = append(errs, fmt.Errorf("accounts.%s: names are lowercase letters, digits, _ and -, other than %s, %s and %s", name, MainAccount, AllAccounts, CCDataCredential))
+			continue
+		}
+		account := Account{Name: name}
+		envPrefix := "BINANCE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		for _, field := range []string{"api_key", "secret_key", "private_key_file", "sub_accounts"} {
+			key, env := "accounts."+name+"."+field, envPrefix+strings.ToUpper(field)
+			for _, source := range []configSource{{path, fileValues[key], fileValues[key] != ""}, {env, os.Getenv(env), os.Getenv(env) != ""}} {
+				if !source.ok {
+					continue
+				}
+				value := strings.TrimSpace(source.value)
+				switch field {
+				case "api_key":
+					account.APIKey = value
+				case "secret_key":
+					account.SecretKey = value
+				case "private_key_file":
+					privateKey, err := readPrivateKeyFile(value)
+					if err != nil {
+						errs = append(errs, fmt.Errorf("%s (from %s): %w", key, source.name, err))
+					}
+					account.PrivateKey = privateKey
+				case "sub_accounts":
+					emails, err := parseEmails(value)
+					if err != nil {
+						errs = append(errs, fmt.Errorf("%s (from %s): %w", key, source.name, err))
+					}
+					account.SubAccounts = emails
+				}
+			}
+		}
+		// keys may come from the keystore instead, see CheckCredentials
+		accounts = append(accounts, account)
+	}
+	return accounts, used, errs
+}
+
+// parseTOML reads the subset of TOML the config needs: [section] tables and
+// key = value pairs with string, number, boolean or single-line array
+// values. Values come back as strings keyed "section.key", arrays joined by
+// commas.
+func parseTOML(r io.Reader, name string) (map[string]string, error) {
+	values := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(stripTOMLComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: unterminated table header", name, number)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", name, number)
+		}
+		key = strings.TrimSpace(key)
+		if section != "" {
+			key = section + "." + key
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", name, number, key, err)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is set twice", name, number, key)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+func stripTOMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote && (quote == '\'' || i == 0 || line[i-1] != '\\'):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", errors.New("missing value")
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", errors.New("unterminated string")
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", errors.New("arrays must be on one line")
+		}
+		var items []string
+		for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			value, err := parseTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(raw, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("%s is not a string, number, boolean or array", raw)
+	}
+	return strings.ReplaceAll(raw, "_", ""), nil
+}
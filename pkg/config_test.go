@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestConfig loads the config with file as config.toml, the environment
+// variables of env and args as flags.
+func loadTestConfig(t *testing.T, file string, env map[string]string, args ...string) (Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PORTFOLIO_CONFIG", path)
+	for _, setting := range configSettings {
+		t.Setenv(setting.env, env[setting.env])
+	}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	values := ConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(flags, values)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := "[portfolio]\ncurrency = \"BTC\"\ntrade_fetch_limit = 200\n"
+	tests := []struct {
+		name         string
+		env          map[string]string
+		args         []string
+		wantCurrency string
+		wantLimit    int
+	}{
+		{"file over defaults", nil, nil, "BTC", 200},
+		{"environment over file", map[string]string{"BASE_CURRENCY": "eth"}, nil, "ETH", 200},
+		{"flag over environment", map[string]string{"BASE_CURRENCY": "ETH"}, []string{"-currency", "GBP"}, "GBP", 200},
+		{"empty variable is unset", map[string]string{"BASE_CURRENCY": "", "TRADE_FETCH_LIMIT": "300"}, nil, "BTC", 300},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := loadTestConfig(t, file, test.env, test.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Currency != test.wantCurrency || c.TradeFetchLimit != test.wantLimit {
+				t.Errorf("currency %s, trade fetch limit %d, want %s and %d", c.Currency, c.TradeFetchLimit, test.wantCurrency, test.wantLimit)
+			}
+			if c.OrderFetchLimit != DefaultConfig().OrderFetchLimit {
+				t.Errorf("order fetch limit %d, want the default", c.OrderFetchLimit)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want []string
+	}{
+		{"invalid value from the file", "[portfolio]\ntrade_fetch_limit = 5000\n", nil, nil, []string{`portfolio.trade_fetch_limit (from `, `"5000" is not a number between 1 and 1000`}},
+		{"invalid value from the environment", "", map[string]string{"TRADE_FETCH_LIMIT": "0"}, nil, []string{"portfolio.trade_fetch_limit (from TRADE_FETCH_LIMIT)"}},
+		{"invalid value from a flag", "", nil, []string{"-addr", "nowhere"}, []string{"server.address (from -addr)"}},
+		{"every invalid value", "[refresh]\ndashboard = \"10ms\"\n", map[string]string{"BASE_CURRENCY": "not an asset"}, nil, []string{"refresh.dashboard", "portfolio.currency"}},
+		{"unknown setting", "[portfolio]\ncurency = \"BTC\"\n", nil, nil, []string{"unknown setting portfolio.curency"}},
+		{"no cash assets", "", map[string]string{"CASH_ASSETS": " , "}, nil, []string{"at least one asset"}},
+		{"invalid tax history start", "[tax]\nhistory_start = \"2020-13-01\"\n", nil, nil, []string{"tax.history_start"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, test.file, test.env, test.args...)
+			if err == nil {
+				t.Fatalf("no error, want %q", test.want)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML(strings.NewReader(`
+# comment
+top = 1
+[server]
+address = "127.0.0.1:42000" # trailing comment
+name = 'a # not a comment'
+[portfolio]
+cash_assets = ["USDT", 'GBP', ]
+trade_fetch_limit = 1_000
+read_only = true
+`), "config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"top":                         "1",
+		"server.address":              "127.0.0.1:42000",
+		"server.name":                 "a # not a comment",
+		"portfolio.cash_assets":       "USDT,GBP",
+		"portfolio.trade_fetch_limit": "1000",
+		"portfolio.read_only":         "true",
+	}
+	if len(values) != len(want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s = %q, want %q", key, values[key], value)
+		}
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := map[string]string{
+		"[server\n":                   "config.toml:1: unterminated table header",
+		"address\n":                   "config.toml:1: expected key = value",
+		"address =\n":                 "config.toml:1: address: missing value",
+		"\n[server]\naddress = \"a\n": "config.toml:3: server.address:",
+		"name = 'a\n":                 "config.toml:1: name: unterminated string",
+		"list = [\"a\",\n":            "config.toml:1: list: arrays must be on one line",
+		"limit = ten\n":               "config.toml:1: limit: ten is not a string, number, boolean or array",
+		"a = 1\na = 2\n":              "config.toml:2: a is set twice",
+		"[x]\na = [\"b\", c]\n":       "config.toml:2: x.a: c is not a string",
+		"[server]\naddress = 1\n[server]\naddress = 2\n": "config.toml:4: server.address is set twice",
+	}
+	for file, want := range tests {
+		_, err := parseTOML(strings.NewReader(file), "config.toml")
+		if err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("parseTOML(%q) = %v, want %q", file, err, want)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ExportFormat string
+
+const (
+	ExportCSV   ExportFormat = "csv"
+	ExportJSONL ExportFormat = "jsonl"
+	ExportXLSX  ExportFormat = "xlsx"
+)
+
+func ParseExportFormat(value string) (ExportFormat, error) {
+	switch format := ExportFormat(strings.ToLower(strings.TrimSpace(value))); format {
+	case "":
+		return ExportCSV, nil
+	case ExportCSV, ExportJSONL, ExportXLSX:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown export format %q, use csv, jsonl or xlsx", value)
+}
+
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportJSONL:
+		return "application/x-ndjson"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv"
+}
+
+// ExportFilter narrows an export down to some assets and a time range (ms,
+// inclusive). Zero values don't filter.
+type ExportFilter struct {
+	Assets map[string]bool
+	From   int64
+	To     int64
+}
+
+// NewExportFilter parses a comma separated asset list and from/to dates
+// (YYYY-MM-DD, both inclusive, UTC). Empty values don't filter.
+func NewExportFilter(assets string, from string, to string) (ExportFilter, error) {
+	var filter ExportFilter
+	if assets = strings.TrimSpace(assets); assets != "" {
+		filter.Assets = make(map[string]bool)
+		for _, asset := range strings.Split(strings.ToUpper(assets), ",") {
+			filter.Assets[strings.TrimSpace(asset)] = true
+		}
+	}
+	if from != "" {
+		day, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			return filter, fmt.Errorf("from must be YYYY-MM-DD")
+		}
+		filter.From = day.UnixMilli()
+	}
+	if to != "" {
+		day, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return filter, fmt.Errorf("to must be YYYY-MM-DD")
+		}
+		filter.To = day.AddDate(0, 0, 1).UnixMilli() - 1
+	}
+	return filter, nil
+}
+
+func (f ExportFilter) asset(assets ...string) bool {
+	if len(f.Assets) == 0 {
+		return true
+	}
+	for _, asset := range assets {
+		if f.Assets[asset] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f ExportFilter) time(ts int64) bool {
+	return (f.From == 0 || ts >= f.From) && (f.To == 0 || ts <= f.To)
+}
+
+// ExportTable is a dataset ready to be written in any ExportFormat. Cells are
+// strings, bools, ints, float64s or time.Times.
+type ExportTable struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+func exportCellString(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+// Write writes the table as CSV with a header row, as one JSON object per row
+// with the columns as keys, or as an XLSX sheet.
+func (t ExportTable) Write(w io.Writer, format ExportFormat) error {
+	switch format {
+	case ExportJSONL:
+		for _, row := range t.Rows {
+			var line bytes.Buffer
+			line.WriteByte('{')
+			for i, value := range row {
+				if i > 0 {
+					line.WriteByte(',')
+				}
+				switch v := value.(type) {
+				case float64:
+					if math.IsNaN(v) || math.IsInf(v, 0) {
+						value = nil
+					}
+				case time.Time:
+					value = exportCellString(v)
+				}
+				key, _ := json.Marshal(t.Columns[i])
+				cell, err := json.Marshal(value)
+				if err != nil {
+					return err
+				}
+				line.Write(key)
+				line.WriteByte(':')
+				line.Write(cell)
+			}
+			line.WriteString("}\n")
+			if _, err := w.Write(line.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ExportXLSX:
+		rows := [][]interface{}{make([]interface{}, len(t.Columns))}
+		for i, column := range t.Columns {
+			rows[0][i] = column
+		}
+		for _, row := range t.Rows {
+			cells := make([]interface{}, len(row))
+			for i, value := range row {
+				cells[i] = value
+				if ts, ok := value.(time.Time); ok {
+					cells[i] = exportCellString(ts)
+				}
+			}
+			rows = append(rows, cells)
+		}
+		return writeXLSX(w, t.Name, rows)
+	}
+	writer := csv.NewWriter(w)
+	writer.Write(t.Columns)
+	for _, row := range t.Rows {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = exportCellString(value)
+		}
+		writer.Write(cells)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func HoldingsTable(walletBalances []*WalletBalance, filter ExportFilter) ExportTable {
+	table := ExportTable{
+		Name:    "holdings",
+		Columns: []string{"symbol", "quote_symbol", "free", "locked", "price", "price_flag", "price_change_value", "price_change_percent", "quote_value"},
+	}
+	for _, balance := range walletBalances {
+		if !filter.asset(balance.Symbol) {
+			continue
+		}
+		table.Rows = append(table.Rows, []interface{}{balance.Symbol, balance.QuoteSymbol, balance.Free, balance.Locked, balance.Price, balance.PriceFlag, balance.PriceChangeValue, balance.PriceChangePercent, balance.QuoteValue})
+	}
+	return table
+}
+
+// PortfolioStatsTable flattens the TradeStats of each PortfolioBalance into
+// columns, e.g. Buy.Highest.Price becomes buy_highest_price.
+func PortfolioStatsTable(portfolioBalances []*PortfolioBalance, filter ExportFilter) ExportTable {
+	table := ExportTable{
+		Name: "stats",
+		Columns: []string{
+			"symbol", "quote_symbol", "free", "locked", "price", "price_flag", "price_change_value", "price_change_percent", "quote_value",
+			"total_value", "avg_buy_price", "daily_pnl", "unrealized_pnl", "realized_pnl", "portfolio_allocation",
+		},
+	}
+	for _, side := range []string{"buy", "sale"} {
+		for _, column := range []string{"total_cost", "total_gain", "qty", "last_price", "last_time", "highest_price", "highest_time", "lowest_price", "lowest_time"} {
+			table.Columns = append(table.Columns, side+"_"+column)
+		}
+	}
+	table.Columns = append(table.Columns, "maker_qty", "taker_qty")
+	sideCells := func(side PortfolioTradeSideStats) []interface{} {
+		tradeTime := func(ts int) interface{} {
+			if ts == 0 {
+				return ""
+			}
+			return time.UnixMilli(int64(ts))
+		}
+		return []interface{}{
+			side.TotalCost, side.TotalGain, side.Qty,
+			side.Last.Price, tradeTime(side.Last.Timestamp),
+			side.Highest.Price, tradeTime(side.Highest.Timestamp),
+			side.Lowest.Price, tradeTime(side.Lowest.Timestamp),
+		}
+	}
+	for _, balance := range portfolioBalances {
+		if !filter.asset(balance.Symbol) {
+			continue
+		}
+		stats := balance.TradeStats
+		row := []interface{}{
+			balance.Symbol, balance.QuoteSymbol, balance.Free, balance.Locked, balance.Price, balance.PriceFlag, balance.PriceChangeValue, balance.PriceChangePercent, balance.QuoteValue,
+			stats.TotalValue, stats.AvgBuyPrice, stats.DailyPNL, stats.UnrealizedPNL, stats.RealizedPNL, stats.PortfolioAllocation,
+		}
+		row = append(row, sideCells(stats.Buy)...)
+		row = append(row, sideCells(stats.Sale)...)
+		row = append(row, stats.LiquidityProvider.MakerQty, stats.LiquidityProvider.TakerQty)
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+func TradesTable(assetToTrades map[string][]Trade, filter ExportFilter) ExportTable {
+	table := ExportTable{
+		Name:    "trades",
+		Columns: []string{"time", "symbol", "base_asset", "quote_asset", "side", "price", "qty", "quote_qty", "commission", "commission_asset", "is_maker", "trade_id", "order_id", "source"},
+	}
+	for _, trade := range FilterTrades(assetToTrades, filter) {
+		base, quote := SplitSymbol(trade.Symbol)
+		side, source := "SELL", "api"
+		if trade.IsBuyer {
+			side = "BUY"
+		}
+		if trade.ID < 0 {
+			source = "import"
+		}
+		price, _ := strconv.ParseFloat(trade.Price, 64)
+		qty, _ := strconv.ParseFloat(trade.Qty, 64)
+		quoteQty, _ := strconv.ParseFloat(trade.QuoteQty, 64)
+		commission, _ := strconv.ParseFloat(trade.Commission, 64)
+		table.Rows = append(table.Rows, []interface{}{time.UnixMilli(int64(trade.Time)), trade.Symbol, base, quote, side, price, qty, quoteQty, commission, trade.CommissionAsset, trade.IsMaker, trade.ID, trade.OrderId, source})
+	}
+	return table
+}
+
+// RealizedLot is a sell matched with the buy it closes, in the quote asset of
+// the pair.
+type RealizedLot struct {
+	Symbol     string  `json:"symbol"`
+	BaseAsset  string  `json:"base_asset"`
+	QuoteAsset string  `json:"quote_asset"`
+	Qty        float64 `json:"qty"`
+	BoughtAt   int64   `json:"bought_at"`
+	SoldAt     int64   `json:"sold_at"`
+	CostBasis  float64 `json:"cost_basis"`
+	Proceeds   float64 `json:"proceeds"`
+	PNL        float64 `json:"pnl"`
+	Unmatched  bool    `json:"unmatched"`
+}
+
+// pairLotEvents turns the trades of one pair into lot events in the quote
+// asset, oldest first. Fees count when paid in the quote asset and are taken
+// off the quantity bought when paid in the base asset.
+func pairLotEvents(symbol string, trades []Trade) []TaxEvent {
+	base, quote := SplitSymbol(symbol)
+	var events []TaxEvent
+	for _, trade := range trades {
+		qty, _ := strconv.ParseFloat(trade.Qty, 64)
+		quoteQty, _ := strconv.ParseFloat(trade.QuoteQty, 64)
+		commission, _ := strconv.ParseFloat(trade.Commission, 64)
+		event := TaxEvent{Kind: TaxDisposal, Asset: symbol, Time: int64(trade.Time), Qty: qty, Value: quoteQty}
+		if trade.IsBuyer {
+			event.Kind = TaxAcquisition
+			if trade.CommissionAsset == base {
+				event.Qty -= commission
+			}
+		}
+		if trade.CommissionAsset == quote && quote != "" {
+			event.Fee = commission
+		}
+		events = append(events, event)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time < events[j].Time
+	})
+	return events
+}
+
+// RealizedPNLLots matches the sells of each pair with its buys using method.
+// Unlike the tax reports no fiat prices are needed: everything stays in the
+// quote asset of the pair.
+func RealizedPNLLots(assetToTrades map[string][]Trade, method LotMethod) []RealizedLot {
+	var lots []RealizedLot
+	for symbol, trades := range assetToTrades {
+		base, quote := SplitSymbol(symbol)
+		disposals, _ := MatchLots(pairLotEvents(symbol, trades), method)
+		for _, disposal := range disposals {
+			lots = append(lots, RealizedLot{
+				Symbol:     symbol,
+				BaseAsset:  base,
+				QuoteAsset: quote,
+				Qty:        disposal.Qty,
+				BoughtAt:   disposal.AcquiredAt,
+				SoldAt:     disposal.DisposedAt,
+				CostBasis:  disposal.CostBasis,
+				Proceeds:   disposal.Proceeds,
+				PNL:        disposal.Gain,
+				Unmatched:  disposal.Unmatched,
+			})
+		}
+	}
+	return lots
+}
+
+// FilterLots keeps the lots of the assets of filter sold in its time range,
+// in the order they were sold.
+func FilterLots(lots []RealizedLot, filter ExportFilter) []RealizedLot {
+	var matching []RealizedLot
+	for _, lot := range lots {
+		if filter.asset(lot.Symbol, lot.BaseAsset, lot.QuoteAsset) && filter.time(lot.SoldAt) {
+			matching = append(matching, lot)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].SoldAt < matching[j].SoldAt
+	})
+	return matching
+}
+
+func RealizedLotsTable(lots []RealizedLot, filter ExportFilter) ExportTable {
+	table := ExportTable{
+		Name:    "lots",
+		Columns: []string{"sold_at", "bought_at", "symbol", "base_asset", "quote_asset", "qty", "cost_basis", "proceeds", "pnl", "unmatched"},
+	}
+	for _, lot := range FilterLots(lots, filter) {
+		var boughtAt interface{} = ""
+		if !lot.Unmatched {
+			boughtAt = time.UnixMilli(lot.BoughtAt)
+		}
+		table.Rows = append(table.Rows, []interface{}{time.UnixMilli(lot.SoldAt), boughtAt, lot.Symbol, lot.BaseAsset, lot.QuoteAsset, lot.Qty, lot.CostBasis, lot.Proceeds, lot.PNL, lot.Unmatched})
+	}
+	return table
+}
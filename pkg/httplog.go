@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// secretParams are the query parameters never written to logs or errors.
+var secretParams = []string{"signature", "api_key", "apikey"}
+
+var secretParamPattern = regexp.MustCompile(`(?i)\b(signature|api_key|apikey)=[^&\s"]*`)
+
+// redactURL hides signatures and API keys in a URL: a signature is a valid
+// request for the whole recvWindow.
+func redactURL(url string) string {
+	return secretParamPattern.ReplaceAllString(url, "${1}=REDACTED")
+}
+
+func redactQuery(query neturl.Values) string {
+	for _, param := range secretParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+		}
+	}
+	return query.Encode()
+}
+
+// loggingTransport logs one line per request of an API client once it's
+// answered: method, endpoint, status, latency and, for Binance, the request
+// weight used in the current minute. Failures and non-2xx answers are
+// warnings, the rest info.
+type loggingTransport struct {
+	api  string
+	next http.RoundTripper
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	entry := log.WithFields(log.Fields{
+		"api":        t.api,
+		"method":     req.Method,
+		"endpoint":   req.URL.Path,
+		"latency_ms": time.Since(start).Milliseconds(),
+	})
+	if query := req.URL.Query(); len(query) > 0 {
+		entry = entry.WithField("query", redactQuery(query))
+	}
+	if err != nil {
+		entry.WithField("error", redactURL(err.Error())).Warn("request failed")
+		return resp, err
+	}
+	entry = entry.WithField("status", resp.StatusCode)
+	if weight := resp.Header.Get("X-Mbx-Used-Weight-1m"); weight != "" {
+		entry = entry.WithField("weight", weight)
+	}
+	if resp.StatusCode >= 300 {
+		entry.Warn("request")
+	} else {
+		entry.Info("request")
+	}
+	return resp, nil
+}
+
+// apiClient is an http.Client logging through loggingTransport whose errors
+// don't carry the signed URL. Each call is bounded by timeout, read when
+// it's made, unless its context ends sooner.
+type apiClient struct {
+	client  *http.Client
+	timeout func() time.Duration
+}
+
+func newAPIClient(transport http.RoundTripper, timeout func() time.Duration) *apiClient {
+	return &apiClient{client: &http.Client{Transport: transport}, timeout: timeout}
+}
+
+// cancelOnClose releases the timeout of a call once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+func (c *apiClient) Do(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), c.timeout())
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		return resp, err
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+func (c *apiClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// binanceClient also goes through the rate limiter, which sees every attempt
+// before it's logged.
+var binanceClient = newAPIClient(rateLimitTransport{limiter: binanceLimiter, next: loggingTransport{api: "binance", next: http.DefaultTransport}}, func() time.Duration {
+	return config.BinanceRequestTimeout
+})
+
+var ccDataClient = newAPIClient(loggingTransport{api: "ccdata", next: http.DefaultTransport}, func() time.Duration {
+	return config.CCDataRequestTimeout
+})
+
+// SetLogging sets the level and the format, text or json, of the logs.
+func SetLogging(level log.Level, format string) {
+	log.SetLevel(level)
+	if format == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	} else {
+		log.SetFormatter(&log.TextFormatter{})
+	}
+}
@@ -0,0 +1,367 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	ImportTradeHistory       = "trade_history"
+	ImportTransactionHistory = "transaction_history"
+)
+
+// ImportBatch is one export file normalised into trades and ledger entries.
+type ImportBatch struct {
+	Format   string
+	Trades   []Trade
+	Ledger   []LedgerEntry
+	Warnings []string
+}
+
+// transactionTradeOperations are the Transaction History operations that are
+// legs of a spot trade. Every other operation goes to the ledger.
+var transactionTradeOperations = map[string]bool{
+	"buy":                 true,
+	"sell":                true,
+	"fee":                 true,
+	"transaction related": true,
+	"transaction buy":     true,
+	"transaction spend":   true,
+	"transaction fee":     true,
+	"transaction sold":    true,
+	"transaction revenue": true,
+	"binance convert":     true,
+	"large otc trading":   true,
+}
+
+// exportHeader maps normalised column names ("Date(UTC)" -> "dateutc") to
+// their index.
+type exportHeader map[string]int
+
+func (h exportHeader) has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := h[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the first of the named columns present in row.
+func (h exportHeader) get(row []string, names ...string) string {
+	for _, name := range names {
+		if index, ok := h[name]; ok && index < len(row) {
+			return strings.TrimSpace(row[index])
+		}
+	}
+	return ""
+}
+
+// normaliseColumn keeps letters and digits only, which also drops the BOM
+// Excel writes before the first column name.
+func normaliseColumn(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// ParseBinanceExport reads a Binance "Trade History" or "Transaction
+// History" export, as CSV or XLSX, telling them apart by their columns.
+func ParseBinanceExport(filename string, r io.Reader) (ImportBatch, error) {
+	var rows [][]string
+	var err error
+	if strings.EqualFold(filepath.Ext(filename), ".xlsx") {
+		rows, err = readXLSXRows(r)
+	} else {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		rows, err = reader.ReadAll()
+	}
+	if err != nil {
+		return ImportBatch{}, err
+	}
+	for len(rows) > 0 && strings.TrimSpace(strings.Join(rows[0], "")) == "" {
+		rows = rows[1:]
+	}
+	if len(rows) == 0 {
+		return ImportBatch{}, fmt.Errorf("%s is empty", filename)
+	}
+	header := make(exportHeader)
+	for i, name := range rows[0] {
+		name = normaliseColumn(name)
+		if _, ok := header[name]; !ok {
+			header[name] = i
+		}
+	}
+	switch {
+	case header.has("operation") && header.has("change"):
+		return parseTransactionHistory(header, rows[1:])
+	case header.has("pair", "market") && header.has("side", "type"):
+		return parseTradeHistory(header, rows[1:])
+	}
+	return ImportBatch{}, fmt.Errorf("%s: not a Binance Trade History or Transaction History export", filename)
+}
+
+// parseExportTime reads the UTC timestamps of the exports, which come as
+// "2024-01-02 15:04:05", "24-01-02 15:04:05" or, in XLSX, Excel serial days.
+func parseExportTime(value string) (int64, error) {
+	for _, layout := range []string{time.DateTime, "06-01-02 15:04:05", time.RFC3339, "2006/01/02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UnixMilli(), nil
+		}
+	}
+	if days, err := strconv.ParseFloat(value, 64); err == nil && days > 0 {
+		excelEpoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+		return excelEpoch.Add(time.Duration(math.Round(days*86400)) * time.Second).UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("unknown date %q", value)
+}
+
+// parseExportAmount splits amounts like "0.015BTC" or "1,234.5 USDT" into
+// the number and the asset. Asset names can start with digits ("1INCH"),
+// so the hinted assets are tried before guessing.
+func parseExportAmount(value string, hints ...string) (float64, string, error) {
+	value = strings.ReplaceAll(strings.ReplaceAll(value, ",", ""), " ", "")
+	number, asset := value, ""
+	matched := false
+	for _, hint := range hints {
+		if hint != "" && strings.HasSuffix(value, hint) {
+			number, asset, matched = strings.TrimSuffix(value, hint), hint, true
+			break
+		}
+	}
+	if !matched {
+		if end := strings.LastIndexFunc(value, func(r rune) bool {
+			return unicode.IsDigit(r) || r == '.'
+		}); end >= 0 {
+			number, asset = value[:end+1], value[end+1:]
+		}
+	}
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("bad amount %q", value)
+	}
+	return amount, asset, nil
+}
+
+func formatExportFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// importedTradeID gives imported trades a stable negative id so they never
+// clash with exchange trade ids and re-imports produce the same trade.
+func importedTradeID(trade Trade, occurrence int) int64 {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s|%d", tradeFillKey(trade), occurrence)
+	return -int64(hash.Sum64() >> 1)
+}
+
+func assignImportedTradeIDs(trades []Trade) {
+	occurrences := make(map[string]int)
+	for i := range trades {
+		key := tradeFillKey(trades[i])
+		trades[i].ID = importedTradeID(trades[i], occurrences[key])
+		occurrences[key]++
+	}
+}
+
+// parseTradeHistory handles both generations of the spot Trade History:
+// "Date(UTC),Pair,Side,Price,Executed,Amount,Fee" with the asset glued to
+// each amount, and the older "Date(UTC),Market,Type,Price,Amount,Total,Fee,
+// Fee Coin".
+func parseTradeHistory(header exportHeader, rows [][]string) (ImportBatch, error) {
+	batch := ImportBatch{Format: ImportTradeHistory}
+	for i, row := range rows {
+		line := i + 2
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		symbol := strings.ToUpper(strings.NewReplacer("/", "", "-", "", "_", "").Replace(header.get(row, "pair", "market", "symbol")))
+		base, quote := SplitSymbol(symbol)
+		ts, err := parseExportTime(header.get(row, "dateutc", "date", "time", "utctime"))
+		if err != nil {
+			batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: %v", line, err))
+			continue
+		}
+		side := strings.ToUpper(header.get(row, "side", "type"))
+		if side != "BUY" && side != "SELL" {
+			batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: unknown side %q", line, side))
+			continue
+		}
+		qtyColumn, quoteQtyColumn := "amount", "total"
+		if header.has("executed") {
+			qtyColumn, quoteQtyColumn = "executed", "amount"
+		}
+		price, _, err := parseExportAmount(header.get(row, "price"), quote)
+		if err != nil {
+			batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: %v", line, err))
+			continue
+		}
+		qty, _, err := parseExportAmount(header.get(row, qtyColumn), base)
+		if err != nil {
+			batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: %v", line, err))
+			continue
+		}
+		quoteQty := price * qty
+		if value := header.get(row, quoteQtyColumn); value != "" {
+			if quoteQty, _, err = parseExportAmount(value, quote); err != nil {
+				batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: %v", line, err))
+				continue
+			}
+		}
+		var commission float64
+		var commissionAsset string
+		if value := header.get(row, "fee"); value != "" {
+			if commission, commissionAsset, err = parseExportAmount(value, base, quote, "BNB"); err != nil {
+				batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: %v", line, err))
+				continue
+			}
+		}
+		if feeCoin := header.get(row, "feecoin", "feeasset"); feeCoin != "" {
+			commissionAsset = strings.ToUpper(feeCoin)
+		}
+		if quote == "" {
+			batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: %s has an unknown quote asset, price lookups may fail", line, symbol))
+		}
+		batch.Trades = append(batch.Trades, Trade{
+			Symbol:          symbol,
+			OrderListId:     -1,
+			Price:           formatExportFloat(price),
+			Qty:             formatExportFloat(qty),
+			QuoteQty:        formatExportFloat(quoteQty),
+			Commission:      formatExportFloat(commission),
+			CommissionAsset: commissionAsset,
+			Time:            int(ts),
+			IsBuyer:         side == "BUY",
+		})
+	}
+	assignImportedTradeIDs(batch.Trades)
+	return batch, nil
+}
+
+type transactionRow struct {
+	line  int
+	entry LedgerEntry
+	delta float64
+}
+
+// transactionSymbol finds the pair two coins traded on, taking the coin that
+// ranks higher in quoteAssets as the quote (ETH/BTC is ETHBTC).
+func transactionSymbol(coinA string, coinB string) (string, string, string) {
+	quotes := quoteAssets()
+	rankA, rankB := slices.Index(quotes, coinA), slices.Index(quotes, coinB)
+	switch {
+	case rankA < 0 && rankB < 0:
+		return "", "", ""
+	case rankB < 0 || (rankA >= 0 && rankA < rankB):
+		return coinB + coinA, coinB, coinA
+	}
+	return coinA + coinB, coinA, coinB
+}
+
+// parseTransactionHistory turns the "User_ID,UTC_Time,Account,Operation,
+// Coin,Change,Remark" export into trades and ledger entries. The legs of a
+// spot trade share a timestamp, so the trade rows of one second are summed
+// per coin: one coin in, one coin out and an optional fee make a trade.
+// Anything else can't be rebuilt and is kept in the ledger.
+func parseTransactionHistory(header exportHeader, rows [][]string) (ImportBatch, error) {
+	batch := ImportBatch{Format: ImportTransactionHistory}
+	var groupKeys []string
+	groups := make(map[string][]transactionRow)
+	for i, row := range rows {
+		line := i + 2
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		ts, err := parseExportTime(header.get(row, "utctime", "dateutc", "date", "time"))
+		if err != nil {
+			batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: %v", line, err))
+			continue
+		}
+		change, _, err := parseExportAmount(header.get(row, "change"))
+		if err != nil {
+			batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: %v", line, err))
+			continue
+		}
+		entry := LedgerEntry{
+			Time:      ts,
+			Account:   header.get(row, "account"),
+			Operation: header.get(row, "operation"),
+			Coin:      strings.ToUpper(header.get(row, "coin")),
+			Change:    formatExportFloat(change),
+			Remark:    header.get(row, "remark"),
+		}
+		account := strings.ToLower(entry.Account)
+		if !transactionTradeOperations[strings.ToLower(entry.Operation)] || (account != "spot" && account != "") {
+			batch.Ledger = append(batch.Ledger, entry)
+			continue
+		}
+		key := fmt.Sprintf("%d|%s", ts, entry.Account)
+		if _, ok := groups[key]; !ok {
+			groupKeys = append(groupKeys, key)
+		}
+		groups[key] = append(groups[key], transactionRow{line: line, entry: entry, delta: change})
+	}
+
+	for _, key := range groupKeys {
+		group := groups[key]
+		coinToDelta := make(map[string]float64)
+		feeToDelta := make(map[string]float64)
+		for _, row := range group {
+			if strings.Contains(strings.ToLower(row.entry.Operation), "fee") {
+				feeToDelta[row.entry.Coin] += row.delta
+			} else {
+				coinToDelta[row.entry.Coin] += row.delta
+			}
+		}
+		var received, spent string
+		valid := len(coinToDelta) == 2 && len(feeToDelta) <= 1
+		for coin, delta := range coinToDelta {
+			switch {
+			case delta > 0:
+				received = coin
+			case delta < 0:
+				spent = coin
+			}
+		}
+		symbol, base, quote := transactionSymbol(received, spent)
+		if !valid || received == "" || spent == "" || symbol == "" {
+			batch.Warnings = append(batch.Warnings, fmt.Sprintf("row %d: trade rows at %s could not be paired, kept in the ledger", group[0].line, time.UnixMilli(group[0].entry.Time).UTC().Format(time.DateTime)))
+			for _, row := range group {
+				batch.Ledger = append(batch.Ledger, row.entry)
+			}
+			continue
+		}
+		qty, quoteQty := math.Abs(coinToDelta[base]), math.Abs(coinToDelta[quote])
+		trade := Trade{
+			Symbol:      symbol,
+			OrderListId: -1,
+			Price:       formatExportFloat(quoteQty / qty),
+			Qty:         formatExportFloat(qty),
+			QuoteQty:    formatExportFloat(quoteQty),
+			Commission:  "0",
+			Time:        int(group[0].entry.Time),
+			IsBuyer:     received == base,
+		}
+		for coin, delta := range feeToDelta {
+			trade.Commission = formatExportFloat(math.Abs(delta))
+			trade.CommissionAsset = coin
+		}
+		batch.Trades = append(batch.Trades, trade)
+	}
+	assignImportedTradeIDs(batch.Trades)
+	return batch, nil
+}
@@ -0,0 +1,227 @@
+package pkg
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// Excel's BOM, two identical BTC fills in one second, and an asset
+	// starting with a digit
+	tradeHistoryCSV = "\ufeffDate(UTC),Pair,Side,Price,Executed,Amount,Fee\n" +
+		"2024-01-02 10:00:00,BTCUSDT,BUY,42000,0.01BTC,420USDT,0.00001BTC\n" +
+		"2024-01-02 10:00:00,BTCUSDT,BUY,42000,0.01BTC,420USDT,0.00001BTC\n" +
+		"2024-01-03 11:00:00,1INCHUSDT,SELL,0.5,100.51INCH,50.25USDT,0.05USDT\n"
+	oldTradeHistoryCSV = "Date(UTC),Market,Type,Price,Amount,Total,Fee,Fee Coin\n" +
+		"21-05-01 08:30:00,ETHBTC,SELL,0.05,2,0.1,0.0001,BTC\n" +
+		"21-05-01 08:31:00,ETHBTC,HOLD,0.05,2,0.1,0.0001,BTC\n"
+	// two ETH fills and their fee in one second, a sell a second later, a
+	// deposit and a leg that can't be paired
+	transactionHistoryCSV = "User_ID,UTC_Time,Account,Operation,Coin,Change,Remark\n" +
+		"1,2024-02-01 09:00:00,Spot,Transaction Buy,ETH,0.5,\n" +
+		"1,2024-02-01 09:00:00,Spot,Transaction Spend,USDT,-1000,\n" +
+		"1,2024-02-01 09:00:00,Spot,Transaction Fee,ETH,-0.0005,\n" +
+		"1,2024-02-01 09:00:00,Spot,Transaction Buy,ETH,0.5,\n" +
+		"1,2024-02-01 09:00:00,Spot,Transaction Spend,USDT,-1000,\n" +
+		"1,2024-02-01 09:00:01,Spot,Transaction Sold,ETH,-0.1,\n" +
+		"1,2024-02-01 09:00:01,Spot,Transaction Revenue,BTC,0.005,\n" +
+		"1,2024-02-02 00:00:00,Spot,Deposit,USDT,500,\n" +
+		"1,2024-02-03 00:00:00,Spot,Transaction Buy,ETH,1,\n"
+	// the Trade History of the ETH buy above, fill by fill
+	overlappingTradeHistoryCSV = "Date(UTC),Pair,Side,Price,Executed,Amount,Fee\n" +
+		"2024-02-01 09:00:00,ETHUSDT,BUY,2000,0.5ETH,1000USDT,0.00025ETH\n" +
+		"2024-02-01 09:00:00,ETHUSDT,BUY,2000,0.5ETH,1000USDT,0.00025ETH\n"
+)
+
+func parseTestExport(t *testing.T, csv string) ImportBatch {
+	t.Helper()
+	batch, err := ParseBinanceExport("export.csv", strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return batch
+}
+
+func exportTime(value string) int {
+	ts, _ := time.Parse(time.DateTime, value)
+	return int(ts.UnixMilli())
+}
+
+func parseTestFloat(value string) float64 {
+	f, _ := strconv.ParseFloat(value, 64)
+	return f
+}
+
+func checkTrade(t *testing.T, name string, got Trade, want Trade) {
+	t.Helper()
+	if got.Symbol != want.Symbol || got.Time != want.Time || got.IsBuyer != want.IsBuyer || got.CommissionAsset != want.CommissionAsset ||
+		!closeTo(parseTestFloat(got.Price), parseTestFloat(want.Price)) || !closeTo(parseTestFloat(got.Qty), parseTestFloat(want.Qty)) ||
+		!closeTo(parseTestFloat(got.QuoteQty), parseTestFloat(want.QuoteQty)) || !closeTo(parseTestFloat(got.Commission), parseTestFloat(want.Commission)) {
+		t.Errorf("%s: trade = %+v, want %+v", name, got, want)
+	}
+	if got.ID >= 0 {
+		t.Errorf("%s: imported trade id %d, want a negative one", name, got.ID)
+	}
+}
+
+func TestParseTradeHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		trades   []Trade
+		warnings int
+	}{
+		{"executed and amount with assets", tradeHistoryCSV, []Trade{
+			{Symbol: "BTCUSDT", Price: "42000", Qty: "0.01", QuoteQty: "420", Commission: "0.00001", CommissionAsset: "BTC", Time: exportTime("2024-01-02 10:00:00"), IsBuyer: true},
+			{Symbol: "BTCUSDT", Price: "42000", Qty: "0.01", QuoteQty: "420", Commission: "0.00001", CommissionAsset: "BTC", Time: exportTime("2024-01-02 10:00:00"), IsBuyer: true},
+			{Symbol: "1INCHUSDT", Price: "0.5", Qty: "100.5", QuoteQty: "50.25", Commission: "0.05", CommissionAsset: "USDT", Time: exportTime("2024-01-03 11:00:00")},
+		}, 0},
+		{"market, type and fee coin", oldTradeHistoryCSV, []Trade{
+			{Symbol: "ETHBTC", Price: "0.05", Qty: "2", QuoteQty: "0.1", Commission: "0.0001", CommissionAsset: "BTC", Time: exportTime("2021-05-01 08:30:00")},
+		}, 1},
+	}
+	for _, test := range tests {
+		batch := parseTestExport(t, test.csv)
+		if batch.Format != ImportTradeHistory {
+			t.Errorf("%s: format %q, want %q", test.name, batch.Format, ImportTradeHistory)
+		}
+		if len(batch.Trades) != len(test.trades) || len(batch.Warnings) != test.warnings {
+			t.Errorf("%s: %d trades and warnings %q, want %d trades and %d warnings", test.name, len(batch.Trades), batch.Warnings, len(test.trades), test.warnings)
+			continue
+		}
+		for i, want := range test.trades {
+			checkTrade(t, test.name, batch.Trades[i], want)
+		}
+	}
+
+	// the same fill twice is two trades, with ids that survive a re-import
+	first, again := parseTestExport(t, tradeHistoryCSV), parseTestExport(t, tradeHistoryCSV)
+	if first.Trades[0].ID == first.Trades[1].ID {
+		t.Error("identical fills got the same id")
+	}
+	for i := range first.Trades {
+		if first.Trades[i].ID != again.Trades[i].ID {
+			t.Errorf("trade %d: id %d, then %d on the same file", i, first.Trades[i].ID, again.Trades[i].ID)
+		}
+	}
+}
+
+func TestParseTransactionHistory(t *testing.T) {
+	batch := parseTestExport(t, transactionHistoryCSV)
+	if batch.Format != ImportTransactionHistory {
+		t.Errorf("format %q, want %q", batch.Format, ImportTransactionHistory)
+	}
+	want := []Trade{
+		{Symbol: "ETHUSDT", Price: "2000", Qty: "1", QuoteQty: "2000", Commission: "0.0005", CommissionAsset: "ETH", Time: exportTime("2024-02-01 09:00:00"), IsBuyer: true},
+		{Symbol: "ETHBTC", Price: "0.05", Qty: "0.1", QuoteQty: "0.005", Commission: "0", Time: exportTime("2024-02-01 09:00:01")},
+	}
+	if len(batch.Trades) != len(want) {
+		t.Fatalf("trades = %+v, want %d", batch.Trades, len(want))
+	}
+	for i := range want {
+		checkTrade(t, "transaction history", batch.Trades[i], want[i])
+	}
+	if len(batch.Ledger) != 2 || batch.Ledger[0].Operation != "Deposit" || batch.Ledger[1].Operation != "Transaction Buy" {
+		t.Errorf("ledger = %+v, want the deposit and the unpaired buy", batch.Ledger)
+	}
+	if len(batch.Warnings) != 1 {
+		t.Errorf("warnings = %q, want one for the unpaired buy", batch.Warnings)
+	}
+}
+
+func TestTransactionSymbol(t *testing.T) {
+	tests := []struct {
+		coinA, coinB        string
+		symbol, base, quote string
+	}{
+		{"BTC", "USDT", "BTCUSDT", "BTC", "USDT"},
+		{"USDT", "BTC", "BTCUSDT", "BTC", "USDT"},
+		{"ETH", "BTC", "ETHBTC", "ETH", "BTC"},
+		{"BTC", "GBP", "BTCGBP", "BTC", "GBP"},
+		{"USDC", "USDT", "USDCUSDT", "USDC", "USDT"},
+		{"1INCH", "BNB", "1INCHBNB", "1INCH", "BNB"},
+		{"FOO", "BAR", "", "", ""},
+	}
+	for _, test := range tests {
+		symbol, base, quote := transactionSymbol(test.coinA, test.coinB)
+		if symbol != test.symbol || base != test.base || quote != test.quote {
+			t.Errorf("transactionSymbol(%s, %s) = %s, %s, %s, want %s, %s, %s", test.coinA, test.coinB, symbol, base, quote, test.symbol, test.base, test.quote)
+		}
+	}
+}
+
+func TestTradeStoreImport(t *testing.T) {
+	apiTrade := func(id int64, qty string) Trade {
+		return Trade{Symbol: "BTCUSDT", ID: id, Price: "42000", Qty: qty, QuoteQty: "420", Commission: "0", CommissionAsset: "BNB", Time: exportTime("2024-01-02 10:00:00") + 250, IsBuyer: true}
+	}
+	type step struct {
+		csv    string
+		result ImportResult
+	}
+	tests := []struct {
+		name  string
+		api   []Trade
+		steps []step
+	}{
+		{"re-importing the same file", nil, []step{
+			{tradeHistoryCSV, ImportResult{Trades: 3}},
+			{tradeHistoryCSV, ImportResult{DuplicateTrades: 3}},
+		}},
+		{"a bucket the API covers", []Trade{apiTrade(7, "0.02")}, []step{
+			{tradeHistoryCSV, ImportResult{Trades: 1, DuplicateTrades: 2}},
+		}},
+		{"a fill the API has, in a bucket it doesn't cover", []Trade{apiTrade(7, "0.01"), apiTrade(8, "0.005")}, []step{
+			{tradeHistoryCSV, ImportResult{Trades: 2, DuplicateTrades: 1}},
+		}},
+		{"a Transaction History over a Trade History", nil, []step{
+			{overlappingTradeHistoryCSV, ImportResult{Trades: 2}},
+			{transactionHistoryCSV, ImportResult{Trades: 1, DuplicateTrades: 1, Ledger: 2}},
+			{transactionHistoryCSV, ImportResult{DuplicateTrades: 2, DuplicateLedger: 2}},
+		}},
+		{"a Trade History over a Transaction History", nil, []step{
+			{transactionHistoryCSV, ImportResult{Trades: 2, Ledger: 2}},
+			{overlappingTradeHistoryCSV, ImportResult{DuplicateTrades: 2}},
+		}},
+	}
+	for _, test := range tests {
+		store, err := OpenTradeStore(filepath.Join(t.TempDir(), "trades-store.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(test.api) > 0 {
+			if err := store.SyncTrades("BTCUSDT", test.api, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, step := range test.steps {
+			result, err := store.Import(parseTestExport(t, step.csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Trades != step.result.Trades || result.DuplicateTrades != step.result.DuplicateTrades ||
+				result.Ledger != step.result.Ledger || result.DuplicateLedger != step.result.DuplicateLedger {
+				t.Errorf("%s, import %d: %+v, want %+v", test.name, i+1, result, step.result)
+			}
+		}
+	}
+
+	// imports survive reopening the store
+	path := filepath.Join(t.TempDir(), "trades-store.json")
+	store, err := OpenTradeStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Import(parseTestExport(t, tradeHistoryCSV)); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenTradeStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(reopened.Trades("BTCUSDT")); got != 2 {
+		t.Errorf("reopened store has %d BTCUSDT trades, want 2", got)
+	}
+}
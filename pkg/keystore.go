@@ -0,0 +1,316 @@
+package pkg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// CCDataCredential is the keystore name of the CCData API key; every other
+// credential is a Binance account.
+const CCDataCredential = "ccdata"
+
+// ErrWrongPassphrase is returned when a keystore can't be decrypted, which
+// is either a wrong passphrase or a corrupted file.
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted keystore")
+
+// MinPassphraseLength is enforced when a keystore is created.
+const MinPassphraseLength = 8
+
+// Credential is a set of API keys kept in the keystore. Binance accounts
+// have a SecretKey or the PEM PrivateKey of an RSA or Ed25519 key; CCData
+// has neither.
+type Credential struct {
+	Name       string `json:"name"`
+	APIKey     string `json:"api_key"`
+	SecretKey  string `json:"secret_key,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+}
+
+// Type is the type of the API key: HMAC, RSA, Ed25519, or empty for CCData.
+func (c Credential) Type() string {
+	if c.PrivateKey != "" {
+		if signer, err := ParsePrivateKeySigner([]byte(c.PrivateKey)); err == nil {
+			return signer.Type()
+		}
+		return "invalid"
+	}
+	if c.SecretKey != "" {
+		return "HMAC"
+	}
+	return ""
+}
+
+// String keeps the keys out of logs and error messages.
+func (c Credential) String() string {
+	return fmt.Sprintf("%s (%s)", c.Name, MaskKey(c.APIKey))
+}
+
+// MaskKey shows the first and last 4 characters of key.
+func MaskKey(key string) string {
+	if len(key) <= 12 {
+		return "****"
+	}
+	return key[:4] + "…" + key[len(key)-4:]
+}
+
+// keystoreKDF holds the argon2id parameters the file key was derived with,
+// so they can be raised later without breaking existing files.
+type keystoreKDF struct {
+	Name    string `json:"name"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// keystoreFile is the on-disk format. The credentials are encrypted with
+// AES-256-GCM under a key derived from the passphrase; the version and KDF
+// parameters are authenticated as additional data.
+type keystoreFile struct {
+	Version    int         `json:"version"`
+	KDF        keystoreKDF `json:"kdf"`
+	Nonce      []byte      `json:"nonce"`
+	Ciphertext []byte      `json:"ciphertext"`
+}
+
+// Keystore is an unlocked keystore file. The derived key is kept so changes
+// can be saved without asking for the passphrase again.
+type Keystore struct {
+	path        string
+	kdf         keystoreKDF
+	key         []byte
+	credentials map[string]Credential
+}
+
+func (k keystoreKDF) deriveKey(passphrase string) ([]byte, error) {
+	if k.Name != "argon2id" {
+		return nil, fmt.Errorf("unsupported key derivation %q", k.Name)
+	}
+	return argon2.IDKey([]byte(passphrase), k.Salt, k.Time, k.Memory, k.Threads, 32), nil
+}
+
+func (k keystoreKDF) additionalData(version int) []byte {
+	data, _ := json.Marshal(struct {
+		Version int         `json:"version"`
+		KDF     keystoreKDF `json:"kdf"`
+	}{version, k})
+	return data
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CreateKeystore starts an empty keystore at path; nothing is written until
+// Save. It refuses to replace an existing file.
+func CreateKeystore(path string, passphrase string) (*Keystore, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+	if len(passphrase) < MinPassphraseLength {
+		return nil, fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+	}
+	kdf := keystoreKDF{Name: "argon2id", Salt: make([]byte, 16), Time: 3, Memory: 64 * 1024, Threads: 4}
+	if _, err := rand.Read(kdf.Salt); err != nil {
+		return nil, err
+	}
+	key, err := kdf.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &Keystore{path: path, kdf: kdf, key: key, credentials: make(map[string]Credential)}, nil
+}
+
+// OpenKeystore decrypts the keystore at path.
+func OpenKeystore(path string, passphrase string) (*Keystore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("%s: unsupported keystore version %d", path, file.Version)
+	}
+	key, err := file.KDF.deriveKey(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, file.KDF.additionalData(file.Version))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrWrongPassphrase)
+	}
+	var credentials []Credential
+	if err := json.Unmarshal(plaintext, &credentials); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	keystore := &Keystore{path: path, kdf: file.KDF, key: key, credentials: make(map[string]Credential)}
+	for _, credential := range credentials {
+		keystore.credentials[credential.Name] = credential
+	}
+	return keystore, nil
+}
+
+// Path is the file the keystore is saved to.
+func (k *Keystore) Path() string {
+	return k.path
+}
+
+// Credentials returns the stored credentials sorted by name.
+func (k *Keystore) Credentials() []Credential {
+	credentials := make([]Credential, 0, len(k.credentials))
+	for _, credential := range k.credentials {
+		credentials = append(credentials, credential)
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].Name < credentials[j].Name
+	})
+	return credentials
+}
+
+// Set adds or replaces the credential with the same name.
+func (k *Keystore) Set(credential Credential) error {
+	switch {
+	case credential.Name != CCDataCredential && credential.Name != MainAccount && (!accountNamePattern.MatchString(credential.Name) || credential.Name == AllAccounts):
+		return fmt.Errorf("%q: names are %s, %s or lowercase letters, digits, _ and -, other than %s", credential.Name, MainAccount, CCDataCredential, AllAccounts)
+	case credential.APIKey == "":
+		return fmt.Errorf("%s: the API key is required", credential.Name)
+	case credential.Name != CCDataCredential && credential.SecretKey == "" && credential.PrivateKey == "":
+		return fmt.Errorf("%s: a secret key or a private key is required", credential.Name)
+	}
+	if credential.PrivateKey != "" {
+		if _, err := ParsePrivateKeySigner([]byte(credential.PrivateKey)); err != nil {
+			return fmt.Errorf("%s: %w", credential.Name, err)
+		}
+	}
+	k.credentials[credential.Name] = credential
+	return nil
+}
+
+// Remove deletes the credential called name, reporting whether it existed.
+func (k *Keystore) Remove(name string) bool {
+	_, ok := k.credentials[name]
+	delete(k.credentials, name)
+	return ok
+}
+
+// Save encrypts the credentials with a fresh nonce and replaces the file,
+// readable by the owner only.
+func (k *Keystore) Save() error {
+	plaintext, err := json.Marshal(k.Credentials())
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return err
+	}
+	file := keystoreFile{Version: 1, KDF: k.kdf, Nonce: make([]byte, gcm.NonceSize())}
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, k.kdf.additionalData(file.Version))
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), filepath.Base(k.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), k.path)
+}
+
+// ApplyKeystore takes the account and CCData keys from the keystore. Keys
+// there win over plaintext ones, and credentials for accounts the config
+// doesn't list add those accounts. It returns the names of plaintext keys
+// that were overridden, which should be deleted.
+func (c *Config) ApplyKeystore(keystore *Keystore) []string {
+	var overridden []string
+	for _, credential := range keystore.Credentials() {
+		if credential.Name == CCDataCredential {
+			if c.CCDataAPIKey != "" && c.CCDataAPIKey != credential.APIKey {
+				overridden = append(overridden, "ccdata.api_key")
+			}
+			c.CCDataAPIKey = credential.APIKey
+			continue
+		}
+		found := false
+		for i := range c.Accounts {
+			account := &c.Accounts[i]
+			if account.Name != credential.Name {
+				continue
+			}
+			found = true
+			if account.APIKey != "" && (account.APIKey != credential.APIKey || account.SecretKey != credential.SecretKey || account.PrivateKey != credential.PrivateKey) {
+				overridden = append(overridden, account.Name+" account keys")
+			}
+			account.APIKey, account.SecretKey, account.PrivateKey = credential.APIKey, credential.SecretKey, credential.PrivateKey
+		}
+		if !found {
+			c.Accounts = append(c.Accounts, Account{Name: credential.Name, APIKey: credential.APIKey, SecretKey: credential.SecretKey, PrivateKey: credential.PrivateKey})
+		}
+		if credential.Name == MainAccount {
+			c.BinanceAPIKey, c.BinanceSecretKey, c.BinancePrivateKey = credential.APIKey, credential.SecretKey, credential.PrivateKey
+		}
+	}
+	return overridden
+}
+
+// CheckCredentials reports accounts other than main that ended up without
+// keys in the config, the environment or the keystore.
+func (c Config) CheckCredentials() error {
+	var errs []error
+	for _, account := range c.Accounts {
+		if account.Name != MainAccount && (account.APIKey == "" || (account.SecretKey == "" && account.PrivateKey == "")) {
+			errs = append(errs, fmt.Errorf("accounts.%s: api_key and secret_key or private_key_file are required, in the keystore (keys add %s) or the config", account.Name, account.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PlaintextCredentials are the keys set in the config file or the
+// environment, for moving them into the keystore.
+func (c Config) PlaintextCredentials() []Credential {
+	var credentials []Credential
+	for _, account := range c.Accounts {
+		if account.APIKey != "" {
+			credentials = append(credentials, Credential{Name: account.Name, APIKey: account.APIKey, SecretKey: account.SecretKey, PrivateKey: account.PrivateKey})
+		}
+	}
+	if c.CCDataAPIKey != "" {
+		credentials = append(credentials, Credential{Name: CCDataCredential, APIKey: c.CCDataAPIKey})
+	}
+	return credentials
+}
@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	keystore, err := CreateKeystore(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Credential{Name: MainAccount, APIKey: "api-key-0123456789", SecretKey: "secret-key-0123456789"}
+	if err := keystore.Set(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := keystore.Save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("keystore mode = %v, want 0600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{want.APIKey, want.SecretKey} {
+		if bytes.Contains(data, []byte(key)) {
+			t.Errorf("keystore file contains %s in plaintext", key)
+		}
+	}
+
+	opened, err := OpenKeystore(path, "correct horse")
+	if err != nil {
+		t.Fatalf("OpenKeystore: %v", err)
+	}
+	if got := opened.Credentials(); len(got) != 1 || got[0] != want {
+		t.Errorf("credentials = %v, want %v", got, want)
+	}
+
+	if _, err := OpenKeystore(path, "wrong horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("OpenKeystore with the wrong passphrase = %v, want ErrWrongPassphrase", err)
+	}
+	if _, err := CreateKeystore(path, "correct horse"); err == nil {
+		t.Error("CreateKeystore replaced an existing keystore")
+	}
+}
@@ -0,0 +1,209 @@
+package pkg
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// APIParam is a query or path parameter of an APIRoute, a string unless
+// Type says otherwise.
+type APIParam struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// APIRoute describes an /api/v1 route for the OpenAPI document. Path uses
+// echo's :param syntax and Data is a value of the type the route answers
+// with, e.g. []Trade(nil). Paged routes take limit and offset.
+type APIRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	Params  []APIParam
+	Data    interface{}
+	Paged   bool
+}
+
+var pageParams = []APIParam{
+	{Name: "limit", In: "query", Type: "integer", Description: "items per page, 1 to 1000 (default 100)"},
+	{Name: "offset", In: "query", Type: "integer", Description: "items to skip"},
+}
+
+var pathParamPattern = regexp.MustCompile(`:(\w+)`)
+
+// OpenAPI builds the OpenAPI 3.0 document of routes, served under basePath.
+// The schemas of the answers are generated from the Go types: JSON tags
+// name the properties and fields without omitempty are required. Every
+// route takes a bearer API token.
+func OpenAPI(title string, version string, basePath string, routes []APIRoute) map[string]interface{} {
+	g := schemaGenerator{components: make(map[string]interface{})}
+	g.components["ErrorResponse"] = g.object(reflect.TypeOf(APIResp[interface{}]{}))
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		envelope := g.object(reflect.TypeOf(APIResp[struct{}]{}))
+		envelope["properties"].(map[string]interface{})["data"] = g.schema(reflect.TypeOf(route.Data))
+		params := route.Params
+		if route.Paged {
+			params = append(append([]APIParam{}, params...), pageParams...)
+		}
+		var parameters []interface{}
+		for _, param := range params {
+			paramType := param.Type
+			if paramType == "" {
+				paramType = "string"
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name":        param.Name,
+				"in":          param.In,
+				"description": param.Description,
+				"required":    param.Required || param.In == "path",
+				"schema":      map[string]interface{}{"type": paramType},
+			})
+		}
+		operation := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": operationID(route),
+			"responses": map[string]interface{}{
+				"200":     jsonResponse("OK", envelope),
+				"default": jsonResponse("error, see error.code", map[string]interface{}{"$ref": "#/components/schemas/ErrorResponse"}),
+			},
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		path := pathParamPattern.ReplaceAllString(route.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path].(map[string]interface{})[strings.ToLower(route.Method)] = operation
+	}
+	return map[string]interface{}{
+		"openapi":  "3.0.3",
+		"info":     map[string]interface{}{"title": title, "version": version},
+		"servers":  []interface{}{map[string]interface{}{"url": basePath}},
+		"paths":    paths,
+		"security": []interface{}{map[string]interface{}{"bearerToken": []interface{}{}}},
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "an API token from the tokens command, with the read scope"},
+			},
+		},
+	}
+}
+
+func jsonResponse(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+	}
+}
+
+// operationID turns GET /orders/:symbol into getOrdersSymbol.
+func operationID(route APIRoute) string {
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.FieldsFunc(route.Path, func(r rune) bool { return r == '/' || r == ':' || r == '_' || r == '.' }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// schemaGenerator turns Go types into JSON schemas, putting named structs in
+// components and referring to them.
+type schemaGenerator struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	errorCodeType = reflect.TypeOf(ErrorCode(""))
+)
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case errorCodeType:
+		return map[string]interface{}{"type": "string", "enum": ErrorCodes}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" || strings.Contains(name, "[") {
+			return g.object(t)
+		}
+		if _, ok := g.components[name]; !ok {
+			// set first so that recursive types end up referring to themselves
+			g.components[name] = map[string]interface{}{}
+			g.components[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	g.fields(t, properties, &required)
+	object := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
+
+// fields adds the JSON properties of struct t, flattening embedded structs
+// the way encoding/json does.
+func (g *schemaGenerator) fields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			g.fields(fieldType, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+type OpenOrderView struct {
+	Order
+	RemainingQty    float64 `json:"remaining_qty"`
+	LockedAsset     string  `json:"locked_asset"`
+	LockedQty       float64 `json:"locked_qty"`
+	CurrentPrice    float64 `json:"current_price"`
+	DistancePercent float64 `json:"distance_percent"`
+	AgeSeconds      int64   `json:"age_seconds"`
+}
+
+type OpenOrdersPanel struct {
+	Symbol          string          `json:"symbol"`
+	BaseAsset       string          `json:"base_asset"`
+	QuoteAsset      string          `json:"quote_asset"`
+	CurrentPrice    float64         `json:"current_price"`
+	BaseLocked      float64         `json:"base_locked"`
+	QuoteLocked     float64         `json:"quote_locked"`
+	OrdersLockBase  float64         `json:"orders_lock_base"`
+	OrdersLockQuote float64         `json:"orders_lock_quote"`
+	Orders          []OpenOrderView `json:"orders"`
+}
+
+// BuildOpenOrdersPanel describes the working orders of one symbol: what each
+// order locks, how far its limit is from the current price and how old it is.
+// The Locked balances of the wallet are carried along so they can be checked
+// against what the orders account for.
+func BuildOpenOrdersPanel(baseAsset string, quoteAsset string, orders []Order, currentPrice float64, walletBalances []*WalletBalance, now time.Time) OpenOrdersPanel {
+	panel := OpenOrdersPanel{
+		Symbol:       baseAsset + quoteAsset,
+		BaseAsset:    baseAsset,
+		QuoteAsset:   quoteAsset,
+		CurrentPrice: currentPrice,
+		Orders:       []OpenOrderView{},
+	}
+	for _, balance := range walletBalances {
+		if balance.Symbol == baseAsset {
+			panel.BaseLocked = balance.Locked
+		}
+		if balance.Symbol == quoteAsset {
+			panel.QuoteLocked = balance.Locked
+		}
+	}
+	for _, order := range orders {
+		price, _ := strconv.ParseFloat(order.Price, 64)
+		origQty, _ := strconv.ParseFloat(order.OrigQty, 64)
+		executedQty, _ := strconv.ParseFloat(order.ExecutedQty, 64)
+		view := OpenOrderView{
+			Order:        order,
+			RemainingQty: origQty - executedQty,
+			CurrentPrice: currentPrice,
+			AgeSeconds:   (now.UnixMilli() - int64(order.Time)) / 1000,
+		}
+		if order.Side == "BUY" {
+			view.LockedAsset = quoteAsset
+			view.LockedQty = view.RemainingQty * price
+			panel.OrdersLockQuote += view.LockedQty
+		} else {
+			view.LockedAsset = baseAsset
+			view.LockedQty = view.RemainingQty
+			panel.OrdersLockBase += view.LockedQty
+		}
+		if currentPrice > 0 && price > 0 {
+			view.DistancePercent = (price - currentPrice) / currentPrice * 100
+		}
+		panel.Orders = append(panel.Orders, view)
+	}
+	sort.Slice(panel.Orders, func(i, j int) bool {
+		return panel.Orders[i].Time > panel.Orders[j].Time
+	})
+	return panel
+}
@@ -0,0 +1,189 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type OrderTypeStats struct {
+	Type            string  `json:"type"`
+	Orders          int     `json:"orders"`
+	Filled          int     `json:"filled"`
+	PartiallyFilled int     `json:"partially_filled"`
+	FillRate        float64 `json:"fill_rate"`
+}
+
+type OrderGroupStats struct {
+	Symbol               string           `json:"symbol"`
+	Month                string           `json:"month"`
+	Orders               int              `json:"orders"`
+	Filled               int              `json:"filled"`
+	PartiallyFilled      int              `json:"partially_filled"`
+	Canceled             int              `json:"canceled"`
+	Expired              int              `json:"expired"`
+	Rejected             int              `json:"rejected"`
+	Working              int              `json:"working"`
+	FillRate             float64          `json:"fill_rate"`
+	CancelRatio          float64          `json:"cancel_ratio"`
+	AvgTimeToFillSeconds float64          `json:"avg_time_to_fill_seconds"`
+	MarketOrders         int              `json:"market_orders"`
+	AvgSlippageBps       float64          `json:"avg_slippage_bps"`
+	WorstSlippageBps     float64          `json:"worst_slippage_bps"`
+	ByType               []OrderTypeStats `json:"by_type"`
+
+	timeToFillTotal float64
+	slippageTotal   float64
+	typeToStats     map[string]*OrderTypeStats
+}
+
+type OrderAnalytics struct {
+	Totals OrderGroupStats   `json:"totals"`
+	Groups []OrderGroupStats `json:"groups"`
+}
+
+type midPriceCache map[string]float64
+
+// referencePrice approximates the mid-price when an order was submitted with
+// the close of the last full minute before it. Binance has no historical order
+// book, and the minute the order lands in is already moved by the order itself.
+func (m midPriceCache) referencePrice(ctx context.Context, symbol string, ts int64) (float64, error) {
+	minute := ts - ts%60000 - 60000
+	key := fmt.Sprintf("%s:%d", symbol, minute)
+	if price, ok := m[key]; ok {
+		return price, nil
+	}
+	klines, err := GetKlines(ctx, symbol, "1m", minute, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(klines) == 0 {
+		return 0, fmt.Errorf("no kline for %s at %d", symbol, minute)
+	}
+	m[key] = klines[0].Close
+	return klines[0].Close, nil
+}
+
+// orderSlippageBps is how much worse than the reference price the order was
+// filled on average, in basis points. Positive means it cost us.
+func orderSlippageBps(order Order, referencePrice float64) (float64, bool) {
+	executedQty, _ := strconv.ParseFloat(order.ExecutedQty, 64)
+	quoteQty, _ := strconv.ParseFloat(order.CummulativeQuoteQty, 64)
+	if executedQty <= 0 || referencePrice <= 0 {
+		return 0, false
+	}
+	avgPrice := quoteQty / executedQty
+	slippage := (avgPrice - referencePrice) / referencePrice * 10000
+	if order.Side == "SELL" {
+		slippage = -slippage
+	}
+	return slippage, true
+}
+
+func (g *OrderGroupStats) add(order Order, slippageBps float64, hasSlippage bool) {
+	if g.typeToStats == nil {
+		g.typeToStats = make(map[string]*OrderTypeStats)
+	}
+	typeStats, ok := g.typeToStats[order.Type]
+	if !ok {
+		typeStats = &OrderTypeStats{Type: order.Type}
+		g.typeToStats[order.Type] = typeStats
+	}
+	executedQty, _ := strconv.ParseFloat(order.ExecutedQty, 64)
+	g.Orders++
+	typeStats.Orders++
+	switch order.Status {
+	case "FILLED":
+		g.Filled++
+		typeStats.Filled++
+		g.timeToFillTotal += float64(order.UpdateTime-order.Time) / 1000
+	case "NEW", "PARTIALLY_FILLED", "PENDING_NEW":
+		g.Working++
+	case "CANCELED", "PENDING_CANCEL":
+		g.Canceled++
+	case "EXPIRED", "EXPIRED_IN_MATCH":
+		g.Expired++
+	case "REJECTED":
+		g.Rejected++
+	}
+	if order.Status != "FILLED" && executedQty > 0 {
+		g.PartiallyFilled++
+		typeStats.PartiallyFilled++
+	}
+	if hasSlippage {
+		g.MarketOrders++
+		g.slippageTotal += slippageBps
+		if g.MarketOrders == 1 || slippageBps > g.WorstSlippageBps {
+			g.WorstSlippageBps = slippageBps
+		}
+	}
+}
+
+func (g *OrderGroupStats) finish() {
+	if g.Orders > 0 {
+		g.FillRate = float64(g.Filled) / float64(g.Orders) * 100
+		g.CancelRatio = float64(g.Canceled) / float64(g.Orders) * 100
+	}
+	if g.Filled > 0 {
+		g.AvgTimeToFillSeconds = g.timeToFillTotal / float64(g.Filled)
+	}
+	if g.MarketOrders > 0 {
+		g.AvgSlippageBps = g.slippageTotal / float64(g.MarketOrders)
+	}
+	g.ByType = nil
+	for _, typeStats := range g.typeToStats {
+		if typeStats.Orders > 0 {
+			typeStats.FillRate = float64(typeStats.Filled) / float64(typeStats.Orders) * 100
+		}
+		g.ByType = append(g.ByType, *typeStats)
+	}
+	sort.Slice(g.ByType, func(i, j int) bool {
+		return g.ByType[i].Type < g.ByType[j].Type
+	})
+}
+
+// AnalyseOrders groups orders of every status by symbol and month and works
+// out fill rates, cancellations, time to fill and, for filled MARKET orders,
+// slippage against the price just before submission.
+func AnalyseOrders(ctx context.Context, orders []Order) OrderAnalytics {
+	analytics := OrderAnalytics{Totals: OrderGroupStats{Symbol: "ALL", Month: "ALL"}}
+	keyToGroup := make(map[string]*OrderGroupStats)
+	midPrices := make(midPriceCache)
+	for _, order := range orders {
+		month := time.UnixMilli(int64(order.Time)).UTC().Format("2006-01")
+		key := order.Symbol + ":" + month
+		group, ok := keyToGroup[key]
+		if !ok {
+			group = &OrderGroupStats{Symbol: order.Symbol, Month: month}
+			keyToGroup[key] = group
+		}
+		var slippageBps float64
+		var hasSlippage bool
+		if order.Type == "MARKET" && order.Status == "FILLED" {
+			referencePrice, err := midPrices.referencePrice(ctx, order.Symbol, int64(order.Time))
+			if err != nil {
+				log.Warnf("%s: no reference price for order %d: %v", order.Symbol, order.OrderId, err)
+			} else {
+				slippageBps, hasSlippage = orderSlippageBps(order, referencePrice)
+			}
+		}
+		group.add(order, slippageBps, hasSlippage)
+		analytics.Totals.add(order, slippageBps, hasSlippage)
+	}
+	for _, group := range keyToGroup {
+		group.finish()
+		analytics.Groups = append(analytics.Groups, *group)
+	}
+	analytics.Totals.finish()
+	sort.Slice(analytics.Groups, func(i, j int) bool {
+		if analytics.Groups[i].Symbol != analytics.Groups[j].Symbol {
+			return analytics.Groups[i].Symbol < analytics.Groups[j].Symbol
+		}
+		return analytics.Groups[i].Month > analytics.Groups[j].Month
+	})
+	return analytics
+}
@@ -0,0 +1,175 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// PDFDocument is a minimal PDF 1.4 writer: text in the built-in Helvetica
+// fonts, lines, rectangles and pie slices on A4 pages. Coordinates are in
+// points from the top-left corner of the page.
+type PDFDocument struct {
+	Width  float64
+	Height float64
+	pages  []*bytes.Buffer
+}
+
+type PDFColor struct {
+	R, G, B float64
+}
+
+var (
+	pdfBlack = PDFColor{0, 0, 0}
+	pdfGrey  = PDFColor{0.45, 0.45, 0.45}
+	pdfLight = PDFColor{0.93, 0.93, 0.93}
+	pdfGreen = PDFColor{0.1, 0.55, 0.25}
+	pdfRed   = PDFColor{0.8, 0.15, 0.15}
+)
+
+// helveticaWidths are the advance widths of ' ' to '~' in 1/1000 em, from
+// the Adobe font metrics of the standard fonts.
+var helveticaWidths = [2][95]int{
+	{278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, 556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, 1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, 333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, 556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584},
+	{278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278, 556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611, 975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556, 333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611, 611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584},
+}
+
+// pdfWinAnsi maps the few non-ASCII characters we print to WinAnsiEncoding.
+var pdfWinAnsi = map[rune]byte{'£': 0xA3, '€': 0x80, '·': 0xB7, '–': 0x96, '—': 0x97}
+
+func NewPDFDocument() *PDFDocument {
+	return &PDFDocument{Width: 595, Height: 842}
+}
+
+func (d *PDFDocument) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *PDFDocument) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+func pdfEncodeText(text string) string {
+	var encoded strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			encoded.WriteByte('\\')
+			encoded.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			encoded.WriteRune(r)
+		case pdfWinAnsi[r] != 0:
+			fmt.Fprintf(&encoded, "\\%03o", pdfWinAnsi[r])
+		default:
+			encoded.WriteByte('?')
+		}
+	}
+	return encoded.String()
+}
+
+// TextWidth is the width of text in points.
+func (d *PDFDocument) TextWidth(text string, size float64, bold bool) float64 {
+	font := 0
+	if bold {
+		font = 1
+	}
+	var width int
+	for _, r := range text {
+		if r >= ' ' && r <= '~' {
+			width += helveticaWidths[font][r-' ']
+		} else {
+			width += 556
+		}
+	}
+	return float64(width) * size / 1000
+}
+
+// Text draws text with its baseline at y.
+func (d *PDFDocument) Text(x float64, y float64, size float64, bold bool, color PDFColor, text string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT %.3f %.3f %.3f rg /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", color.R, color.G, color.B, font, size, x, d.Height-y, pdfEncodeText(text))
+}
+
+// TextRight draws text ending at x.
+func (d *PDFDocument) TextRight(x float64, y float64, size float64, bold bool, color PDFColor, text string) {
+	d.Text(x-d.TextWidth(text, size, bold), y, size, bold, color, text)
+}
+
+func (d *PDFDocument) Rect(x float64, y float64, width float64, height float64, color PDFColor) {
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f\n", color.R, color.G, color.B, x, d.Height-y-height, width, height)
+}
+
+func (d *PDFDocument) Line(x1 float64, y1 float64, x2 float64, y2 float64, width float64, color PDFColor) {
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f RG %.2f w %.2f %.2f m %.2f %.2f l S\n", color.R, color.G, color.B, width, x1, d.Height-y1, x2, d.Height-y2)
+}
+
+// PieSlice fills the slice of the circle at (cx, cy) between two angles in
+// radians, clockwise from twelve o'clock, approximating the arc with one
+// Bézier curve per quarter turn at most.
+func (d *PDFDocument) PieSlice(cx float64, cy float64, radius float64, start float64, end float64, color PDFColor) {
+	point := func(angle float64) (float64, float64) {
+		return cx + radius*math.Sin(angle), d.Height - (cy - radius*math.Cos(angle))
+	}
+	page := d.page()
+	fmt.Fprintf(page, "%.3f %.3f %.3f rg %.2f %.2f m ", color.R, color.G, color.B, cx, d.Height-cy)
+	x, y := point(start)
+	fmt.Fprintf(page, "%.2f %.2f l ", x, y)
+	segments := int(math.Ceil((end - start) / (math.Pi / 2)))
+	step := (end - start) / float64(max(segments, 1))
+	for i := 0; i < segments; i++ {
+		a1, a2 := start+float64(i)*step, start+float64(i+1)*step
+		k := 4.0 / 3.0 * math.Tan((a2-a1)/4) * radius
+		x1, y1 := point(a1)
+		x2, y2 := point(a2)
+		// control points run along the tangents, which point clockwise
+		c1x, c1y := x1+k*math.Cos(a1), y1-k*math.Sin(a1)
+		c2x, c2y := x2-k*math.Cos(a2), y2+k*math.Sin(a2)
+		fmt.Fprintf(page, "%.2f %.2f %.2f %.2f %.2f %.2f c ", c1x, c1y, c2x, c2y, x2, y2)
+	}
+	page.WriteString("h f\n")
+}
+
+// Write renders the document with a cross-reference table.
+func (d *PDFDocument) Write(w io.Writer) error {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	var out bytes.Buffer
+	var offsets []int
+	addObject := func(body string) int {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+		return len(offsets)
+	}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// 1 catalog, 2 pages tree, 3 and 4 fonts, then a page and its content
+	// stream per page
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	addObject("<< /Type /Catalog /Pages 2 0 R >>")
+	addObject(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	addObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	addObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		addObject(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", d.Width, d.Height, 6+2*i))
+		addObject(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const hourMs = int64(time.Hour / time.Millisecond)
+
+// cryptoQuoteAssets are the coins besides stablecoins and fiat that Binance
+// lists pairs against, in the order they win as the quote.
+var cryptoQuoteAssets = []string{"BTC", "ETH", "BNB", "XRP", "DOGE", "TRX"}
+
+// quoteAssets are the assets a Binance symbol may be quoted in, the one
+// that's the quote of a pair of two of them first: stablecoins, then fiat,
+// then coins (EURUSDT, BTCGBP, ETHBTC).
+func quoteAssets() []string {
+	var assets []string
+	for _, group := range [][]string{config.USDStablecoins, config.CashAssets, config.FiatCurrencies, cryptoQuoteAssets} {
+		for _, asset := range group {
+			if !slices.Contains(assets, asset) {
+				assets = append(assets, asset)
+			}
+		}
+	}
+	return assets
+}
+
+// SplitSymbol splits a Binance symbol into base and quote, taking the
+// longest quote asset it ends with so that e.g. FDUSD wins over USD.
+func SplitSymbol(symbol string) (string, string) {
+	quote := ""
+	for _, asset := range quoteAssets() {
+		if strings.HasSuffix(symbol, asset) && len(symbol) > len(asset) && len(asset) > len(quote) {
+			quote = asset
+		}
+	}
+	if quote == "" {
+		return symbol, ""
+	}
+	return strings.TrimSuffix(symbol, quote), quote
+}
+
+// HistoricalPricer values assets in a fiat currency at a point in time using
+// hourly Binance klines, trying the direct pair, the inverse pair and finally
+// a route through USDT.
+type HistoricalPricer struct {
+	Fiat    string
+	closes  map[string]map[int64]float64
+	missing map[string]bool
+}
+
+func NewHistoricalPricer(fiat string) *HistoricalPricer {
+	return &HistoricalPricer{
+		Fiat:    fiat,
+		closes:  make(map[string]map[int64]float64),
+		missing: make(map[string]bool),
+	}
+}
+
+// hourlyClose returns the close of symbol for the hour containing ts, loading
+// 1000 hours at a time into the cache.
+func (p *HistoricalPricer) hourlyClose(ctx context.Context, symbol string, ts int64) (float64, error) {
+	if p.missing[symbol] {
+		return 0, fmt.Errorf("%s is not listed", symbol)
+	}
+	hour := ts - ts%hourMs
+	if price, ok := p.closes[symbol][hour]; ok {
+		return price, nil
+	}
+	klines, err := GetKlines(ctx, symbol, "1h", hour, 1000)
+	if err != nil {
+		if strings.Contains(err.Error(), "-1121") {
+			p.missing[symbol] = true
+		}
+		return 0, err
+	}
+	if p.closes[symbol] == nil {
+		p.closes[symbol] = make(map[int64]float64)
+	}
+	for _, kline := range klines {
+		p.closes[symbol][kline.OpenTime] = kline.Close
+	}
+	price, ok := p.closes[symbol][hour]
+	if !ok {
+		return 0, fmt.Errorf("no %s price at %s", symbol, time.UnixMilli(ts).UTC().Format(time.DateTime))
+	}
+	return price, nil
+}
+
+// Price values one unit of asset in the pricer's fiat at ts (ms).
+func (p *HistoricalPricer) Price(ctx context.Context, asset string, ts int64) (float64, error) {
+	return p.price(ctx, asset, p.Fiat, ts)
+}
+
+func (p *HistoricalPricer) price(ctx context.Context, asset string, fiat string, ts int64) (float64, error) {
+	if asset == fiat || (fiat == "USD" && config.IsUSDStablecoin(asset)) {
+		return 1, nil
+	}
+	if fiat == "USD" {
+		// Binance has no USD pairs; USDT stands in for the dollar
+		return p.price(ctx, asset, "USDT", ts)
+	}
+	if price, err := p.hourlyClose(ctx, asset+fiat, ts); err == nil {
+		return price, nil
+	}
+	if price, err := p.hourlyClose(ctx, fiat+asset, ts); err == nil && price > 0 {
+		return 1 / price, nil
+	}
+	if asset == "USDT" || fiat == "USDT" {
+		return 0, fmt.Errorf("no route to price %s in %s", asset, fiat)
+	}
+	assetInUSDT, err := p.price(ctx, asset, "USDT", ts)
+	if err != nil {
+		return 0, err
+	}
+	usdtInFiat, err := p.price(ctx, "USDT", fiat, ts)
+	if err != nil {
+		return 0, err
+	}
+	return assetInUSDT * usdtInFiat, nil
+}
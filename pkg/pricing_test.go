@@ -0,0 +1,24 @@
+package pkg
+
+import "testing"
+
+func TestSplitSymbol(t *testing.T) {
+	tests := []struct {
+		symbol string
+		base   string
+		quote  string
+	}{
+		{"BTCUSDT", "BTC", "USDT"},
+		{"BTCFDUSD", "BTC", "FDUSD"},
+		{"ETHBUSD", "ETH", "BUSD"},
+		{"ETHBTC", "ETH", "BTC"},
+		{"BTCGBP", "BTC", "GBP"},
+		{"USDCUSDT", "USDC", "USDT"},
+		{"DOGE", "DOGE", ""},
+	}
+	for _, test := range tests {
+		if base, quote := SplitSymbol(test.symbol); base != test.base || quote != test.quote {
+			t.Errorf("SplitSymbol(%s) = %s, %s, want %s, %s", test.symbol, base, quote, test.base, test.quote)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrCodeTooManyRequests is the Binance error of a request refused with 429
+// (or 418 once the IP is banned) for going over a rate limit.
+const ErrCodeTooManyRequests = -1003
+
+// ErrRateLimited is wrapped by the error of a request that would have had to
+// wait longer than it could for a rate limit.
+var ErrRateLimited = errors.New("rate limited")
+
+// maxRateLimitWait is the longest a request waits for a limit to reset or a
+// ban to lift; past it, e.g. for the daily order count, it fails instead.
+const maxRateLimitWait = 2 * time.Minute
+
+// maxRateLimitRetries is how often an unsigned request is sent when Binance
+// answers 429.
+const maxRateLimitRetries = 3
+
+// RateLimit is one of the limits listed by exchangeInfo, e.g. 6000
+// REQUEST_WEIGHT per 1 MINUTE.
+type RateLimit struct {
+	RateLimitType string `json:"rateLimitType"`
+	Interval      string `json:"interval"`
+	IntervalNum   int    `json:"intervalNum"`
+	Limit         int    `json:"limit"`
+}
+
+// defaultRateLimits are Binance's spot limits, used until exchangeInfo has
+// been read.
+var defaultRateLimits = []RateLimit{
+	{"REQUEST_WEIGHT", "MINUTE", 1, 6000},
+	{"ORDERS", "SECOND", 10, 100},
+	{"ORDERS", "DAY", 1, 200000},
+	{"RAW_REQUESTS", "MINUTE", 5, 61000},
+}
+
+var intervalUnits = map[string]time.Duration{"SECOND": time.Second, "MINUTE": time.Minute, "HOUR": time.Hour, "DAY": 24 * time.Hour}
+
+func (l RateLimit) window() time.Duration {
+	return time.Duration(l.IntervalNum) * intervalUnits[l.Interval]
+}
+
+// interval is the window as Binance spells it in headers, e.g. 1M or 10S.
+func (l RateLimit) interval() string {
+	if l.Interval == "" {
+		return ""
+	}
+	return strconv.Itoa(l.IntervalNum) + l.Interval[:1]
+}
+
+// header is the response header Binance reports the usage of the limit in,
+// or empty for RAW_REQUESTS which isn't reported.
+func (l RateLimit) header() string {
+	switch l.RateLimitType {
+	case "REQUEST_WEIGHT":
+		return "X-MBX-USED-WEIGHT-" + l.interval()
+	case "ORDERS":
+		return "X-MBX-ORDER-COUNT-" + l.interval()
+	}
+	return ""
+}
+
+// orderEndpoints count towards the ORDERS limits when posted.
+var orderEndpoints = map[string]bool{
+	"/api/v3/order":               true,
+	"/api/v3/order/cancelReplace": true,
+	"/api/v3/orderList/oco":       true,
+	"/api/v3/sor/order":           true,
+}
+
+// requestWeight is the weight Binance charges for req, per its API docs.
+// Endpoints not listed cost 1.
+func requestWeight(req *http.Request) int {
+	query := req.URL.Query()
+	switch req.URL.Path {
+	case "/api/v3/account", "/api/v3/myTrades", "/api/v3/allOrders", "/api/v3/exchangeInfo":
+		return 20
+	case "/api/v3/openOrders":
+		if req.Method != http.MethodGet {
+			return 1
+		}
+		if query.Has("symbol") {
+			return 6
+		}
+		return 80
+	case "/api/v3/order":
+		if req.Method == http.MethodGet {
+			return 4
+		}
+	case "/api/v3/order/test":
+		// only the query is checked, a POST body isn't read here
+		if query.Get("computeCommissionRates") == "true" {
+			return 20
+		}
+	case "/api/v3/klines", "/api/v3/depth":
+		return 2
+	case "/api/v3/ticker/price":
+		if query.Has("symbol") {
+			return 2
+		}
+		return 4
+	case "/api/v3/ticker/24hr":
+		switch {
+		case query.Has("symbol"):
+			return 2
+		case query.Has("symbols"):
+			switch n := strings.Count(query.Get("symbols"), ",") + 1; {
+			case n <= 20:
+				return 2
+			case n <= 100:
+				return 40
+			}
+		}
+		return 80
+	}
+	return 1
+}
+
+// rateCounter is the usage of one limit in its current window.
+type rateCounter struct {
+	limit RateLimit
+	start time.Time
+	used  int
+}
+
+// cost is what req counts towards the limit.
+func (c *rateCounter) cost(req *http.Request, weight int) int {
+	switch c.limit.RateLimitType {
+	case "REQUEST_WEIGHT":
+		return weight
+	case "ORDERS":
+		if req.Method == http.MethodPost && orderEndpoints[req.URL.Path] {
+			return 1
+		}
+		return 0
+	}
+	return 1
+}
+
+func (c *rateCounter) roll(now time.Time) {
+	if start := now.Truncate(c.limit.window()); start.After(c.start) {
+		c.start, c.used = start, 0
+	}
+}
+
+// rateLimiter keeps the /api requests to Binance within the rate limits: a
+// request waits for its window to reset when it would go over, the usage
+// Binance reports in the response headers corrects the local count, and a
+// 429 or 418 holds every request, /sapi included, for its Retry-After.
+type rateLimiter struct {
+	mu       sync.Mutex
+	counters []*rateCounter
+	// fromExchange is set once the limits were read from exchangeInfo
+	fromExchange bool
+	loading      bool
+	// loadRetryAt is when to read exchangeInfo again after it failed
+	loadRetryAt   time.Time
+	blockedUntil  time.Time
+	blockedStatus int
+	waits         int
+	waited        time.Duration
+}
+
+func newRateLimiter() *rateLimiter {
+	l := &rateLimiter{}
+	l.setLimits(defaultRateLimits)
+	return l
+}
+
+// setLimits replaces the limits, keeping the usage of those that stay.
+func (l *rateLimiter) setLimits(limits []RateLimit) {
+	var counters []*rateCounter
+	for _, limit := range limits {
+		if limit.window() <= 0 || limit.Limit <= 0 {
+			continue
+		}
+		counter := &rateCounter{limit: limit}
+		for _, old := range l.counters {
+			if old.limit.RateLimitType == limit.RateLimitType && old.limit.window() == limit.window() {
+				counter.start, counter.used = old.start, old.used
+			}
+		}
+		counters = append(counters, counter)
+	}
+	l.counters = counters
+}
+
+// SetRateLimits applies the rate limits of an exchangeInfo answer.
+func SetRateLimits(limits []RateLimit) {
+	if len(limits) == 0 {
+		return
+	}
+	binanceLimiter.mu.Lock()
+	defer binanceLimiter.mu.Unlock()
+	binanceLimiter.setLimits(limits)
+	binanceLimiter.fromExchange = true
+}
+
+// loadRetryDelay is how long the default limits are used after reading
+// exchangeInfo failed, before the next /api request tries again.
+const loadRetryDelay = time.Minute
+
+// loadLimits reads the limits from exchangeInfo once, before the first /api
+// request. BTCUSDT keeps the answer small, the limits come with any symbol.
+func (l *rateLimiter) loadLimits(req *http.Request) {
+	l.mu.Lock()
+	load := !l.fromExchange && !l.loading && req.URL.Path != "/api/v3/exchangeInfo" && !time.Now().Before(l.loadRetryAt)
+	if load {
+		l.loading = true
+	}
+	l.mu.Unlock()
+	if !load {
+		return
+	}
+	var result ExchangeInfo
+	resp, err := binanceClient.Get(req.Context(), binanceBaseURL+"/exchangeInfo?symbol=BTCUSDT")
+	if err == nil {
+		defer resp.Body.Close()
+		var body []byte
+		if body, err = io.ReadAll(resp.Body); err == nil && resp.StatusCode != 200 {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		} else if err == nil {
+			err = json.Unmarshal(body, &result)
+		}
+	}
+	if err != nil || len(result.RateLimits) == 0 {
+		log.Warnf("[rateLimiter]: using the default rate limits for %v, reading exchangeInfo failed - %v", loadRetryDelay, err)
+		l.mu.Lock()
+		l.loading, l.loadRetryAt = false, time.Now().Add(loadRetryDelay)
+		l.mu.Unlock()
+		return
+	}
+	SetRateLimits(result.RateLimits)
+}
+
+// reserve counts req against the limits, waiting as long as it would go over
+// one of them or a 429/418 is being honoured. A wait past maxRateLimitWait
+// or the deadline of req fails at once.
+func (l *rateLimiter) reserve(req *http.Request) error {
+	budgeted := strings.HasPrefix(req.URL.Path, "/api/")
+	if budgeted {
+		l.loadLimits(req)
+	}
+	weight := requestWeight(req)
+	for {
+		l.mu.Lock()
+		now := binanceClock.now()
+		wait := l.blockedUntil.Sub(now)
+		reason := fmt.Sprintf("Binance answered %d", l.blockedStatus)
+		if budgeted {
+			for _, counter := range l.counters {
+				counter.roll(now)
+				cost := counter.cost(req, weight)
+				// a request over the whole limit still goes in an empty window
+				if cost == 0 || counter.used == 0 || counter.used+cost <= counter.limit.Limit {
+					continue
+				}
+				if reset := counter.start.Add(counter.limit.window()).Sub(now); reset > wait {
+					wait = reset
+					reason = fmt.Sprintf("%s %d/%d per %s", counter.limit.RateLimitType, counter.used, counter.limit.Limit, counter.limit.interval())
+				}
+			}
+		}
+		if wait <= 0 {
+			if budgeted {
+				for _, counter := range l.counters {
+					counter.used += counter.cost(req, weight)
+				}
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		deadline, hasDeadline := req.Context().Deadline()
+		if wait > maxRateLimitWait || (hasDeadline && time.Now().Add(wait).After(deadline)) {
+			l.mu.Unlock()
+			return fmt.Errorf("%s %s: %w (%s) until %s", req.Method, req.URL.Path, ErrRateLimited, reason, now.Add(wait).Format(time.RFC3339))
+		}
+		l.waits++
+		l.waited += wait
+		l.mu.Unlock()
+		log.WithFields(log.Fields{"endpoint": req.URL.Path, "wait_ms": wait.Milliseconds(), "reason": reason}).Warn("rate limit, waiting")
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return req.Context().Err()
+		}
+	}
+}
+
+// update takes the usage Binance reports in resp and, for a 429 or 418,
+// holds the next requests for its Retry-After.
+func (l *rateLimiter) update(resp *http.Response) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := binanceClock.now()
+	for _, counter := range l.counters {
+		header := counter.limit.header()
+		if header == "" {
+			continue
+		}
+		if used, err := strconv.Atoi(resp.Header.Get(header)); err == nil {
+			counter.roll(now)
+			counter.used = max(counter.used, used)
+		}
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusTeapot {
+		return
+	}
+	retryAfter := time.Minute
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	if until := now.Add(retryAfter); until.After(l.blockedUntil) {
+		l.blockedUntil, l.blockedStatus = until, resp.StatusCode
+	}
+	log.WithFields(log.Fields{"endpoint": resp.Request.URL.Path, "status": resp.StatusCode, "retry_after_s": int(retryAfter.Seconds())}).Warn("rate limited by Binance")
+}
+
+// reservedKey marks the context of a request already counted by reserveAhead.
+type reservedKey struct{}
+
+// reserveAhead counts req, waiting if needed, before it's signed so the wait
+// doesn't eat into its recvWindow. The request sent with the returned context
+// isn't counted again.
+func (l *rateLimiter) reserveAhead(req *http.Request) (context.Context, error) {
+	if err := l.reserve(req); err != nil {
+		return nil, err
+	}
+	return context.WithValue(req.Context(), reservedKey{}, true), nil
+}
+
+// rateLimitTransport sends the requests of binanceClient through the rate
+// limiter. Unsigned requests answered 429 are sent again once the limiter
+// allows; signed ones are retried by doSignedRequest with a new timestamp.
+type rateLimitTransport struct {
+	limiter *rateLimiter
+	next    http.RoundTripper
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 || req.Context().Value(reservedKey{}) == nil {
+			if err := t.limiter.reserve(req); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		t.limiter.update(resp)
+		replayable := req.Header.Get("X-MBX-APIKEY") == "" && (req.Body == nil || req.GetBody != nil)
+		if resp.StatusCode != http.StatusTooManyRequests || !replayable || attempt == maxRateLimitRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+		req = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// RateLimitUsage is the usage of one rate limit in its current window.
+type RateLimitUsage struct {
+	Type     string    `json:"type"`
+	Interval string    `json:"interval"`
+	Limit    int       `json:"limit"`
+	Used     int       `json:"used"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// RateLimitStatus is the state of the Binance rate limiter.
+type RateLimitStatus struct {
+	Limits []RateLimitUsage `json:"limits"`
+	// FromExchange is false while the limits are the defaults
+	FromExchange bool       `json:"from_exchange"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+	// BlockedStatus is the 429 or 418 that set BlockedUntil
+	BlockedStatus int   `json:"blocked_status,omitempty"`
+	Waits         int   `json:"waits"`
+	WaitedMs      int64 `json:"waited_ms"`
+}
+
+// GetRateLimitStatus reports the current usage of the Binance rate limits.
+func GetRateLimitStatus() RateLimitStatus {
+	binanceLimiter.mu.Lock()
+	defer binanceLimiter.mu.Unlock()
+	now := binanceClock.now()
+	status := RateLimitStatus{FromExchange: binanceLimiter.fromExchange, Waits: binanceLimiter.waits, WaitedMs: binanceLimiter.waited.Milliseconds()}
+	for _, counter := range binanceLimiter.counters {
+		counter.roll(now)
+		status.Limits = append(status.Limits, RateLimitUsage{
+			Type:     counter.limit.RateLimitType,
+			Interval: counter.limit.interval(),
+			Limit:    counter.limit.Limit,
+			Used:     counter.used,
+			ResetsAt: counter.start.Add(counter.limit.window()),
+		})
+	}
+	if binanceLimiter.blockedUntil.After(now) {
+		until := binanceLimiter.blockedUntil
+		status.BlockedUntil, status.BlockedStatus = &until, binanceLimiter.blockedStatus
+	}
+	return status
+}
+
+var binanceLimiter = newRateLimiter()
@@ -0,0 +1,161 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestWeight(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		want   int
+	}{
+		{http.MethodGet, "/api/v3/account", 20},
+		{http.MethodGet, "/api/v3/myTrades?symbol=BTCUSDT", 20},
+		{http.MethodGet, "/api/v3/openOrders?symbol=BTCUSDT", 6},
+		{http.MethodGet, "/api/v3/openOrders", 80},
+		{http.MethodDelete, "/api/v3/openOrders?symbol=BTCUSDT", 1},
+		{http.MethodGet, "/api/v3/order?symbol=BTCUSDT&orderId=1", 4},
+		{http.MethodPost, "/api/v3/order", 1},
+		{http.MethodPost, "/api/v3/order/test", 1},
+		{http.MethodPost, "/api/v3/order/test?computeCommissionRates=true", 20},
+		{http.MethodGet, "/api/v3/klines?symbol=BTCUSDT", 2},
+		{http.MethodGet, "/api/v3/ticker/price?symbol=BTCUSDT", 2},
+		{http.MethodGet, "/api/v3/ticker/price", 4},
+		{http.MethodGet, "/api/v3/ticker/24hr?symbol=BTCUSDT", 2},
+		{http.MethodGet, "/api/v3/ticker/24hr?symbols=" + strings.Repeat("X,", 19) + "X", 2},
+		{http.MethodGet, "/api/v3/ticker/24hr?symbols=" + strings.Repeat("X,", 20) + "X", 40},
+		{http.MethodGet, "/api/v3/ticker/24hr?symbols=" + strings.Repeat("X,", 100) + "X", 80},
+		{http.MethodGet, "/api/v3/ticker/24hr", 80},
+		{http.MethodGet, "/api/v3/ping", 1},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		if got := requestWeight(req); got != test.want {
+			t.Errorf("requestWeight(%s %s) = %d, want %d", test.method, test.url, got, test.want)
+		}
+	}
+}
+
+// startLimitedServer points a rate limited client with limits at a server
+// answering with respond, and counts the requests that reach it.
+func startLimitedServer(t *testing.T, limits []RateLimit, respond func(w http.ResponseWriter, call int32)) (*rateLimiter, *http.Client, string, *atomic.Int32) {
+	t.Helper()
+	calls := new(atomic.Int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, calls.Add(1))
+	}))
+	t.Cleanup(server.Close)
+	limiter := newRateLimiter()
+	limiter.setLimits(limits)
+	// don't read exchangeInfo from the test server
+	limiter.fromExchange = true
+	client := &http.Client{Transport: rateLimitTransport{limiter: limiter, next: http.DefaultTransport}}
+	return limiter, client, server.URL, calls
+}
+
+func getWithin(client *http.Client, url string, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestRateLimiterTakesUsageFromHeaders(t *testing.T) {
+	// a day long window, so the wait for it is always past the deadline
+	limits := []RateLimit{{"REQUEST_WEIGHT", "DAY", 1, 100}}
+	limiter, client, url, calls := startLimitedServer(t, limits, func(w http.ResponseWriter, _ int32) {
+		// other clients of the same IP spent most of the weight
+		w.Header().Set("X-MBX-USED-WEIGHT-1D", "90")
+		fmt.Fprint(w, "{}")
+	})
+	if _, err := getWithin(client, url+"/api/v3/ping", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if used := limiter.counters[0].used; used != 90 {
+		t.Errorf("used weight %d after the response, want the 90 Binance reported", used)
+	}
+	if _, err := getWithin(client, url+"/api/v3/ticker/price?symbol=BTCUSDT", time.Second); err != nil {
+		t.Fatalf("a request within the limit: %v", err)
+	}
+	_, err := getWithin(client, url+"/api/v3/account", time.Second)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("a request over the limit = %v, want ErrRateLimited", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("%d requests reached the server, want 2", calls.Load())
+	}
+
+	// the header only raises the local count
+	limiter.counters[0].used = 95
+	limiter.update(&http.Response{StatusCode: 200, Header: http.Header{"X-Mbx-Used-Weight-1d": {"50"}}, Request: httptest.NewRequest(http.MethodGet, "/api/v3/ping", nil)})
+	if used := limiter.counters[0].used; used != 95 {
+		t.Errorf("used weight %d after a lower header, want 95", used)
+	}
+}
+
+func TestRateLimiterWaitsForRetryAfter(t *testing.T) {
+	_, client, url, calls := startLimitedServer(t, defaultRateLimits, func(w http.ResponseWriter, call int32) {
+		if call == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"code":-1003,"msg":"Too many requests"}`)
+			return
+		}
+		fmt.Fprint(w, "{}")
+	})
+	start := time.Now()
+	resp, err := getWithin(client, url+"/api/v3/ticker/price", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Errorf("status %d after %d calls, want 200 after a retry", resp.StatusCode, calls.Load())
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("retried after %v, want the 1s of Retry-After", elapsed)
+	}
+}
+
+func TestRateLimiterHoldsEveryRequestAfterBan(t *testing.T) {
+	limiter, client, url, calls := startLimitedServer(t, defaultRateLimits, func(w http.ResponseWriter, _ int32) {
+		w.Header().Set("Retry-After", "300")
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprint(w, `{"code":-1003,"msg":"Way too many requests; IP banned"}`)
+	})
+	resp, err := getWithin(client, url+"/api/v3/ticker/price", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTeapot || calls.Load() != 1 {
+		t.Errorf("status %d after %d calls, want the 418 without a retry", resp.StatusCode, calls.Load())
+	}
+	// /sapi isn't budgeted, but a ban holds it too
+	if _, err := getWithin(client, url+"/sapi/v1/capital/deposit/hisrec", time.Second); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("a request during the ban = %v, want ErrRateLimited", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("%d requests reached the server during the ban, want none", calls.Load()-1)
+	}
+	limiter.mu.Lock()
+	blockedFor, status := time.Until(limiter.blockedUntil), limiter.blockedStatus
+	limiter.mu.Unlock()
+	if status != http.StatusTeapot || blockedFor < 290*time.Second {
+		t.Errorf("blocked for %v by %d, want 300s by 418", blockedFor, status)
+	}
+}
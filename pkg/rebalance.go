@@ -0,0 +1,240 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type AllocationTarget struct {
+	Asset     string  `json:"asset"`
+	Weight    float64 `json:"weight"`
+	Tolerance float64 `json:"tolerance"`
+}
+
+type RebalanceLine struct {
+	Asset          string  `json:"asset"`
+	Symbol         string  `json:"symbol"`
+	Price          float64 `json:"price"`
+	CurrentValue   float64 `json:"current_value"`
+	CurrentWeight  float64 `json:"current_weight"`
+	TargetWeight   float64 `json:"target_weight"`
+	Tolerance      float64 `json:"tolerance"`
+	Drift          float64 `json:"drift"`
+	Side           string  `json:"side,omitempty"`
+	Qty            float64 `json:"qty"`
+	QtyText        string  `json:"qty_text,omitempty"`
+	Notional       float64 `json:"notional"`
+	EstimatedFee   float64 `json:"estimated_fee"`
+	SkippedReason  string  `json:"skipped_reason,omitempty"`
+	WithinBand     bool    `json:"within_band"`
+	MinNotional    float64 `json:"min_notional"`
+	LotStepSize    float64 `json:"lot_step_size"`
+	LotMinQuantity float64 `json:"lot_min_quantity"`
+}
+
+type RebalancePlan struct {
+	Currency     string          `json:"currency"`
+	TotalValue   float64         `json:"total_value"`
+	CashBefore   float64         `json:"cash_before"`
+	CashAfter    float64         `json:"cash_after"`
+	TakerFeeRate float64         `json:"taker_fee_rate"`
+	TotalFees    float64         `json:"total_fees"`
+	Lines        []RebalanceLine `json:"lines"`
+	Warnings     []string        `json:"warnings,omitempty"`
+}
+
+// ParseAllocationTargets reads targets written as "asset:weight:tolerance"
+// percentages separated by commas, e.g. "BTC:50:5,ETH:30:5,USDT:20:2".
+// The tolerance is optional and defaults to 0.
+func ParseAllocationTargets(value string) ([]AllocationTarget, error) {
+	var targets []AllocationTarget
+	var total float64
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid target %q, expected asset:weight[:tolerance]", part)
+		}
+		target := AllocationTarget{Asset: strings.ToUpper(strings.TrimSpace(fields[0]))}
+		var err error
+		target.Weight, err = strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil || target.Weight < 0 {
+			return nil, fmt.Errorf("invalid weight for %s", target.Asset)
+		}
+		if len(fields) == 3 {
+			target.Tolerance, err = strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+			if err != nil || target.Tolerance < 0 {
+				return nil, fmt.Errorf("invalid tolerance for %s", target.Asset)
+			}
+		}
+		total += target.Weight
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no allocation targets defined")
+	}
+	if total > 100.0001 {
+		return nil, fmt.Errorf("target weights add up to %.2f%%, more than 100%%", total)
+	}
+	return targets, nil
+}
+
+func walletBalanceValue(balance *WalletBalance, currency string) float64 {
+	if balance.Symbol == currency {
+		return balance.Free + balance.Locked
+	}
+	return balance.QuoteValue
+}
+
+// floorToStep rounds qty down to a multiple of the LOT_SIZE step.
+func floorToStep(qty float64, step float64) float64 {
+	if step <= 0 {
+		return qty
+	}
+	return math.Floor(qty/step+1e-9) * step
+}
+
+// formatQty prints qty with as many decimals as the step size allows so that
+// Binance doesn't reject it for too much precision.
+func formatQty(qty float64, step float64) string {
+	decimals := 8
+	if step > 0 {
+		decimals = max(0, int(math.Round(-math.Log10(step))))
+	}
+	return strconv.FormatFloat(qty, 'f', decimals, 64)
+}
+
+// PlanRebalance works out the market trades that bring the wallet back to the
+// target weights for every asset that drifted outside of its tolerance band.
+// Assets without a target are left alone; the quote currency is the cash leg.
+func PlanRebalance(ctx context.Context, currency string, walletBalances []*WalletBalance, targets []AllocationTarget) (RebalancePlan, error) {
+	plan := RebalancePlan{Currency: currency}
+	assetToBalance := make(map[string]*WalletBalance)
+	for _, balance := range walletBalances {
+		assetToBalance[balance.Symbol] = balance
+		plan.TotalValue += walletBalanceValue(balance, currency)
+	}
+	if plan.TotalValue <= 0 {
+		return plan, fmt.Errorf("wallet has no value in %s", currency)
+	}
+	if cash, ok := assetToBalance[currency]; ok {
+		plan.CashBefore = cash.Free
+	}
+
+	var symbols []string
+	for _, target := range targets {
+		if target.Asset != currency {
+			symbols = append(symbols, target.Asset+currency)
+		}
+	}
+	symbolToInfo := make(map[string]SymbolInfo)
+	if len(symbols) > 0 {
+		exchangeInfo, err := GetExchangeInfo(ctx, symbols)
+		if err != nil {
+			return plan, err
+		}
+		for _, info := range exchangeInfo.Symbols {
+			symbolToInfo[info.Symbol] = info
+		}
+	}
+	accountInfo, err := GetAccountInfo(ctx)
+	if err != nil {
+		log.Warnf("[PlanRebalance]: no commission rates, assuming 0.1%%: %v", err)
+		plan.TakerFeeRate = 0.001
+	} else {
+		plan.TakerFeeRate, _ = strconv.ParseFloat(accountInfo.CommissionRates.Taker, 64)
+	}
+
+	cash := plan.CashBefore
+	for _, target := range targets {
+		line := RebalanceLine{
+			Asset:        target.Asset,
+			TargetWeight: target.Weight,
+			Tolerance:    target.Tolerance,
+		}
+		if balance, ok := assetToBalance[target.Asset]; ok {
+			line.CurrentValue = walletBalanceValue(balance, currency)
+			line.Price = balance.Price
+		}
+		line.CurrentWeight = line.CurrentValue / plan.TotalValue * 100
+		line.Drift = line.CurrentWeight - line.TargetWeight
+		line.WithinBand = math.Abs(line.Drift) <= line.Tolerance
+		if line.WithinBand || target.Asset == currency {
+			plan.Lines = append(plan.Lines, line)
+			continue
+		}
+
+		line.Symbol = target.Asset + currency
+		info, ok := symbolToInfo[line.Symbol]
+		if !ok || info.Status != "TRADING" {
+			line.SkippedReason = fmt.Sprintf("%s is not trading on Binance", line.Symbol)
+			plan.Lines = append(plan.Lines, line)
+			continue
+		}
+		if line.Price <= 0 {
+			price, err := GetCurrentTickerPrice(ctx, line.Symbol)
+			if err != nil {
+				line.SkippedReason = "no price available"
+				plan.Lines = append(plan.Lines, line)
+				continue
+			}
+			line.Price = price
+		}
+		if lotSize, ok := info.Filter("LOT_SIZE"); ok {
+			line.LotStepSize, _ = strconv.ParseFloat(lotSize.StepSize, 64)
+			line.LotMinQuantity, _ = strconv.ParseFloat(lotSize.MinQty, 64)
+		}
+		if notional, ok := info.Filter("NOTIONAL"); ok {
+			line.MinNotional, _ = strconv.ParseFloat(notional.MinNotional, 64)
+		} else if notional, ok := info.Filter("MIN_NOTIONAL"); ok {
+			line.MinNotional, _ = strconv.ParseFloat(notional.MinNotional, 64)
+		}
+
+		deltaValue := line.TargetWeight/100*plan.TotalValue - line.CurrentValue
+		line.Side = "BUY"
+		if deltaValue < 0 {
+			line.Side = "SELL"
+		}
+		line.Qty = floorToStep(math.Abs(deltaValue)/line.Price, line.LotStepSize)
+		line.QtyText = formatQty(line.Qty, line.LotStepSize)
+		line.Notional = line.Qty * line.Price
+		switch {
+		case line.Qty <= 0 || line.Qty < line.LotMinQuantity:
+			line.SkippedReason = fmt.Sprintf("quantity below LOT_SIZE minimum of %v", line.LotMinQuantity)
+		case line.Notional < line.MinNotional:
+			line.SkippedReason = fmt.Sprintf("order value below minimum notional of %v %s", line.MinNotional, currency)
+		}
+		if line.SkippedReason != "" {
+			line.Side = ""
+			plan.Lines = append(plan.Lines, line)
+			continue
+		}
+		line.EstimatedFee = line.Notional * plan.TakerFeeRate
+		plan.TotalFees += line.EstimatedFee
+		if line.Side == "BUY" {
+			cash -= line.Notional
+		} else {
+			cash += line.Notional
+		}
+		cash -= line.EstimatedFee
+		plan.Lines = append(plan.Lines, line)
+	}
+	plan.CashAfter = cash
+	if plan.CashAfter < 0 {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("plan needs %.2f %s more than the wallet holds; execute sells first or lower the buys", -plan.CashAfter, currency))
+	}
+	sort.SliceStable(plan.Lines, func(i, j int) bool {
+		// sells first so that the buys are funded
+		return plan.Lines[i].Side == "SELL" && plan.Lines[j].Side != "SELL"
+	})
+	return plan, nil
+}
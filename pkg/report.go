@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Levels of an AssetWarning: error means the asset's figures are missing,
+// warning that they are incomplete or stale, info is only a note.
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// Sources of the data whose fetch time Report.Freshness records.
+const (
+	SourceBalances = "balances"
+	SourcePrices   = "prices"
+	SourceTrades   = "trades"
+)
+
+// AssetWarning is a problem with one asset of a response, or with the whole
+// account when Asset is empty, that the rest of the response doesn't share.
+// Account is set once the warnings of several accounts are merged.
+type AssetWarning struct {
+	Account string `json:"account,omitempty"`
+	Asset   string `json:"asset,omitempty"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+func (w AssetWarning) String() string {
+	var parts []string
+	for _, part := range []string{w.Account, w.Asset, w.Message} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ": ")
+}
+
+// Report goes along with data built from several sources: the assets that
+// are missing from it or stale, and when each source was fetched. For a
+// source fetched more than once, e.g. prices partly from an earlier fetch,
+// the oldest time is kept.
+type Report struct {
+	Warnings  []AssetWarning       `json:"warnings,omitempty"`
+	Freshness map[string]time.Time `json:"freshness,omitempty"`
+}
+
+func (r *Report) warn(asset string, level string, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, AssetWarning{Asset: asset, Level: level, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) fetched(source string, at time.Time) {
+	if at.IsZero() {
+		return
+	}
+	if r.Freshness == nil {
+		r.Freshness = make(map[string]time.Time)
+	}
+	if oldest, ok := r.Freshness[source]; !ok || at.Before(oldest) {
+		r.Freshness[source] = at
+	}
+}
+
+// merge adds the warnings of account's report, and its fetch times.
+func (r *Report) merge(account string, other Report) {
+	for _, warning := range other.Warnings {
+		if warning.Account == "" {
+			warning.Account = account
+		}
+		r.Warnings = append(r.Warnings, warning)
+	}
+	for source, at := range other.Freshness {
+		r.fetched(source, at)
+	}
+}
+
+// Partial reports whether some asset is missing or stale, so the data is
+// worth fetching again rather than keeping.
+func (r Report) Partial() bool {
+	for _, warning := range r.Warnings {
+		if warning.Level != LevelInfo {
+			return true
+		}
+	}
+	return false
+}
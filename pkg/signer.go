@@ -0,0 +1,157 @@
+package pkg
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"sync"
+)
+
+// Signer signs the payload of a signed Binance request: the query string
+// and body of a REST call, or the sorted params of a WebSocket API request.
+type Signer interface {
+	Sign(payload string) (string, error)
+	// Type is HMAC, RSA or Ed25519, as Binance names the API key types
+	Type() string
+}
+
+// HMACSigner signs with the secret key of a system-generated API key.
+type HMACSigner struct {
+	secret []byte
+}
+
+func NewHMACSigner(secret string) HMACSigner {
+	return HMACSigner{secret: []byte(secret)}
+}
+
+func (s HMACSigner) Sign(payload string) (string, error) {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s HMACSigner) Type() string {
+	return "HMAC"
+}
+
+// RSASigner signs with the private key of an RSA API key, PKCS#1 v1.5 over
+// SHA-256, base64 encoded.
+type RSASigner struct {
+	key *rsa.PrivateKey
+}
+
+func (s RSASigner) Sign(payload string) (string, error) {
+	digest := sha256.Sum256([]byte(payload))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func (s RSASigner) Type() string {
+	return "RSA"
+}
+
+// Ed25519Signer signs with the private key of an Ed25519 API key, the type
+// Binance recommends and the only one the WebSocket API logs on with.
+type Ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s Ed25519Signer) Sign(payload string) (string, error) {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, []byte(payload))), nil
+}
+
+func (s Ed25519Signer) Type() string {
+	return "Ed25519"
+}
+
+// ParsePrivateKeySigner returns an RSA or Ed25519 signer for the unencrypted
+// PEM private key in data, PKCS#8 ("PRIVATE KEY") or PKCS#1 ("RSA PRIVATE
+// KEY") as openssl writes them.
+func ParsePrivateKeySigner(data []byte) (Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	if block.Headers["Proc-Type"] != "" || block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New("encrypted private keys aren't supported, store the key in the keystore instead")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return RSASigner{key: key}, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return RSASigner{key: key}, nil
+		case ed25519.PrivateKey:
+			return Ed25519Signer{key: key}, nil
+		}
+		return nil, fmt.Errorf("%T keys aren't supported, use RSA or Ed25519", key)
+	}
+	return nil, fmt.Errorf("unexpected PEM block %q, expected a private key", block.Type)
+}
+
+// signerCache holds the signers of the private keys parsed so far, by PEM:
+// each key is parsed once rather than on every signed request.
+type signerCache struct {
+	mu      sync.Mutex
+	signers map[string]Signer
+}
+
+var parsedSigners = signerCache{signers: make(map[string]Signer)}
+
+// Signer returns the signer of the account: its private key when it has one,
+// otherwise HMAC with its secret key.
+func (a Account) Signer() (Signer, error) {
+	if a.PrivateKey != "" {
+		parsedSigners.mu.Lock()
+		defer parsedSigners.mu.Unlock()
+		if signer, ok := parsedSigners.signers[a.PrivateKey]; ok {
+			return signer, nil
+		}
+		signer, err := ParsePrivateKeySigner([]byte(a.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("%s private key: %w", a.Name, err)
+		}
+		parsedSigners.signers[a.PrivateKey] = signer
+		return signer, nil
+	}
+	if a.SecretKey == "" {
+		return nil, fmt.Errorf("%s has no secret or private key", a.Name)
+	}
+	return NewHMACSigner(a.SecretKey), nil
+}
+
+// signPayload appends the signature of account to payload, escaped as RSA
+// and Ed25519 signatures are base64.
+func signPayload(account Account, payload string) (string, error) {
+	signer, err := account.Signer()
+	if err != nil {
+		return "", err
+	}
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s&signature=%s", payload, neturl.QueryEscape(signature)), nil
+}
@@ -0,0 +1,532 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type StatementHolding struct {
+	Asset         string  `json:"asset"`
+	StartQty      float64 `json:"start_qty"`
+	EndQty        float64 `json:"end_qty"`
+	EndPrice      float64 `json:"end_price"`
+	EndValue      float64 `json:"end_value"`
+	Allocation    float64 `json:"allocation"`
+	UnrealizedPNL float64 `json:"unrealized_pnl"`
+	RealizedPNL   float64 `json:"realized_pnl"`
+}
+
+type StatementFee struct {
+	Asset string  `json:"asset"`
+	Qty   float64 `json:"qty"`
+	Value float64 `json:"value"`
+}
+
+type StatementTransfer struct {
+	Time  int64   `json:"time"`
+	Kind  string  `json:"kind"`
+	Asset string  `json:"asset"`
+	Qty   float64 `json:"qty"`
+	Value float64 `json:"value"`
+}
+
+// PortfolioStatement covers one calendar month (UTC). To is exclusive and
+// never later than the time the statement was generated.
+type PortfolioStatement struct {
+	Month         string              `json:"month"`
+	Currency      string              `json:"currency"`
+	From          int64               `json:"from"`
+	To            int64               `json:"to"`
+	GeneratedAt   int64               `json:"generated_at"`
+	StartValue    float64             `json:"start_value"`
+	EndValue      float64             `json:"end_value"`
+	NetTransfers  float64             `json:"net_transfers"`
+	Change        float64             `json:"change"`
+	Return        float64             `json:"return"`
+	RealizedPNL   float64             `json:"realized_pnl"`
+	UnrealizedPNL float64             `json:"unrealized_pnl"`
+	Fees          float64             `json:"fees"`
+	Holdings      []StatementHolding  `json:"holdings"`
+	FeesByAsset   []StatementFee      `json:"fees_by_asset"`
+	Transfers     []StatementTransfer `json:"transfers"`
+	Warnings      []string            `json:"warnings,omitempty"`
+}
+
+// StatementInput is what a statement is built from: the current balances,
+// every known trade, and the deposits and withdrawals from the start of the
+// month until now.
+type StatementInput struct {
+	WalletBalances []*WalletBalance
+	AssetToTrades  map[string][]Trade
+	Deposits       []Deposit
+	Withdrawals    []Withdrawal
+}
+
+// ParseStatementMonth reads "2006-01", defaulting to the previous month.
+func ParseStatementMonth(value string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	if strings.TrimSpace(value) == "" {
+		return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	month, err := time.Parse("2006-01", strings.TrimSpace(value))
+	if err != nil {
+		return month, fmt.Errorf("month must be YYYY-MM")
+	}
+	if month.After(now) {
+		return month, fmt.Errorf("%s hasn't started yet", value)
+	}
+	return month, nil
+}
+
+type balanceChange struct {
+	time  int64
+	asset string
+	qty   float64
+}
+
+// BuildStatement walks the current balances back through the trades and
+// transfers to get the holdings at the start and end of the month, values
+// them with the pricer, and works out the month's performance, PNL and fees.
+// Pending, cancelled and failed transfers are ignored.
+func BuildStatement(ctx context.Context, month time.Time, currency string, input StatementInput, pricer *HistoricalPricer, now time.Time) PortfolioStatement {
+	statement := PortfolioStatement{
+		Month:       month.Format("2006-01"),
+		Currency:    currency,
+		From:        month.UnixMilli(),
+		To:          min(month.AddDate(0, 1, 0).UnixMilli(), now.UnixMilli()),
+		GeneratedAt: now.UnixMilli(),
+	}
+	from, to := statement.From, statement.To
+	valueAt := func(asset string, qty float64, ts int64) float64 {
+		if qty == 0 {
+			return 0
+		}
+		price, err := pricer.Price(ctx, asset, ts)
+		if err != nil {
+			statement.Warnings = append(statement.Warnings, fmt.Sprintf("%s: no %s price at %s: %v", asset, currency, time.UnixMilli(ts).UTC().Format(time.DateTime), err))
+		}
+		return qty * price
+	}
+
+	var changes []balanceChange
+	for symbol, trades := range input.AssetToTrades {
+		base, quote := SplitSymbol(symbol)
+		for _, trade := range trades {
+			qty, _ := strconv.ParseFloat(trade.Qty, 64)
+			quoteQty, _ := strconv.ParseFloat(trade.QuoteQty, 64)
+			commission, _ := strconv.ParseFloat(trade.Commission, 64)
+			ts := int64(trade.Time)
+			if !trade.IsBuyer {
+				qty, quoteQty = -qty, -quoteQty
+			}
+			changes = append(changes,
+				balanceChange{ts, base, qty},
+				balanceChange{ts, quote, -quoteQty},
+				balanceChange{ts, trade.CommissionAsset, -commission},
+			)
+		}
+	}
+	for _, deposit := range input.Deposits {
+		if deposit.Status != 1 && deposit.Status != 6 {
+			continue
+		}
+		qty, _ := strconv.ParseFloat(deposit.Amount, 64)
+		changes = append(changes, balanceChange{deposit.InsertTime, deposit.Coin, qty})
+		if deposit.InsertTime >= from && deposit.InsertTime < to {
+			statement.Transfers = append(statement.Transfers, StatementTransfer{Time: deposit.InsertTime, Kind: "deposit", Asset: deposit.Coin, Qty: qty, Value: valueAt(deposit.Coin, qty, deposit.InsertTime)})
+		}
+	}
+	for _, withdrawal := range input.Withdrawals {
+		if withdrawal.Status == 1 || withdrawal.Status == 3 || withdrawal.Status == 5 {
+			continue
+		}
+		qty, _ := strconv.ParseFloat(withdrawal.Amount, 64)
+		fee, _ := strconv.ParseFloat(withdrawal.TransactionFee, 64)
+		ts := withdrawal.ApplyTimestamp()
+		changes = append(changes, balanceChange{ts, withdrawal.Coin, -qty - fee})
+		if ts >= from && ts < to {
+			statement.Transfers = append(statement.Transfers, StatementTransfer{Time: ts, Kind: "withdrawal", Asset: withdrawal.Coin, Qty: qty, Value: valueAt(withdrawal.Coin, qty, ts)})
+		}
+	}
+	sort.Slice(statement.Transfers, func(i, j int) bool {
+		return statement.Transfers[i].Time < statement.Transfers[j].Time
+	})
+
+	// current balances minus everything that happened since gives the
+	// balances at a point in time
+	assetToHolding := make(map[string]*StatementHolding)
+	holding := func(asset string) *StatementHolding {
+		if _, ok := assetToHolding[asset]; !ok {
+			assetToHolding[asset] = &StatementHolding{Asset: asset}
+		}
+		return assetToHolding[asset]
+	}
+	for _, balance := range input.WalletBalances {
+		h := holding(balance.Symbol)
+		h.StartQty += balance.Free + balance.Locked
+		h.EndQty += balance.Free + balance.Locked
+	}
+	for _, change := range changes {
+		if change.asset == "" || change.qty == 0 {
+			continue
+		}
+		h := holding(change.asset)
+		if change.time >= from {
+			h.StartQty -= change.qty
+		}
+		if change.time >= to {
+			h.EndQty -= change.qty
+		}
+	}
+	for _, h := range assetToHolding {
+		if h.StartQty < -1e-8 || h.EndQty < -1e-8 {
+			statement.Warnings = append(statement.Warnings, fmt.Sprintf("%s: balance history is incomplete, the %s balance comes out negative", h.Asset, statement.Month))
+		}
+		h.StartQty, h.EndQty = math.Max(h.StartQty, 0), math.Max(h.EndQty, 0)
+		if h.StartQty < 1e-12 {
+			h.StartQty = 0
+		}
+		if h.EndQty < 1e-12 {
+			h.EndQty = 0
+		}
+		statement.StartValue += valueAt(h.Asset, h.StartQty, from)
+		h.EndValue = valueAt(h.Asset, h.EndQty, to-1)
+		if h.EndQty > 0 {
+			h.EndPrice = h.EndValue / h.EndQty
+		}
+		statement.EndValue += h.EndValue
+	}
+
+	// modified Dietz: transfers are weighted by how much of the month they
+	// were invested for
+	weightedTransfers := 0.0
+	for _, transfer := range statement.Transfers {
+		value := transfer.Value
+		if transfer.Kind == "withdrawal" {
+			value = -value
+		}
+		statement.NetTransfers += value
+		weightedTransfers += value * float64(to-transfer.Time) / float64(to-from)
+	}
+	statement.Change = statement.EndValue - statement.StartValue - statement.NetTransfers
+	if invested := statement.StartValue + weightedTransfers; invested > 0 {
+		statement.Return = statement.Change / invested * 100
+	}
+
+	assetToFee := make(map[string]*StatementFee)
+	for symbol, trades := range input.AssetToTrades {
+		base, quote := SplitSymbol(symbol)
+		var events []TaxEvent
+		for _, event := range pairLotEvents(symbol, trades) {
+			if event.Time < to {
+				events = append(events, event)
+			}
+		}
+		disposals, _ := MatchLots(events, LotFIFO)
+		var remainingQty, remainingCost float64
+		for _, event := range events {
+			if event.Kind == TaxAcquisition {
+				remainingQty += event.Qty
+				remainingCost += event.Value + event.Fee
+			}
+		}
+		for _, disposal := range disposals {
+			if !disposal.Unmatched {
+				remainingQty -= disposal.Qty
+				remainingCost -= disposal.CostBasis
+			}
+			if disposal.DisposedAt >= from && disposal.DisposedAt < to {
+				pnl := valueAt(quote, disposal.Gain, disposal.DisposedAt)
+				holding(base).RealizedPNL += pnl
+				statement.RealizedPNL += pnl
+			}
+		}
+		if remainingQty > 1e-12 && quote != "" {
+			unrealized := valueAt(base, remainingQty, to-1) - valueAt(quote, remainingCost, to-1)
+			holding(base).UnrealizedPNL += unrealized
+			statement.UnrealizedPNL += unrealized
+		}
+
+		for _, trade := range trades {
+			commission, _ := strconv.ParseFloat(trade.Commission, 64)
+			if int64(trade.Time) < from || int64(trade.Time) >= to || commission == 0 {
+				continue
+			}
+			fee, ok := assetToFee[trade.CommissionAsset]
+			if !ok {
+				fee = &StatementFee{Asset: trade.CommissionAsset}
+				assetToFee[trade.CommissionAsset] = fee
+			}
+			fee.Qty += commission
+			value := valueAt(trade.CommissionAsset, commission, int64(trade.Time))
+			fee.Value += value
+			statement.Fees += value
+		}
+	}
+	for _, fee := range assetToFee {
+		statement.FeesByAsset = append(statement.FeesByAsset, *fee)
+	}
+	sort.Slice(statement.FeesByAsset, func(i, j int) bool {
+		return statement.FeesByAsset[i].Value > statement.FeesByAsset[j].Value
+	})
+
+	for _, h := range assetToHolding {
+		if h.StartQty == 0 && h.EndQty == 0 && h.RealizedPNL == 0 {
+			continue
+		}
+		if statement.EndValue > 0 {
+			h.Allocation = h.EndValue / statement.EndValue * 100
+		}
+		statement.Holdings = append(statement.Holdings, *h)
+	}
+	sort.Slice(statement.Holdings, func(i, j int) bool {
+		if statement.Holdings[i].EndValue != statement.Holdings[j].EndValue {
+			return statement.Holdings[i].EndValue > statement.Holdings[j].EndValue
+		}
+		return statement.Holdings[i].Asset < statement.Holdings[j].Asset
+	})
+	sort.Strings(statement.Warnings)
+	return statement
+}
+
+// formatStatementNumber formats with thousands separators and the given
+// number of decimals.
+func formatStatementNumber(value float64, decimals int) string {
+	text := strconv.FormatFloat(math.Abs(value), 'f', decimals, 64)
+	whole, fraction, _ := strings.Cut(text, ".")
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	if fraction != "" {
+		grouped.WriteString("." + fraction)
+	}
+	if value < 0 && strings.Trim(grouped.String(), "0.,") != "" {
+		return "-" + grouped.String()
+	}
+	return grouped.String()
+}
+
+func formatStatementQty(value float64) string {
+	text := strconv.FormatFloat(value, 'f', 8, 64)
+	text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	if text == "" {
+		return "0"
+	}
+	return text
+}
+
+func pnlColor(value float64) PDFColor {
+	if value < 0 {
+		return pdfRed
+	}
+	return pdfGreen
+}
+
+var statementPalette = []PDFColor{
+	{0.95, 0.73, 0.18}, {0.25, 0.47, 0.85}, {0.18, 0.65, 0.45}, {0.85, 0.35, 0.3},
+	{0.55, 0.4, 0.8}, {0.2, 0.7, 0.8}, {0.9, 0.55, 0.2}, {0.6, 0.6, 0.6},
+}
+
+// statementColumn is a table column, right-aligned at X unless it's the
+// first one.
+type statementColumn struct {
+	Title string
+	X     float64
+}
+
+type statementPage struct {
+	doc *PDFDocument
+	y   float64
+}
+
+const statementMargin = 40
+
+func (p *statementPage) ensureSpace(height float64) {
+	if p.y+height > p.doc.Height-statementMargin {
+		p.doc.AddPage()
+		p.y = statementMargin + 10
+	}
+}
+
+func (p *statementPage) heading(title string) {
+	p.ensureSpace(50)
+	p.y += 24
+	p.doc.Text(statementMargin, p.y, 12, true, pdfBlack, title)
+	p.y += 8
+}
+
+func (p *statementPage) tableHeader(columns []statementColumn) {
+	p.ensureSpace(30)
+	p.doc.Rect(statementMargin, p.y, p.doc.Width-2*statementMargin, 16, pdfLight)
+	for i, column := range columns {
+		if i == 0 {
+			p.doc.Text(column.X, p.y+11, 8, true, pdfBlack, column.Title)
+		} else {
+			p.doc.TextRight(column.X, p.y+11, 8, true, pdfBlack, column.Title)
+		}
+	}
+	p.y += 16
+}
+
+type statementCell struct {
+	Text  string
+	Color PDFColor
+}
+
+func (p *statementPage) tableRow(columns []statementColumn, cells []statementCell) {
+	if p.y+14 > p.doc.Height-statementMargin {
+		p.ensureSpace(14)
+		p.tableHeader(columns)
+	}
+	for i, cell := range cells {
+		if i == 0 {
+			p.doc.Text(columns[i].X, p.y+10, 8, false, cell.Color, cell.Text)
+		} else {
+			p.doc.TextRight(columns[i].X, p.y+10, 8, false, cell.Color, cell.Text)
+		}
+	}
+	p.y += 14
+	p.doc.Line(statementMargin, p.y, p.doc.Width-statementMargin, p.y, 0.3, pdfLight)
+}
+
+// WritePDF renders the statement as an A4 PDF.
+func (s PortfolioStatement) WritePDF(w io.Writer) error {
+	doc := NewPDFDocument()
+	doc.AddPage()
+	page := &statementPage{doc: doc, y: 58}
+	right := doc.Width - statementMargin
+	money := func(value float64) string {
+		return formatStatementNumber(value, 2)
+	}
+	monthStart := time.UnixMilli(s.From).UTC()
+	lastDay := time.UnixMilli(s.To - 1).UTC()
+
+	doc.Text(statementMargin, page.y, 18, true, pdfBlack, "Portfolio statement")
+	doc.TextRight(right, page.y, 14, true, pdfBlack, monthStart.Format("January 2006"))
+	page.y += 18
+	doc.Text(statementMargin, page.y, 9, false, pdfGrey, fmt.Sprintf("%s - %s · values in %s · generated %s UTC", monthStart.Format("2 Jan 2006"), lastDay.Format("2 Jan 2006"), s.Currency, time.UnixMilli(s.GeneratedAt).UTC().Format("2 Jan 2006 15:04")))
+	page.y += 10
+	doc.Line(statementMargin, page.y, right, page.y, 0.8, pdfBlack)
+	page.y += 12
+
+	cards := func(labels []string, values []string, colors []PDFColor) {
+		gap := 8.0
+		width := (right - statementMargin - gap*float64(len(labels)-1)) / float64(len(labels))
+		for i, label := range labels {
+			x := statementMargin + float64(i)*(width+gap)
+			doc.Rect(x, page.y, width, 44, pdfLight)
+			doc.Text(x+8, page.y+15, 8, false, pdfGrey, label)
+			doc.Text(x+8, page.y+33, 12, true, colors[i], values[i])
+		}
+		page.y += 52
+	}
+	cards(
+		[]string{"Start value", "End value", "Net deposits", "Change", "Return"},
+		[]string{money(s.StartValue), money(s.EndValue), money(s.NetTransfers), money(s.Change), formatStatementNumber(s.Return, 2) + "%"},
+		[]PDFColor{pdfBlack, pdfBlack, pdfBlack, pnlColor(s.Change), pnlColor(s.Return)},
+	)
+	cards(
+		[]string{"Realized PNL", "Unrealized PNL", "Trading fees"},
+		[]string{money(s.RealizedPNL), money(s.UnrealizedPNL), money(s.Fees)},
+		[]PDFColor{pnlColor(s.RealizedPNL), pnlColor(s.UnrealizedPNL), pdfBlack},
+	)
+
+	page.heading("Allocation at month end")
+	var pieSlices []StatementHolding
+	var other float64
+	for _, holding := range s.Holdings {
+		switch {
+		case holding.EndValue <= 0:
+		case len(pieSlices) < len(statementPalette)-1:
+			pieSlices = append(pieSlices, holding)
+		default:
+			other += holding.EndValue
+		}
+	}
+	if other > 0 {
+		pieSlices = append(pieSlices, StatementHolding{Asset: "Other", EndValue: other, Allocation: other / s.EndValue * 100})
+	}
+	radius := 70.0
+	centerX, centerY := statementMargin+radius+10, page.y+radius+10
+	angle := 0.0
+	for i, slice := range pieSlices {
+		sweep := slice.EndValue / s.EndValue * 2 * math.Pi
+		doc.PieSlice(centerX, centerY, radius, angle, angle+sweep, statementPalette[i%len(statementPalette)])
+		angle += sweep
+	}
+	if len(pieSlices) == 0 {
+		doc.Text(statementMargin, page.y+20, 9, false, pdfGrey, "Nothing held at the end of the month.")
+	}
+	legendY := page.y + 20
+	for i, slice := range pieSlices {
+		doc.Rect(240, legendY-8, 9, 9, statementPalette[i%len(statementPalette)])
+		doc.Text(256, legendY, 9, false, pdfBlack, slice.Asset)
+		doc.TextRight(380, legendY, 9, false, pdfBlack, formatStatementNumber(slice.Allocation, 1)+"%")
+		doc.TextRight(right, legendY, 9, false, pdfBlack, money(slice.EndValue))
+		legendY += 15
+	}
+	page.y = math.Max(centerY+radius, legendY) + 4
+
+	page.heading("Holdings and PNL")
+	holdingColumns := []statementColumn{{"Asset", statementMargin + 4}, {"Start qty", 170}, {"End qty", 250}, {"Price", 315}, {"Value", 385}, {"Alloc.", 425}, {"Unrealized", 490}, {"Realized", right - 4}}
+	page.tableHeader(holdingColumns)
+	for _, holding := range s.Holdings {
+		page.tableRow(holdingColumns, []statementCell{
+			{holding.Asset, pdfBlack},
+			{formatStatementQty(holding.StartQty), pdfBlack},
+			{formatStatementQty(holding.EndQty), pdfBlack},
+			{money(holding.EndPrice), pdfBlack},
+			{money(holding.EndValue), pdfBlack},
+			{formatStatementNumber(holding.Allocation, 1) + "%", pdfBlack},
+			{money(holding.UnrealizedPNL), pnlColor(holding.UnrealizedPNL)},
+			{money(holding.RealizedPNL), pnlColor(holding.RealizedPNL)},
+		})
+	}
+
+	page.heading("Trading fees")
+	feeColumns := []statementColumn{{"Asset", statementMargin + 4}, {"Quantity", 385}, {"Value", right - 4}}
+	page.tableHeader(feeColumns)
+	for _, fee := range s.FeesByAsset {
+		page.tableRow(feeColumns, []statementCell{{fee.Asset, pdfBlack}, {formatStatementQty(fee.Qty), pdfBlack}, {money(fee.Value), pdfBlack}})
+	}
+	page.tableRow(feeColumns, []statementCell{{"Total", pdfBlack}, {"", pdfBlack}, {money(s.Fees), pdfBlack}})
+
+	page.heading("Deposits and withdrawals")
+	transferColumns := []statementColumn{{"Date", statementMargin + 4}, {"Type", 200}, {"Asset", 260}, {"Quantity", 385}, {"Value", right - 4}}
+	page.tableHeader(transferColumns)
+	if len(s.Transfers) == 0 {
+		page.tableRow(transferColumns, []statementCell{{"None this month", pdfGrey}})
+	}
+	for _, transfer := range s.Transfers {
+		page.tableRow(transferColumns, []statementCell{
+			{time.UnixMilli(transfer.Time).UTC().Format("2 Jan 2006 15:04"), pdfBlack},
+			{transfer.Kind, pdfBlack},
+			{transfer.Asset, pdfBlack},
+			{formatStatementQty(transfer.Qty), pdfBlack},
+			{money(transfer.Value), pdfBlack},
+		})
+	}
+
+	if len(s.Warnings) > 0 {
+		page.heading("Notes")
+		for _, warning := range s.Warnings {
+			page.ensureSpace(12)
+			page.y += 12
+			doc.Text(statementMargin, page.y, 7, false, pdfGrey, warning)
+		}
+	}
+	page.ensureSpace(24)
+	page.y += 24
+	doc.Text(statementMargin, page.y, 7, false, pdfGrey, "Balances are reconstructed from current balances, known trades and transfers. Prices are hourly Binance closes. Realized PNL uses FIFO.")
+	return doc.Write(w)
+}
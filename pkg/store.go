@@ -0,0 +1,306 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LedgerEntry is a balance change from a Binance Transaction History export
+// that isn't a trade: deposits, withdrawals, distributions, earn interest...
+type LedgerEntry struct {
+	Time      int64  `json:"time"`
+	Account   string `json:"account"`
+	Operation string `json:"operation"`
+	Coin      string `json:"coin"`
+	Change    string `json:"change"`
+	Remark    string `json:"remark,omitempty"`
+}
+
+func (e LedgerEntry) key() string {
+	return fmt.Sprintf("%d|%s|%s|%s|%s", e.Time, e.Account, e.Operation, e.Coin, e.Change)
+}
+
+type ImportResult struct {
+	File            string   `json:"file"`
+	Format          string   `json:"format"`
+	Trades          int      `json:"trades"`
+	DuplicateTrades int      `json:"duplicate_trades"`
+	Ledger          int      `json:"ledger"`
+	DuplicateLedger int      `json:"duplicate_ledger"`
+	Warnings        []string `json:"warnings,omitempty"`
+}
+
+type tradeStoreData struct {
+	APITrades      map[string][]Trade `json:"api_trades"`
+	ImportedTrades map[string][]Trade `json:"imported_trades"`
+	Ledger         []LedgerEntry      `json:"ledger"`
+}
+
+// TradeStore keeps the trades synced from /myTrades next to the ones imported
+// from export files, persisted as one JSON file. API trades are the source
+// of truth: an imported trade is dropped as soon as the API returns the same
+// fill.
+type TradeStore struct {
+	mu     sync.Mutex
+	path   string
+	data   tradeStoreData
+	synced map[string]time.Time
+	// capped are the symbols whose last sync stopped before the latest trade
+	capped map[string]bool
+}
+
+// OpenTradeStore loads the store at path ("trades-store.json" when empty),
+// starting empty if the file doesn't exist yet.
+func OpenTradeStore(path string) (*TradeStore, error) {
+	if path == "" {
+		path = "trades-store.json"
+	}
+	store := &TradeStore{
+		path: path,
+		data: tradeStoreData{
+			APITrades:      make(map[string][]Trade),
+			ImportedTrades: make(map[string][]Trade),
+		},
+		synced: make(map[string]time.Time),
+		capped: make(map[string]bool),
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &store.data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if store.data.APITrades == nil {
+		store.data.APITrades = make(map[string][]Trade)
+	}
+	if store.data.ImportedTrades == nil {
+		store.data.ImportedTrades = make(map[string][]Trade)
+	}
+	return store, nil
+}
+
+func (s *TradeStore) save() error {
+	content, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// tradeBucket groups fills by symbol, side and second. Exports only have
+// second precision and the Transaction History merges the fills of one
+// second, so duplicates are detected per bucket rather than per fill.
+func tradeBucket(trade Trade) string {
+	return fmt.Sprintf("%s|%d|%t", trade.Symbol, trade.Time/1000, trade.IsBuyer)
+}
+
+func tradeFillKey(trade Trade) string {
+	qty, _ := strconv.ParseFloat(trade.Qty, 64)
+	price, _ := strconv.ParseFloat(trade.Price, 64)
+	return fmt.Sprintf("%s|%v|%v", tradeBucket(trade), qty, price)
+}
+
+// Synced reports whether symbol was fetched from the API since startup.
+func (s *TradeStore) Synced(symbol string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.synced[symbol].IsZero()
+}
+
+// SyncedAt returns when symbol was last fetched from the API, zero if not
+// since startup.
+func (s *TradeStore) SyncedAt(symbol string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.synced[symbol]
+}
+
+// Capped reports whether the last sync of symbol stopped at the page limit,
+// before the latest trade.
+func (s *TradeStore) Capped(symbol string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.capped[symbol]
+}
+
+// NextTradeID is the fromId to sync symbol from: the trade after the last
+// one stored from the API, 0 for the first trade when there's none.
+func (s *TradeStore) NextTradeID(symbol string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next int64
+	for _, trade := range s.data.APITrades[symbol] {
+		next = max(next, trade.ID+1)
+	}
+	return next
+}
+
+// SyncTrades merges the trades returned by the API for symbol, keeping older
+// ones that fell out of the API window, and drops imported trades the API
+// now covers. capped says the API has later trades that weren't fetched.
+func (s *TradeStore) SyncTrades(symbol string, trades []Trade, capped bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capped[symbol] = capped
+	idToTrade := make(map[int64]Trade)
+	for _, trade := range s.data.APITrades[symbol] {
+		idToTrade[trade.ID] = trade
+	}
+	for _, trade := range trades {
+		idToTrade[trade.ID] = trade
+	}
+	merged := make([]Trade, 0, len(idToTrade))
+	apiBuckets := make(map[string]bool)
+	for _, trade := range idToTrade {
+		merged = append(merged, trade)
+		apiBuckets[tradeBucket(trade)] = true
+	}
+	sortTrades(merged)
+	if len(merged) > 0 {
+		s.data.APITrades[symbol] = merged
+	}
+	var imported []Trade
+	for _, trade := range s.data.ImportedTrades[symbol] {
+		if !apiBuckets[tradeBucket(trade)] {
+			imported = append(imported, trade)
+		}
+	}
+	if len(imported) == 0 {
+		delete(s.data.ImportedTrades, symbol)
+	} else {
+		s.data.ImportedTrades[symbol] = imported
+	}
+	s.synced[symbol] = time.Now()
+	return s.save()
+}
+
+// Trades returns the API and imported trades of symbol, oldest first.
+func (s *TradeStore) Trades(symbol string) []Trade {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trades(symbol)
+}
+
+func (s *TradeStore) trades(symbol string) []Trade {
+	var trades []Trade
+	trades = append(trades, s.data.APITrades[symbol]...)
+	trades = append(trades, s.data.ImportedTrades[symbol]...)
+	sortTrades(trades)
+	return trades
+}
+
+// AssetTrades returns the trades of every symbol in the store.
+func (s *TradeStore) AssetTrades() map[string][]Trade {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assetToTrades := make(map[string][]Trade)
+	for symbol := range s.data.APITrades {
+		assetToTrades[symbol] = s.trades(symbol)
+	}
+	for symbol := range s.data.ImportedTrades {
+		assetToTrades[symbol] = s.trades(symbol)
+	}
+	return assetToTrades
+}
+
+func (s *TradeStore) Ledger() []LedgerEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]LedgerEntry(nil), s.data.Ledger...)
+}
+
+// Import adds the trades and ledger entries of a parsed export. A bucket of
+// fills that is already fully covered by stored trades is skipped, so the
+// same file (or a Trade History and a Transaction History of the same
+// period) can be imported more than once.
+func (s *TradeStore) Import(batch ImportBatch) (ImportResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := ImportResult{Format: batch.Format, Warnings: batch.Warnings}
+
+	bucketToQty := make(map[string]float64)
+	fillKeys := make(map[string]int)
+	for _, symbolTrades := range []map[string][]Trade{s.data.APITrades, s.data.ImportedTrades} {
+		for _, trades := range symbolTrades {
+			for _, trade := range trades {
+				qty, _ := strconv.ParseFloat(trade.Qty, 64)
+				bucketToQty[tradeBucket(trade)] += qty
+				fillKeys[tradeFillKey(trade)]++
+			}
+		}
+	}
+	var buckets []string
+	bucketToTrades := make(map[string][]Trade)
+	for _, trade := range batch.Trades {
+		bucket := tradeBucket(trade)
+		if _, ok := bucketToTrades[bucket]; !ok {
+			buckets = append(buckets, bucket)
+		}
+		bucketToTrades[bucket] = append(bucketToTrades[bucket], trade)
+	}
+	for _, bucket := range buckets {
+		trades := bucketToTrades[bucket]
+		var qty float64
+		for _, trade := range trades {
+			tradeQty, _ := strconv.ParseFloat(trade.Qty, 64)
+			qty += tradeQty
+		}
+		if stored := bucketToQty[bucket]; stored > 0 && stored >= qty*(1-1e-9) {
+			result.DuplicateTrades += len(trades)
+			continue
+		}
+		for _, trade := range trades {
+			if key := tradeFillKey(trade); fillKeys[key] > 0 {
+				fillKeys[key]--
+				result.DuplicateTrades++
+				continue
+			}
+			s.data.ImportedTrades[trade.Symbol] = append(s.data.ImportedTrades[trade.Symbol], trade)
+			result.Trades++
+		}
+	}
+	for symbol := range s.data.ImportedTrades {
+		sortTrades(s.data.ImportedTrades[symbol])
+	}
+
+	ledgerKeys := make(map[string]int)
+	for _, entry := range s.data.Ledger {
+		ledgerKeys[entry.key()]++
+	}
+	for _, entry := range batch.Ledger {
+		if key := entry.key(); ledgerKeys[key] > 0 {
+			ledgerKeys[key]--
+			result.DuplicateLedger++
+			continue
+		}
+		s.data.Ledger = append(s.data.Ledger, entry)
+		result.Ledger++
+	}
+	sort.SliceStable(s.data.Ledger, func(i, j int) bool {
+		return s.data.Ledger[i].Time < s.data.Ledger[j].Time
+	})
+	return result, s.save()
+}
+
+func sortTrades(trades []Trade) {
+	sort.SliceStable(trades, func(i, j int) bool {
+		if trades[i].Time != trades[j].Time {
+			return trades[i].Time < trades[j].Time
+		}
+		return trades[i].ID < trades[j].ID
+	})
+}
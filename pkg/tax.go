@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type TaxEventKind string
+
+const (
+	TaxAcquisition TaxEventKind = "acquisition"
+	TaxDisposal    TaxEventKind = "disposal"
+	TaxTransferOut TaxEventKind = "transfer_out"
+)
+
+// TaxEvent is one leg of a trade, deposit or withdrawal valued in fiat.
+// Value is the proceeds of a disposal or the cost of an acquisition; Fee is
+// the allowable incidental cost attached to it.
+type TaxEvent struct {
+	Kind   TaxEventKind `json:"kind"`
+	Asset  string       `json:"asset"`
+	Time   int64        `json:"time"`
+	Qty    float64      `json:"qty"`
+	Value  float64      `json:"value"`
+	Fee    float64      `json:"fee"`
+	Source string       `json:"source"`
+}
+
+type TaxInput struct {
+	Trades      []Trade
+	Deposits    []Deposit
+	Withdrawals []Withdrawal
+	// Transfers are withdrawals into another of the accounts: the coins are
+	// still the owner's, only the fee leaves
+	Transfers []Withdrawal
+}
+
+// AccountCapitalHistory is the deposits and withdrawals of one account.
+type AccountCapitalHistory struct {
+	Account     string
+	Deposits    []Deposit
+	Withdrawals []Withdrawal
+}
+
+// internalTransferWindow is how long after a withdrawal the deposit it
+// became may arrive.
+const internalTransferWindow = 24 * time.Hour
+
+// MatchInternalTransfers finds the withdrawals that were deposited into
+// another of the accounts: a deposit of the same coin with the same txId or,
+// without one, the same amount within a day. It returns the deposits and
+// withdrawals left, to and from elsewhere, and the matched withdrawals.
+func MatchInternalTransfers(histories []AccountCapitalHistory) ([]Deposit, []Withdrawal, []Withdrawal) {
+	type accountDeposit struct {
+		account string
+		deposit Deposit
+		matched bool
+	}
+	var deposits []*accountDeposit
+	for _, history := range histories {
+		for _, deposit := range history.Deposits {
+			deposits = append(deposits, &accountDeposit{account: history.Account, deposit: deposit})
+		}
+	}
+	match := func(account string, withdrawal Withdrawal) *accountDeposit {
+		amount, _ := strconv.ParseFloat(withdrawal.Amount, 64)
+		applied := withdrawal.ApplyTimestamp()
+		var byAmount *accountDeposit
+		for _, candidate := range deposits {
+			if candidate.matched || candidate.account == account || candidate.deposit.Coin != withdrawal.Coin {
+				continue
+			}
+			if withdrawal.TxId != "" && candidate.deposit.TxId == withdrawal.TxId {
+				return candidate
+			}
+			depositAmount, _ := strconv.ParseFloat(candidate.deposit.Amount, 64)
+			arrival := candidate.deposit.InsertTime - applied
+			if byAmount == nil && math.Abs(depositAmount-amount) < 1e-9 && arrival >= 0 && arrival <= internalTransferWindow.Milliseconds() {
+				byAmount = candidate
+			}
+		}
+		return byAmount
+	}
+	var withdrawals, transfers []Withdrawal
+	for _, history := range histories {
+		for _, withdrawal := range history.Withdrawals {
+			if deposit := match(history.Account, withdrawal); deposit != nil {
+				deposit.matched = true
+				transfers = append(transfers, withdrawal)
+			} else {
+				withdrawals = append(withdrawals, withdrawal)
+			}
+		}
+	}
+	var external []Deposit
+	for _, deposit := range deposits {
+		if !deposit.matched {
+			external = append(external, deposit.deposit)
+		}
+	}
+	return external, withdrawals, transfers
+}
+
+// BuildTaxEvents splits every trade into the disposal of what was given up and
+// the acquisition of what was received, valuing both at the fiat value of the
+// quote side at trade time. Fiat legs are dropped since fiat isn't an asset
+// for capital gains. The trade fee is an allowable cost of the disposal leg,
+// or of the acquisition when fiat was spent. Deposits are acquisitions at
+// market value and withdrawals are transfers out of the pool; a transfer
+// between the accounts only takes its fee out.
+func BuildTaxEvents(ctx context.Context, input TaxInput, pricer *HistoricalPricer) ([]TaxEvent, []string) {
+	var events []TaxEvent
+	var warnings []string
+	for _, trade := range input.Trades {
+		base, quote := SplitSymbol(trade.Symbol)
+		if quote == "" {
+			warnings = append(warnings, fmt.Sprintf("%s: unknown quote asset, trade %d skipped", trade.Symbol, trade.ID))
+			continue
+		}
+		ts := int64(trade.Time)
+		qty, _ := strconv.ParseFloat(trade.Qty, 64)
+		quoteQty, _ := strconv.ParseFloat(trade.QuoteQty, 64)
+		commission, _ := strconv.ParseFloat(trade.Commission, 64)
+		quotePrice, err := pricer.Price(ctx, quote, ts)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: no %s price for trade %d: %v", trade.Symbol, pricer.Fiat, trade.ID, err))
+			continue
+		}
+		value := quoteQty * quotePrice
+		var fee float64
+		if commission > 0 && trade.CommissionAsset != "" {
+			commissionPrice, err := pricer.Price(ctx, trade.CommissionAsset, ts)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: no %s price for the %s commission of trade %d, counted as no fee: %v", trade.Symbol, pricer.Fiat, trade.CommissionAsset, trade.ID, err))
+			}
+			fee = commission * commissionPrice
+		}
+		source := fmt.Sprintf("trade %s #%d", trade.Symbol, trade.ID)
+
+		given, givenQty, received, receivedQty := quote, quoteQty, base, qty
+		if !trade.IsBuyer {
+			given, givenQty, received, receivedQty = base, qty, quote, quoteQty
+		}
+		if trade.CommissionAsset == received {
+			// the fee was taken out of what we received
+			receivedQty -= commission
+		}
+		disposal := TaxEvent{Kind: TaxDisposal, Asset: given, Time: ts, Qty: givenQty, Value: value, Source: source}
+		acquisition := TaxEvent{Kind: TaxAcquisition, Asset: received, Time: ts, Qty: receivedQty, Value: value, Source: source}
+		if config.IsFiat(given) {
+			acquisition.Fee = fee
+		} else {
+			disposal.Fee = fee
+			events = append(events, disposal)
+		}
+		if !config.IsFiat(received) {
+			events = append(events, acquisition)
+		}
+	}
+	for _, deposit := range input.Deposits {
+		if config.IsFiat(deposit.Coin) {
+			continue
+		}
+		qty, _ := strconv.ParseFloat(deposit.Amount, 64)
+		price, err := pricer.Price(ctx, deposit.Coin, deposit.InsertTime)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: no %s price for deposit %s: %v", deposit.Coin, pricer.Fiat, deposit.TxId, err))
+			continue
+		}
+		events = append(events, TaxEvent{Kind: TaxAcquisition, Asset: deposit.Coin, Time: deposit.InsertTime, Qty: qty, Value: qty * price, Source: "deposit " + deposit.TxId})
+	}
+	for _, withdrawal := range input.Withdrawals {
+		if config.IsFiat(withdrawal.Coin) {
+			continue
+		}
+		qty, _ := strconv.ParseFloat(withdrawal.Amount, 64)
+		events = append(events, TaxEvent{Kind: TaxTransferOut, Asset: withdrawal.Coin, Time: withdrawal.ApplyTimestamp(), Qty: qty, Source: "withdrawal " + withdrawal.Id})
+	}
+	for _, transfer := range input.Transfers {
+		fee, _ := strconv.ParseFloat(transfer.TransactionFee, 64)
+		if config.IsFiat(transfer.Coin) || fee <= 0 {
+			continue
+		}
+		events = append(events, TaxEvent{Kind: TaxTransferOut, Asset: transfer.Coin, Time: transfer.ApplyTimestamp(), Qty: fee, Source: "transfer fee " + transfer.Id})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time < events[j].Time
+	})
+	return events, warnings
+}
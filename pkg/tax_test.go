@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMatchInternalTransfers(t *testing.T) {
+	applied := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	withdrawal := func(id string, coin string, amount string, txID string) Withdrawal {
+		return Withdrawal{Id: id, Coin: coin, Amount: amount, TransactionFee: "0.0001", TxId: txID, ApplyTime: applied.Format(time.DateTime)}
+	}
+	deposit := func(coin string, amount string, txID string, after time.Duration) Deposit {
+		return Deposit{Coin: coin, Amount: amount, TxId: txID, InsertTime: applied.Add(after).UnixMilli()}
+	}
+	histories := []AccountCapitalHistory{
+		{Account: MainAccount, Deposits: []Deposit{deposit("ETH", "2", "external", -time.Hour)}, Withdrawals: []Withdrawal{
+			withdrawal("by-txid", "BTC", "1", "tx1"),
+			withdrawal("by-amount", "BTC", "0.5", ""),
+			withdrawal("too-late", "ETH", "3", ""),
+			withdrawal("elsewhere", "BTC", "0.7", "tx9"),
+		}},
+		{Account: "longterm", Deposits: []Deposit{
+			deposit("BTC", "1", "tx1", 3*time.Hour),
+			deposit("BTC", "0.5", "", time.Hour),
+			deposit("ETH", "3", "", 25*time.Hour),
+		}},
+	}
+	deposits, withdrawals, transfers := MatchInternalTransfers(histories)
+	ids := func(withdrawals []Withdrawal) []string {
+		var ids []string
+		for _, withdrawal := range withdrawals {
+			ids = append(ids, withdrawal.Id)
+		}
+		return ids
+	}
+	if got := ids(transfers); len(got) != 2 || got[0] != "by-txid" || got[1] != "by-amount" {
+		t.Errorf("transfers = %v, want [by-txid by-amount]", got)
+	}
+	if got := ids(withdrawals); len(got) != 2 || got[0] != "too-late" || got[1] != "elsewhere" {
+		t.Errorf("withdrawals = %v, want [too-late elsewhere]", got)
+	}
+	if len(deposits) != 2 || deposits[0].TxId != "external" || deposits[1].Coin != "ETH" || deposits[1].Amount != "3" {
+		t.Errorf("deposits = %+v, want the external ETH and the late ETH", deposits)
+	}
+
+	// a deposit into the same account is never a transfer to itself
+	_, withdrawals, transfers = MatchInternalTransfers([]AccountCapitalHistory{
+		{Account: MainAccount, Deposits: []Deposit{deposit("BTC", "1", "tx1", time.Hour)}, Withdrawals: []Withdrawal{withdrawal("self", "BTC", "1", "tx1")}},
+	})
+	if len(transfers) != 0 || len(withdrawals) != 1 {
+		t.Errorf("transfers = %v, withdrawals = %v, want the withdrawal kept", transfers, withdrawals)
+	}
+}
+
+func TestBuildTaxEventsTransferFee(t *testing.T) {
+	applied := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	input := TaxInput{Transfers: []Withdrawal{{Id: "w1", Coin: "BTC", Amount: "1", TransactionFee: "0.0002", ApplyTime: applied.Format(time.DateTime)}}}
+	events, warnings := BuildTaxEvents(context.Background(), input, NewHistoricalPricer("GBP"))
+	if len(warnings) != 0 || len(events) != 1 {
+		t.Fatalf("events = %+v, warnings = %q, want the fee only", events, warnings)
+	}
+	if event := events[0]; event.Kind != TaxTransferOut || event.Asset != "BTC" || !closeTo(event.Qty, 0.0002) || event.Time != applied.UnixMilli() {
+		t.Errorf("event = %+v, want 0.0002 BTC transferred out", event)
+	}
+}
@@ -0,0 +1,296 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	UKSameDayRule = "same-day"
+	UKThirtyDay   = "30-day"
+	UKSection104  = "section-104"
+	UKUnmatched   = "unmatched"
+)
+
+var ukTimezone = loadUKTimezone()
+
+func loadUKTimezone() *time.Location {
+	location, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+type UKMatch struct {
+	Rule          string  `json:"rule"`
+	Qty           float64 `json:"qty"`
+	AllowableCost float64 `json:"allowable_cost"`
+}
+
+type UKDisposal struct {
+	TaxYear       string    `json:"tax_year"`
+	Date          string    `json:"date"`
+	Asset         string    `json:"asset"`
+	Qty           float64   `json:"qty"`
+	Proceeds      float64   `json:"proceeds"`
+	AllowableCost float64   `json:"allowable_cost"`
+	Gain          float64   `json:"gain"`
+	Matches       []UKMatch `json:"matches"`
+}
+
+type UKTaxYearReport struct {
+	TaxYear             string       `json:"tax_year"`
+	Disposals           []UKDisposal `json:"disposals"`
+	NumberOfDisposals   int          `json:"number_of_disposals"`
+	TotalProceeds       float64      `json:"total_proceeds"`
+	TotalAllowableCosts float64      `json:"total_allowable_costs"`
+	TotalGains          float64      `json:"total_gains"`
+	TotalLosses         float64      `json:"total_losses"`
+	NetGain             float64      `json:"net_gain"`
+	// Warnings are those of the whole report, set by Year
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type UKSection104Pool struct {
+	Asset string  `json:"asset"`
+	Qty   float64 `json:"qty"`
+	Cost  float64 `json:"cost"`
+}
+
+type UKCapitalGainsReport struct {
+	Years    []UKTaxYearReport  `json:"years"`
+	Pools    []UKSection104Pool `json:"pools"`
+	Warnings []string           `json:"warnings,omitempty"`
+}
+
+// UKTaxYear labels the tax year (6 April to 5 April) a UK date falls in,
+// e.g. "2024/25".
+func UKTaxYear(t time.Time) string {
+	t = t.In(ukTimezone)
+	startYear := t.Year()
+	if t.Month() < time.April || (t.Month() == time.April && t.Day() < 6) {
+		startYear--
+	}
+	return fmt.Sprintf("%d/%02d", startYear, (startYear+1)%100)
+}
+
+// ukDay is everything that happened to one asset on one UK calendar day.
+// HMRC treats all acquisitions (and all disposals) of the same day as one.
+type ukDay struct {
+	date        time.Time
+	acqQty      float64
+	acqCost     float64
+	dispQty     float64
+	dispValue   float64
+	dispFees    float64
+	transferQty float64
+	remAcqQty   float64
+	remAcqCost  float64
+	remDispQty  float64
+	matches     []UKMatch
+}
+
+func (d *ukDay) takeAcquisition(qty float64) float64 {
+	cost := d.remAcqCost * qty / d.remAcqQty
+	d.remAcqQty -= qty
+	d.remAcqCost -= cost
+	return cost
+}
+
+// CalculateUKCapitalGains applies the HMRC share matching rules per asset:
+// disposals are matched first with acquisitions on the same day, then with
+// acquisitions in the following 30 days (bed and breakfasting), and whatever
+// is left comes out of the Section 104 pool at its average cost.
+func CalculateUKCapitalGains(events []TaxEvent) UKCapitalGainsReport {
+	var report UKCapitalGainsReport
+	assetToDays := make(map[string]map[string]*ukDay)
+	for _, event := range events {
+		date := time.UnixMilli(event.Time).In(ukTimezone)
+		key := date.Format(time.DateOnly)
+		if assetToDays[event.Asset] == nil {
+			assetToDays[event.Asset] = make(map[string]*ukDay)
+		}
+		day, ok := assetToDays[event.Asset][key]
+		if !ok {
+			day = &ukDay{date: time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, ukTimezone)}
+			assetToDays[event.Asset][key] = day
+		}
+		switch event.Kind {
+		case TaxAcquisition:
+			day.acqQty += event.Qty
+			day.acqCost += event.Value + event.Fee
+		case TaxDisposal:
+			day.dispQty += event.Qty
+			day.dispValue += event.Value
+			day.dispFees += event.Fee
+		case TaxTransferOut:
+			day.transferQty += event.Qty
+		}
+	}
+
+	yearToReport := make(map[string]*UKTaxYearReport)
+	var assets []string
+	for asset := range assetToDays {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+	for _, asset := range assets {
+		var days []*ukDay
+		for _, day := range assetToDays[asset] {
+			day.remAcqQty, day.remAcqCost, day.remDispQty = day.acqQty, day.acqCost, day.dispQty
+			days = append(days, day)
+		}
+		sort.Slice(days, func(i, j int) bool {
+			return days[i].date.Before(days[j].date)
+		})
+
+		for _, day := range days {
+			if qty := math.Min(day.remAcqQty, day.remDispQty); qty > 0 {
+				day.matches = append(day.matches, UKMatch{Rule: UKSameDayRule, Qty: qty, AllowableCost: day.takeAcquisition(qty)})
+				day.remDispQty -= qty
+			}
+		}
+		for i, day := range days {
+			for j := i + 1; j < len(days) && day.remDispQty > 0; j++ {
+				later := days[j]
+				if later.date.After(day.date.AddDate(0, 0, 30)) {
+					break
+				}
+				if qty := math.Min(later.remAcqQty, day.remDispQty); qty > 0 {
+					day.matches = append(day.matches, UKMatch{Rule: UKThirtyDay, Qty: qty, AllowableCost: later.takeAcquisition(qty)})
+					day.remDispQty -= qty
+				}
+			}
+		}
+
+		pool := UKSection104Pool{Asset: asset}
+		for _, day := range days {
+			pool.Qty += day.remAcqQty
+			pool.Cost += day.remAcqCost
+			if qty := math.Min(pool.Qty, day.remDispQty); qty > 0 {
+				cost := pool.Cost * qty / pool.Qty
+				pool.Qty -= qty
+				pool.Cost -= cost
+				day.matches = append(day.matches, UKMatch{Rule: UKSection104, Qty: qty, AllowableCost: cost})
+				day.remDispQty -= qty
+			}
+			if day.remDispQty > 1e-9 {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("%s: %v disposed on %s with no acquisition on record, treated as nil cost", asset, day.remDispQty, day.date.Format(time.DateOnly)))
+				day.matches = append(day.matches, UKMatch{Rule: UKUnmatched, Qty: day.remDispQty})
+				day.remDispQty = 0
+			}
+			// transfers to our own wallets leave the pool at average cost
+			if qty := math.Min(pool.Qty, day.transferQty); qty > 0 {
+				pool.Cost -= pool.Cost * qty / pool.Qty
+				pool.Qty -= qty
+			}
+			if day.dispQty <= 0 {
+				continue
+			}
+			disposal := UKDisposal{
+				TaxYear:       UKTaxYear(day.date),
+				Date:          day.date.Format(time.DateOnly),
+				Asset:         asset,
+				Qty:           day.dispQty,
+				Proceeds:      day.dispValue,
+				AllowableCost: day.dispFees,
+				Matches:       day.matches,
+			}
+			for _, match := range day.matches {
+				disposal.AllowableCost += match.AllowableCost
+			}
+			disposal.Gain = disposal.Proceeds - disposal.AllowableCost
+			yearReport, ok := yearToReport[disposal.TaxYear]
+			if !ok {
+				yearReport = &UKTaxYearReport{TaxYear: disposal.TaxYear}
+				yearToReport[disposal.TaxYear] = yearReport
+			}
+			yearReport.Disposals = append(yearReport.Disposals, disposal)
+		}
+		if pool.Qty > 1e-9 {
+			report.Pools = append(report.Pools, pool)
+		}
+	}
+
+	for _, yearReport := range yearToReport {
+		sort.Slice(yearReport.Disposals, func(i, j int) bool {
+			if yearReport.Disposals[i].Date != yearReport.Disposals[j].Date {
+				return yearReport.Disposals[i].Date < yearReport.Disposals[j].Date
+			}
+			return yearReport.Disposals[i].Asset < yearReport.Disposals[j].Asset
+		})
+		for _, disposal := range yearReport.Disposals {
+			yearReport.NumberOfDisposals++
+			yearReport.TotalProceeds += disposal.Proceeds
+			yearReport.TotalAllowableCosts += disposal.AllowableCost
+			if disposal.Gain >= 0 {
+				yearReport.TotalGains += disposal.Gain
+			} else {
+				yearReport.TotalLosses -= disposal.Gain
+			}
+		}
+		yearReport.NetGain = yearReport.TotalGains - yearReport.TotalLosses
+		report.Years = append(report.Years, *yearReport)
+	}
+	sort.Slice(report.Years, func(i, j int) bool {
+		return report.Years[i].TaxYear < report.Years[j].TaxYear
+	})
+	return report
+}
+
+// Year returns the report of one tax year, e.g. "2024/25".
+func (r UKCapitalGainsReport) Year(taxYear string) (UKTaxYearReport, bool) {
+	for _, yearReport := range r.Years {
+		if yearReport.TaxYear == taxYear {
+			yearReport.Warnings = r.Warnings
+			return yearReport, true
+		}
+	}
+	return UKTaxYearReport{TaxYear: taxYear, Warnings: r.Warnings}, false
+}
+
+func formatGBP(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// WriteCSV writes the disposals of the tax year followed by its totals and
+// the warnings, e.g. trades that couldn't be priced and are left out.
+func (r UKTaxYearReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"Tax year", "Date", "Asset", "Quantity", "Proceeds (GBP)", "Allowable costs (GBP)", "Gain/loss (GBP)", "Same-day qty", "30-day qty", "Section 104 qty", "Unmatched qty"})
+	for _, disposal := range r.Disposals {
+		ruleToQty := make(map[string]float64)
+		for _, match := range disposal.Matches {
+			ruleToQty[match.Rule] += match.Qty
+		}
+		writer.Write([]string{
+			disposal.TaxYear,
+			disposal.Date,
+			disposal.Asset,
+			strconv.FormatFloat(disposal.Qty, 'f', -1, 64),
+			formatGBP(disposal.Proceeds),
+			formatGBP(disposal.AllowableCost),
+			formatGBP(disposal.Gain),
+			strconv.FormatFloat(ruleToQty[UKSameDayRule], 'f', -1, 64),
+			strconv.FormatFloat(ruleToQty[UKThirtyDay], 'f', -1, 64),
+			strconv.FormatFloat(ruleToQty[UKSection104], 'f', -1, 64),
+			strconv.FormatFloat(ruleToQty[UKUnmatched], 'f', -1, 64),
+		})
+	}
+	writer.Write(nil)
+	writer.Write([]string{"Number of disposals", strconv.Itoa(r.NumberOfDisposals)})
+	writer.Write([]string{"Disposal proceeds", formatGBP(r.TotalProceeds)})
+	writer.Write([]string{"Allowable costs", formatGBP(r.TotalAllowableCosts)})
+	writer.Write([]string{"Gains in the year", formatGBP(r.TotalGains)})
+	writer.Write([]string{"Losses in the year", formatGBP(r.TotalLosses)})
+	writer.Write([]string{"Net gain", formatGBP(r.NetGain)})
+	writeWarnings(writer, r.Warnings)
+	writer.Flush()
+	return writer.Error()
+}
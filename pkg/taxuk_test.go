@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ukDate is noon of a UK calendar day, in milliseconds.
+func ukDate(year int, month time.Month, day int) int64 {
+	return time.Date(year, month, day, 12, 0, 0, 0, ukTimezone).UnixMilli()
+}
+
+func closeTo(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestCalculateUKCapitalGains(t *testing.T) {
+	buy := func(time int64, qty float64, cost float64) TaxEvent {
+		return TaxEvent{Kind: TaxAcquisition, Asset: "BTC", Time: time, Qty: qty, Value: cost}
+	}
+	sell := func(time int64, qty float64, proceeds float64) TaxEvent {
+		return TaxEvent{Kind: TaxDisposal, Asset: "BTC", Time: time, Qty: qty, Value: proceeds}
+	}
+	tests := []struct {
+		name        string
+		events      []TaxEvent
+		wantMatches []UKMatch
+		wantGain    float64
+		wantPool    UKSection104Pool
+		wantWarning bool
+	}{
+		{
+			name: "same day acquisition matched before the pool",
+			events: []TaxEvent{
+				buy(ukDate(2023, time.January, 10), 10, 1000),
+				buy(ukDate(2023, time.June, 1), 5, 600),
+				sell(ukDate(2023, time.June, 1), 5, 700),
+			},
+			wantMatches: []UKMatch{{Rule: UKSameDayRule, Qty: 5, AllowableCost: 600}},
+			wantGain:    100,
+			wantPool:    UKSection104Pool{Asset: "BTC", Qty: 10, Cost: 1000},
+		},
+		{
+			name: "bed and breakfast within 30 days",
+			events: []TaxEvent{
+				buy(ukDate(2023, time.January, 10), 10, 1000),
+				sell(ukDate(2023, time.June, 1), 10, 2000),
+				buy(ukDate(2023, time.July, 1), 10, 1500),
+			},
+			wantMatches: []UKMatch{{Rule: UKThirtyDay, Qty: 10, AllowableCost: 1500}},
+			wantGain:    500,
+			wantPool:    UKSection104Pool{Asset: "BTC", Qty: 10, Cost: 1000},
+		},
+		{
+			name: "acquisition 31 days later goes to the pool",
+			events: []TaxEvent{
+				buy(ukDate(2023, time.January, 10), 10, 1000),
+				sell(ukDate(2023, time.June, 1), 10, 2000),
+				buy(ukDate(2023, time.July, 2), 10, 1500),
+			},
+			wantMatches: []UKMatch{{Rule: UKSection104, Qty: 10, AllowableCost: 1000}},
+			wantGain:    1000,
+			wantPool:    UKSection104Pool{Asset: "BTC", Qty: 10, Cost: 1500},
+		},
+		{
+			name: "section 104 pool at average cost",
+			events: []TaxEvent{
+				buy(ukDate(2022, time.March, 1), 10, 1000),
+				buy(ukDate(2022, time.September, 1), 10, 3000),
+				sell(ukDate(2023, time.June, 1), 5, 1500),
+			},
+			wantMatches: []UKMatch{{Rule: UKSection104, Qty: 5, AllowableCost: 1000}},
+			wantGain:    500,
+			wantPool:    UKSection104Pool{Asset: "BTC", Qty: 15, Cost: 3000},
+		},
+		{
+			name: "same day, then 30 days, then the pool",
+			events: []TaxEvent{
+				buy(ukDate(2022, time.March, 1), 10, 1000),
+				buy(ukDate(2023, time.June, 1), 2, 400),
+				sell(ukDate(2023, time.June, 1), 10, 3000),
+				buy(ukDate(2023, time.June, 20), 3, 750),
+			},
+			wantMatches: []UKMatch{
+				{Rule: UKSameDayRule, Qty: 2, AllowableCost: 400},
+				{Rule: UKThirtyDay, Qty: 3, AllowableCost: 750},
+				{Rule: UKSection104, Qty: 5, AllowableCost: 500},
+			},
+			wantGain: 1350,
+			wantPool: UKSection104Pool{Asset: "BTC", Qty: 5, Cost: 500},
+		},
+		{
+			name: "disposal with no acquisition has nil cost",
+			events: []TaxEvent{
+				sell(ukDate(2023, time.June, 1), 1, 300),
+			},
+			wantMatches: []UKMatch{{Rule: UKUnmatched, Qty: 1}},
+			wantGain:    300,
+			wantWarning: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := CalculateUKCapitalGains(test.events)
+			if len(report.Years) != 1 || len(report.Years[0].Disposals) != 1 {
+				t.Fatalf("want one disposal in one tax year, got %+v", report.Years)
+			}
+			disposal := report.Years[0].Disposals[0]
+			if len(disposal.Matches) != len(test.wantMatches) {
+				t.Fatalf("matches = %+v, want %+v", disposal.Matches, test.wantMatches)
+			}
+			for i, match := range disposal.Matches {
+				want := test.wantMatches[i]
+				if match.Rule != want.Rule || !closeTo(match.Qty, want.Qty) || !closeTo(match.AllowableCost, want.AllowableCost) {
+					t.Errorf("match %d = %+v, want %+v", i, match, want)
+				}
+			}
+			if !closeTo(disposal.Gain, test.wantGain) {
+				t.Errorf("gain = %v, want %v", disposal.Gain, test.wantGain)
+			}
+			var pool UKSection104Pool
+			if len(report.Pools) > 0 {
+				pool = report.Pools[0]
+			}
+			if pool.Asset != test.wantPool.Asset || !closeTo(pool.Qty, test.wantPool.Qty) || !closeTo(pool.Cost, test.wantPool.Cost) {
+				t.Errorf("pool = %+v, want %+v", pool, test.wantPool)
+			}
+			if got := len(report.Warnings) > 0; got != test.wantWarning {
+				t.Errorf("warnings = %q, want some: %v", report.Warnings, test.wantWarning)
+			}
+		})
+	}
+}
+
+func TestUKTaxYear(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		{time.Date(2024, time.April, 5, 23, 30, 0, 0, ukTimezone), "2023/24"},
+		{time.Date(2024, time.April, 6, 0, 30, 0, 0, ukTimezone), "2024/25"},
+		// 23:30 UTC on 5 April is already 6 April in London (BST)
+		{time.Date(2024, time.April, 5, 23, 30, 0, 0, time.UTC), "2024/25"},
+		{time.Date(2000, time.January, 1, 0, 0, 0, 0, ukTimezone), "1999/00"},
+	}
+	for _, test := range tests {
+		if got := UKTaxYear(test.date); got != test.want {
+			t.Errorf("UKTaxYear(%s) = %s, want %s", test.date, got, test.want)
+		}
+	}
+}
+
+func TestUKTaxYearReportCSVWarnings(t *testing.T) {
+	report := CalculateUKCapitalGains([]TaxEvent{
+		{Kind: TaxDisposal, Asset: "BTC", Time: ukDate(2023, time.June, 1), Qty: 1, Value: 300},
+	})
+	report.Warnings = append(report.Warnings, "ETHBTC: no GBP price for trade 7")
+	if _, ok := report.Year("2021/22"); ok {
+		t.Error("Year(2021/22) found a year without disposals")
+	}
+	yearReport, ok := report.Year("2023/24")
+	if !ok {
+		t.Fatal("Year(2023/24) not found")
+	}
+	var csv strings.Builder
+	if err := yearReport.WriteCSV(&csv); err != nil {
+		t.Fatal(err)
+	}
+	for _, warning := range report.Warnings {
+		if !strings.Contains(csv.String(), warning) {
+			t.Errorf("CSV is missing the warning %q:\n%s", warning, csv.String())
+		}
+	}
+}
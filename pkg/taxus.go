@@ -0,0 +1,303 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LotMethod string
+
+const (
+	LotFIFO LotMethod = "FIFO"
+	LotLIFO LotMethod = "LIFO"
+	LotHIFO LotMethod = "HIFO"
+)
+
+func ParseLotMethod(value string) (LotMethod, error) {
+	switch method := LotMethod(strings.ToUpper(strings.TrimSpace(value))); method {
+	case "":
+		return LotFIFO, nil
+	case LotFIFO, LotLIFO, LotHIFO:
+		return method, nil
+	}
+	return "", fmt.Errorf("unknown lot method %q, use FIFO, LIFO or HIFO", value)
+}
+
+type taxLot struct {
+	acquiredAt  int64
+	qty         float64
+	costPerUnit float64
+}
+
+// LotDisposal is one line of Form 8949: part of a disposal matched with one
+// acquisition lot.
+type LotDisposal struct {
+	Asset      string  `json:"asset"`
+	Qty        float64 `json:"qty"`
+	AcquiredAt int64   `json:"acquired_at"`
+	DisposedAt int64   `json:"disposed_at"`
+	Proceeds   float64 `json:"proceeds"`
+	CostBasis  float64 `json:"cost_basis"`
+	Gain       float64 `json:"gain"`
+	LongTerm   bool    `json:"long_term"`
+	Unmatched  bool    `json:"unmatched"`
+}
+
+type ScheduleDTotals struct {
+	Proceeds  float64 `json:"proceeds"`
+	CostBasis float64 `json:"cost_basis"`
+	Gain      float64 `json:"gain"`
+}
+
+type USTaxYearReport struct {
+	TaxYear   int             `json:"tax_year"`
+	Method    LotMethod       `json:"method"`
+	Disposals []LotDisposal   `json:"disposals"`
+	ShortTerm ScheduleDTotals `json:"short_term"`
+	LongTerm  ScheduleDTotals `json:"long_term"`
+	// Warnings are those of the whole report, set by Year
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type USCapitalGainsReport struct {
+	Method   LotMethod         `json:"method"`
+	Years    []USTaxYearReport `json:"years"`
+	Warnings []string          `json:"warnings,omitempty"`
+}
+
+// isLongTerm applies the one-year holding rule. The holding period starts the
+// day after acquisition, so a disposal is long-term from the day after the
+// first anniversary onwards. The anniversary of 29 February is 28 February.
+func isLongTerm(acquiredAt int64, disposedAt int64) bool {
+	acquired := time.UnixMilli(acquiredAt).UTC()
+	disposed := time.UnixMilli(disposedAt).UTC()
+	anniversary := time.Date(acquired.Year()+1, acquired.Month(), acquired.Day(), 0, 0, 0, 0, time.UTC)
+	if anniversary.Month() != acquired.Month() {
+		anniversary = anniversary.AddDate(0, 0, -anniversary.Day())
+	}
+	return !disposed.Before(anniversary.AddDate(0, 0, 1))
+}
+
+// pickLot returns the index of the next lot to consume under method.
+func pickLot(lots []*taxLot, method LotMethod) int {
+	switch method {
+	case LotLIFO:
+		return len(lots) - 1
+	case LotHIFO:
+		highest := 0
+		for i, lot := range lots {
+			if lot.costPerUnit > lots[highest].costPerUnit {
+				highest = i
+			}
+		}
+		return highest
+	}
+	return 0
+}
+
+// MatchLots replays the events per asset, consuming acquisition lots with the
+// chosen method on every disposal. A transfer out consumes the oldest lots
+// whatever the method, so that moving coins to one's own wallet doesn't pick
+// the lots left for later disposals. Each consumed lot is a LotDisposal with
+// a pro rata share of the proceeds net of the disposal fee.
+func MatchLots(events []TaxEvent, method LotMethod) ([]LotDisposal, []string) {
+	var disposals []LotDisposal
+	var warnings []string
+	assetToLots := make(map[string][]*taxLot)
+	for _, event := range events {
+		switch event.Kind {
+		case TaxAcquisition:
+			if event.Qty <= 0 {
+				continue
+			}
+			assetToLots[event.Asset] = append(assetToLots[event.Asset], &taxLot{
+				acquiredAt:  event.Time,
+				qty:         event.Qty,
+				costPerUnit: (event.Value + event.Fee) / event.Qty,
+			})
+		case TaxDisposal, TaxTransferOut:
+			if event.Qty <= 0 {
+				continue
+			}
+			netProceeds := event.Value - event.Fee
+			remaining := event.Qty
+			lots := assetToLots[event.Asset]
+			lotMethod := method
+			if event.Kind == TaxTransferOut {
+				lotMethod = LotFIFO
+			}
+			for remaining > 1e-12 && len(lots) > 0 {
+				index := pickLot(lots, lotMethod)
+				lot := lots[index]
+				qty := math.Min(lot.qty, remaining)
+				lot.qty -= qty
+				remaining -= qty
+				if lot.qty <= 1e-12 {
+					lots = append(lots[:index], lots[index+1:]...)
+				}
+				if event.Kind == TaxTransferOut {
+					continue
+				}
+				disposal := LotDisposal{
+					Asset:      event.Asset,
+					Qty:        qty,
+					AcquiredAt: lot.acquiredAt,
+					DisposedAt: event.Time,
+					Proceeds:   netProceeds * qty / event.Qty,
+					CostBasis:  lot.costPerUnit * qty,
+					LongTerm:   isLongTerm(lot.acquiredAt, event.Time),
+				}
+				disposal.Gain = disposal.Proceeds - disposal.CostBasis
+				disposals = append(disposals, disposal)
+			}
+			assetToLots[event.Asset] = lots
+			if remaining > 1e-9 && event.Kind == TaxDisposal {
+				warnings = append(warnings, fmt.Sprintf("%s: %v disposed on %s with no lot on record, reported with zero basis", event.Asset, remaining, time.UnixMilli(event.Time).UTC().Format(time.DateOnly)))
+				disposal := LotDisposal{
+					Asset:      event.Asset,
+					Qty:        remaining,
+					DisposedAt: event.Time,
+					Proceeds:   netProceeds * remaining / event.Qty,
+					Unmatched:  true,
+				}
+				disposal.Gain = disposal.Proceeds
+				disposals = append(disposals, disposal)
+			}
+		}
+	}
+	return disposals, warnings
+}
+
+// CalculateUSCapitalGains groups the lot disposals by calendar tax year with
+// the short- and long-term totals that go on Schedule D.
+func CalculateUSCapitalGains(events []TaxEvent, method LotMethod) USCapitalGainsReport {
+	report := USCapitalGainsReport{Method: method}
+	disposals, warnings := MatchLots(events, method)
+	report.Warnings = warnings
+	yearToReport := make(map[int]*USTaxYearReport)
+	for _, disposal := range disposals {
+		year := time.UnixMilli(disposal.DisposedAt).UTC().Year()
+		yearReport, ok := yearToReport[year]
+		if !ok {
+			yearReport = &USTaxYearReport{TaxYear: year, Method: method}
+			yearToReport[year] = yearReport
+		}
+		yearReport.Disposals = append(yearReport.Disposals, disposal)
+		totals := &yearReport.ShortTerm
+		if disposal.LongTerm {
+			totals = &yearReport.LongTerm
+		}
+		totals.Proceeds += disposal.Proceeds
+		totals.CostBasis += disposal.CostBasis
+		totals.Gain += disposal.Gain
+	}
+	for _, yearReport := range yearToReport {
+		report.Years = append(report.Years, *yearReport)
+	}
+	sort.Slice(report.Years, func(i, j int) bool {
+		return report.Years[i].TaxYear < report.Years[j].TaxYear
+	})
+	return report
+}
+
+func (r USCapitalGainsReport) Year(taxYear int) (USTaxYearReport, bool) {
+	for _, yearReport := range r.Years {
+		if yearReport.TaxYear == taxYear {
+			yearReport.Warnings = r.Warnings
+			return yearReport, true
+		}
+	}
+	return USTaxYearReport{TaxYear: taxYear, Method: r.Method, Warnings: r.Warnings}, false
+}
+
+// digitalAssetBoxesYear is the first tax year whose Form 8949 has boxes for
+// digital assets, with a 1099-DA taking the place of the 1099-B.
+const digitalAssetBoxesYear = 2025
+
+// form8949Boxes are the boxes of digital assets not reported on a 1099-B,
+// C and F, or from 2025 on a 1099-DA, I and L.
+func form8949Boxes(taxYear int) (shortTerm string, longTerm string) {
+	if taxYear >= digitalAssetBoxesYear {
+		return "I", "L"
+	}
+	return "C", "F"
+}
+
+// writeWarnings ends a CSV with the warnings of the report, e.g. trades that
+// couldn't be priced and are left out.
+func writeWarnings(writer *csv.Writer, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	writer.Write(nil)
+	writer.Write([]string{"Warnings"})
+	for _, warning := range warnings {
+		writer.Write([]string{warning})
+	}
+}
+
+func formatUSD(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', 2, 64)
+}
+
+func formatUSDate(ts int64) string {
+	return time.UnixMilli(ts).UTC().Format("01/02/2006")
+}
+
+// Write8949CSV writes one row per lot disposal in Form 8949 column order,
+// in the boxes of the tax year, see form8949Boxes.
+func (r USTaxYearReport) Write8949CSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	shortTermBox, longTermBox := form8949Boxes(r.TaxYear)
+	writer.Write([]string{"Description of property (a)", "Date acquired (b)", "Date sold or disposed of (c)", "Proceeds (d)", "Cost or other basis (e)", "Code(s) (f)", "Amount of adjustment (g)", "Gain or (loss) (h)", "Term", "Box"})
+	for _, disposal := range r.Disposals {
+		acquired := "VARIOUS"
+		if !disposal.Unmatched {
+			acquired = formatUSDate(disposal.AcquiredAt)
+		}
+		term, box := "Short-term", shortTermBox
+		if disposal.LongTerm {
+			term, box = "Long-term", longTermBox
+		}
+		writer.Write([]string{
+			fmt.Sprintf("%s %s", strconv.FormatFloat(disposal.Qty, 'f', -1, 64), disposal.Asset),
+			acquired,
+			formatUSDate(disposal.DisposedAt),
+			formatUSD(disposal.Proceeds),
+			formatUSD(disposal.CostBasis),
+			"",
+			"",
+			formatUSD(disposal.Gain),
+			term,
+			box,
+		})
+	}
+	writeWarnings(writer, r.Warnings)
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteScheduleDCSV writes the Schedule D summary lines the 8949 totals flow
+// into: line 3 (short-term, box C or I) and line 10 (long-term, box F or L).
+func (r USTaxYearReport) WriteScheduleDCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"Tax year", "Lot method", "Line", "Description", "Proceeds (d)", "Cost or other basis (e)", "Adjustments (g)", "Gain or (loss) (h)"})
+	year := strconv.Itoa(r.TaxYear)
+	shortTermBox, longTermBox := form8949Boxes(r.TaxYear)
+	form := "1099-B"
+	if r.TaxYear >= digitalAssetBoxesYear {
+		form = "1099-DA"
+	}
+	writer.Write([]string{year, string(r.Method), "3", fmt.Sprintf("Short-term transactions not reported on Form %s (box %s)", form, shortTermBox), formatUSD(r.ShortTerm.Proceeds), formatUSD(r.ShortTerm.CostBasis), "0.00", formatUSD(r.ShortTerm.Gain)})
+	writer.Write([]string{year, string(r.Method), "10", fmt.Sprintf("Long-term transactions not reported on Form %s (box %s)", form, longTermBox), formatUSD(r.LongTerm.Proceeds), formatUSD(r.LongTerm.CostBasis), "0.00", formatUSD(r.LongTerm.Gain)})
+	writer.Write([]string{year, string(r.Method), "16", "Net capital gain or (loss)", formatUSD(r.ShortTerm.Proceeds + r.LongTerm.Proceeds), formatUSD(r.ShortTerm.CostBasis + r.LongTerm.CostBasis), "0.00", formatUSD(r.ShortTerm.Gain + r.LongTerm.Gain)})
+	writeWarnings(writer, r.Warnings)
+	writer.Flush()
+	return writer.Error()
+}
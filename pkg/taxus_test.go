@@ -0,0 +1,190 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func utcDate(year int, month time.Month, day int) int64 {
+	return time.Date(year, month, day, 12, 0, 0, 0, time.UTC).UnixMilli()
+}
+
+func TestIsLongTerm(t *testing.T) {
+	tests := []struct {
+		name       string
+		acquiredAt int64
+		disposedAt int64
+		want       bool
+	}{
+		{"on the anniversary", utcDate(2023, time.March, 15), utcDate(2024, time.March, 15), false},
+		{"the day after the anniversary", utcDate(2023, time.March, 15), utcDate(2024, time.March, 16), true},
+		{"well within a year", utcDate(2023, time.March, 15), utcDate(2023, time.December, 31), false},
+		{"across 29 February", utcDate(2023, time.March, 1), utcDate(2024, time.March, 1), false},
+		{"across 29 February, a day later", utcDate(2023, time.March, 1), utcDate(2024, time.March, 2), true},
+		{"bought 29 February, sold 28 February", utcDate(2024, time.February, 29), utcDate(2025, time.February, 28), false},
+		{"bought 29 February, sold 1 March", utcDate(2024, time.February, 29), utcDate(2025, time.March, 1), true},
+		{"bought 28 February before a leap day", utcDate(2023, time.February, 28), utcDate(2024, time.February, 29), true},
+	}
+	for _, test := range tests {
+		if got := isLongTerm(test.acquiredAt, test.disposedAt); got != test.want {
+			t.Errorf("%s: isLongTerm = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatchLots(t *testing.T) {
+	buy := func(time int64, qty float64, cost float64) TaxEvent {
+		return TaxEvent{Kind: TaxAcquisition, Asset: "BTC", Time: time, Qty: qty, Value: cost}
+	}
+	sell := func(time int64, qty float64, proceeds float64) TaxEvent {
+		return TaxEvent{Kind: TaxDisposal, Asset: "BTC", Time: time, Qty: qty, Value: proceeds}
+	}
+	transfer := func(time int64, qty float64) TaxEvent {
+		return TaxEvent{Kind: TaxTransferOut, Asset: "BTC", Time: time, Qty: qty}
+	}
+	lots := []TaxEvent{
+		buy(utcDate(2022, time.January, 10), 1, 100),
+		buy(utcDate(2022, time.June, 10), 1, 300),
+		buy(utcDate(2023, time.January, 10), 1, 200),
+	}
+	tests := []struct {
+		name   string
+		events []TaxEvent
+		method LotMethod
+		want   []LotDisposal
+	}{
+		{
+			name:   "FIFO takes the oldest lot",
+			events: append(lots[:3:3], sell(utcDate(2023, time.March, 1), 1, 500)),
+			method: LotFIFO,
+			want:   []LotDisposal{{Qty: 1, AcquiredAt: utcDate(2022, time.January, 10), Proceeds: 500, CostBasis: 100, Gain: 400, LongTerm: true}},
+		},
+		{
+			name:   "LIFO takes the newest lot",
+			events: append(lots[:3:3], sell(utcDate(2023, time.March, 1), 1, 500)),
+			method: LotLIFO,
+			want:   []LotDisposal{{Qty: 1, AcquiredAt: utcDate(2023, time.January, 10), Proceeds: 500, CostBasis: 200, Gain: 300}},
+		},
+		{
+			name:   "HIFO takes the most expensive lot",
+			events: append(lots[:3:3], sell(utcDate(2023, time.March, 1), 1, 500)),
+			method: LotHIFO,
+			want:   []LotDisposal{{Qty: 1, AcquiredAt: utcDate(2022, time.June, 10), Proceeds: 500, CostBasis: 300, Gain: 200}},
+		},
+		{
+			name:   "a disposal across lots shares the proceeds",
+			events: append(lots[:3:3], sell(utcDate(2023, time.March, 1), 1.5, 600)),
+			method: LotFIFO,
+			want: []LotDisposal{
+				{Qty: 1, AcquiredAt: utcDate(2022, time.January, 10), Proceeds: 400, CostBasis: 100, Gain: 300, LongTerm: true},
+				{Qty: 0.5, AcquiredAt: utcDate(2022, time.June, 10), Proceeds: 200, CostBasis: 150, Gain: 50},
+			},
+		},
+		{
+			name:   "a transfer out takes the oldest lot under HIFO",
+			events: append(lots[:3:3], transfer(utcDate(2023, time.February, 1), 1), sell(utcDate(2023, time.March, 1), 2, 1000)),
+			method: LotHIFO,
+			want: []LotDisposal{
+				{Qty: 1, AcquiredAt: utcDate(2022, time.June, 10), Proceeds: 500, CostBasis: 300, Gain: 200},
+				{Qty: 1, AcquiredAt: utcDate(2023, time.January, 10), Proceeds: 500, CostBasis: 200, Gain: 300},
+			},
+		},
+		{
+			name:   "fees add to the basis and come off the proceeds",
+			events: []TaxEvent{{Kind: TaxAcquisition, Asset: "BTC", Time: utcDate(2022, time.January, 10), Qty: 1, Value: 100, Fee: 1}, {Kind: TaxDisposal, Asset: "BTC", Time: utcDate(2022, time.March, 1), Qty: 1, Value: 150, Fee: 2}},
+			method: LotFIFO,
+			want:   []LotDisposal{{Qty: 1, AcquiredAt: utcDate(2022, time.January, 10), Proceeds: 148, CostBasis: 101, Gain: 47}},
+		},
+		{
+			name:   "the part without a lot has zero basis",
+			events: []TaxEvent{buy(utcDate(2022, time.January, 10), 1, 100), sell(utcDate(2022, time.March, 1), 3, 900)},
+			method: LotFIFO,
+			want: []LotDisposal{
+				{Qty: 1, AcquiredAt: utcDate(2022, time.January, 10), Proceeds: 300, CostBasis: 100, Gain: 200},
+				{Qty: 2, Proceeds: 600, Gain: 600, Unmatched: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			disposals, warnings := MatchLots(test.events, test.method)
+			if len(disposals) != len(test.want) {
+				t.Fatalf("disposals = %+v, want %+v", disposals, test.want)
+			}
+			unmatched := false
+			for i, got := range disposals {
+				want := test.want[i]
+				unmatched = unmatched || want.Unmatched
+				if got.Asset != "BTC" || got.DisposedAt != test.events[len(test.events)-1].Time || got.AcquiredAt != want.AcquiredAt ||
+					got.LongTerm != want.LongTerm || got.Unmatched != want.Unmatched ||
+					!closeTo(got.Qty, want.Qty) || !closeTo(got.Proceeds, want.Proceeds) || !closeTo(got.CostBasis, want.CostBasis) || !closeTo(got.Gain, want.Gain) {
+					t.Errorf("disposal %d = %+v, want %+v", i, got, want)
+				}
+			}
+			if got := len(warnings) > 0; got != unmatched {
+				t.Errorf("warnings = %q, want some: %v", warnings, unmatched)
+			}
+		})
+	}
+}
+
+func TestCalculateUSCapitalGains(t *testing.T) {
+	events := []TaxEvent{
+		{Kind: TaxAcquisition, Asset: "ETH", Time: utcDate(2022, time.January, 10), Qty: 2, Value: 2000},
+		{Kind: TaxDisposal, Asset: "ETH", Time: utcDate(2022, time.November, 1), Qty: 1, Value: 1500},
+		{Kind: TaxDisposal, Asset: "ETH", Time: utcDate(2023, time.February, 1), Qty: 1, Value: 800},
+	}
+	report := CalculateUSCapitalGains(events, LotFIFO)
+	if len(report.Years) != 2 {
+		t.Fatalf("years = %+v, want 2022 and 2023", report.Years)
+	}
+	year2022, _ := report.Year(2022)
+	if !closeTo(year2022.ShortTerm.Gain, 500) || year2022.LongTerm != (ScheduleDTotals{}) {
+		t.Errorf("2022 = %+v, want a short-term gain of 500", year2022)
+	}
+	year2023, _ := report.Year(2023)
+	if !closeTo(year2023.LongTerm.Gain, -200) || !closeTo(year2023.LongTerm.Proceeds, 800) || year2023.ShortTerm != (ScheduleDTotals{}) {
+		t.Errorf("2023 = %+v, want a long-term loss of 200", year2023)
+	}
+}
+
+func TestWrite8949CSVBoxes(t *testing.T) {
+	events := []TaxEvent{
+		{Kind: TaxAcquisition, Asset: "ETH", Time: utcDate(2022, time.January, 10), Qty: 3, Value: 3000},
+		{Kind: TaxDisposal, Asset: "ETH", Time: utcDate(2024, time.March, 1), Qty: 1, Value: 1500},
+		{Kind: TaxDisposal, Asset: "ETH", Time: utcDate(2025, time.March, 1), Qty: 1, Value: 2500},
+	}
+	report := CalculateUSCapitalGains(events, LotFIFO)
+	report.Warnings = []string{"BTCUSDT: no USD price for trade 7"}
+	if _, ok := report.Year(2023); ok {
+		t.Error("Year(2023) found a year without disposals")
+	}
+	tests := []struct {
+		year      int
+		box       string
+		scheduleD string
+	}{
+		{2024, "Long-term,F", "Form 1099-B (box F)"},
+		{2025, "Long-term,L", "Form 1099-DA (box L)"},
+	}
+	for _, test := range tests {
+		yearReport, ok := report.Year(test.year)
+		if !ok {
+			t.Fatalf("Year(%d) not found", test.year)
+		}
+		var form8949, scheduleD strings.Builder
+		if err := yearReport.Write8949CSV(&form8949); err != nil {
+			t.Fatal(err)
+		}
+		if err := yearReport.WriteScheduleDCSV(&scheduleD); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(form8949.String(), test.box) || !strings.Contains(form8949.String(), report.Warnings[0]) {
+			t.Errorf("%d Form 8949 wants %s and the warning:\n%s", test.year, test.box, form8949.String())
+		}
+		if !strings.Contains(scheduleD.String(), test.scheduleD) || !strings.Contains(scheduleD.String(), report.Warnings[0]) {
+			t.Errorf("%d Schedule D wants %s and the warning:\n%s", test.year, test.scheduleD, scheduleD.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrCodeTimestamp is the Binance error for a timestamp outside recvWindow.
+const ErrCodeTimestamp = -1021
+
+// serverClock tracks how far Binance's clock is from the local one, so
+// signed requests carry the server's time even on a drifting laptop.
+type serverClock struct {
+	mu       sync.Mutex
+	offset   time.Duration
+	syncedAt time.Time
+}
+
+var binanceClock serverClock
+
+// SyncServerTime measures the offset to /api/v3/time, taking the server time
+// as stamped half way through the round trip, and returns it.
+func SyncServerTime(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	resp, err := binanceClient.Get(ctx, binanceBaseURL+"/time")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	end := time.Now()
+	var result struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != 200 || result.ServerTime == 0 {
+		return 0, fmt.Errorf("server time: status %d", resp.StatusCode)
+	}
+	midpoint := start.Add(end.Sub(start) / 2)
+	offset := time.UnixMilli(result.ServerTime).Sub(midpoint)
+
+	binanceClock.mu.Lock()
+	defer binanceClock.mu.Unlock()
+	binanceClock.offset, binanceClock.syncedAt = offset, end
+	log.WithFields(log.Fields{"offset_ms": offset.Milliseconds(), "rtt_ms": end.Sub(start).Milliseconds()}).Debug("server time synced")
+	return offset, nil
+}
+
+// serverNow is the local time corrected by the offset to Binance, resyncing
+// first when the last sync is older than binance.time_sync. A failed sync
+// keeps the previous offset until the next interval.
+func serverNow(ctx context.Context) time.Time {
+	binanceClock.mu.Lock()
+	due := config.BinanceTimeSync > 0 && time.Since(binanceClock.syncedAt) >= config.BinanceTimeSync
+	if due {
+		// claim this round so concurrent requests don't all sync
+		binanceClock.syncedAt = time.Now()
+	}
+	binanceClock.mu.Unlock()
+	if due {
+		if _, err := SyncServerTime(ctx); err != nil {
+			log.Warnf("[serverNow]: syncing with the Binance server time - %v", err)
+		}
+	}
+	return binanceClock.now()
+}
+
+// now is the local time corrected by the last known offset, without syncing.
+func (c *serverClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+// getTs is the timestamp of a signed request, in milliseconds of server time.
+func getTs(ctx context.Context) string {
+	return strconv.FormatInt(serverNow(ctx).UnixMilli(), 10)
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startClockServer serves /api/v3/time and a signed /api/v3/account from a
+// clock ahead of the local one by skew, refusing timestamps outside the
+// recvWindow with -1021 like Binance, and points the client at it.
+func startClockServer(t *testing.T, skew time.Duration, timeSync time.Duration) (timeCalls *atomic.Int32, accountCalls *atomic.Int32) {
+	t.Helper()
+	timeCalls, accountCalls = new(atomic.Int32), new(atomic.Int32)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/time", func(w http.ResponseWriter, r *http.Request) {
+		timeCalls.Add(1)
+		fmt.Fprintf(w, `{"serverTime":%d}`, time.Now().Add(skew).UnixMilli())
+	})
+	mux.HandleFunc("/api/v3/account", func(w http.ResponseWriter, r *http.Request) {
+		accountCalls.Add(1)
+		timestamp, _ := strconv.ParseInt(r.URL.Query().Get("timestamp"), 10, 64)
+		recvWindow, _ := strconv.ParseInt(r.URL.Query().Get("recvWindow"), 10, 64)
+		if d := time.Now().Add(skew).UnixMilli() - timestamp; d > recvWindow || d < -1000 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":-1021,"msg":"Timestamp for this request is outside of the recvWindow."}`)
+			return
+		}
+		fmt.Fprint(w, `{"canTrade":true,"balances":[]}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	testConfig := DefaultConfig()
+	testConfig.BinanceHost = server.URL
+	testConfig.BinanceTimeSync = timeSync
+	SetConfig(testConfig)
+	resetClock := func() {
+		binanceClock.mu.Lock()
+		binanceClock.offset, binanceClock.syncedAt = 0, time.Time{}
+		binanceClock.mu.Unlock()
+	}
+	resetClock()
+	t.Cleanup(func() {
+		resetClock()
+		SetConfig(DefaultConfig())
+	})
+	return timeCalls, accountCalls
+}
+
+func TestSignedRequestRetriesWithServerTime(t *testing.T) {
+	skew := 10 * time.Second
+	timeCalls, accountCalls := startClockServer(t, skew, 0)
+	account := Account{Name: MainAccount, APIKey: "key", SecretKey: "secret"}
+	if _, err := GetAccountInfoFor(context.Background(), account); err != nil {
+		t.Fatalf("GetAccountInfoFor: %v", err)
+	}
+	if timeCalls.Load() != 1 || accountCalls.Load() != 2 {
+		t.Errorf("%d time and %d account calls, want a sync and a retry after -1021", timeCalls.Load(), accountCalls.Load())
+	}
+	if offset := binanceClock.now().Sub(time.Now()); offset < skew-time.Second || offset > skew+time.Second {
+		t.Errorf("offset %v after the sync, want about %v", offset, skew)
+	}
+
+	// the next request uses the offset at once
+	if _, err := GetAccountInfoFor(context.Background(), account); err != nil {
+		t.Fatalf("GetAccountInfoFor: %v", err)
+	}
+	if timeCalls.Load() != 1 || accountCalls.Load() != 3 {
+		t.Errorf("%d time and %d account calls, want no more syncs or retries", timeCalls.Load(), accountCalls.Load())
+	}
+}
+
+func TestSignedRequestResyncsAStaleOffset(t *testing.T) {
+	// an offset measured before the server's clock was corrected
+	_, accountCalls := startClockServer(t, 0, 0)
+	binanceClock.mu.Lock()
+	binanceClock.offset = -time.Minute
+	binanceClock.mu.Unlock()
+	account := Account{Name: MainAccount, APIKey: "key", SecretKey: "secret"}
+	if _, err := GetAccountInfoFor(context.Background(), account); err != nil {
+		t.Fatalf("GetAccountInfoFor: %v", err)
+	}
+	if accountCalls.Load() != 2 {
+		t.Errorf("%d account calls, want one retry", accountCalls.Load())
+	}
+}
+
+func TestServerNowSyncsWhenDue(t *testing.T) {
+	skew := -30 * time.Second
+	timeCalls, _ := startClockServer(t, skew, time.Hour)
+	for range 3 {
+		if offset := serverNow(context.Background()).Sub(time.Now()); offset < skew-time.Second || offset > skew+time.Second {
+			t.Errorf("serverNow is %v off the local clock, want about %v", offset, skew)
+		}
+	}
+	if timeCalls.Load() != 1 {
+		t.Errorf("%d time calls, want one per binance.time_sync", timeCalls.Load())
+	}
+}
@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// fetchAll calls fetch for every item on at most workers goroutines, each
+// call bounded by timeout, and returns the results and errors in the order
+// of items. Items not started by the time ctx is done fail with its error.
+func fetchAll[T any, R any](ctx context.Context, items []T, workers int, timeout time.Duration, fetch func(ctx context.Context, item T) (R, error)) ([]R, []error) {
+	results := make([]R, len(items))
+	errs := make([]error, len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(workers, 1), len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				callCtx, cancel := context.WithTimeout(ctx, timeout)
+				results[i], errs[i] = fetch(callCtx, items[i])
+				cancel()
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results, errs
+}
@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// WSAPIError is the error of a WebSocket API response.
+type WSAPIError struct {
+	Status int
+	Code   int    `json:"code"`
+	Msg    string `json:"msg"`
+}
+
+func (e WSAPIError) Error() string {
+	return fmt.Sprintf("binance ws-api error %d (status %d): %s", e.Code, e.Status, e.Msg)
+}
+
+// WSAPIConn is a connection to the Binance WebSocket API. Requests are sent
+// one at a time; after Logon the signed methods need neither the API key nor
+// a signature.
+type WSAPIConn struct {
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	nextID int
+}
+
+// DialWSAPI connects to the WebSocket API at binance.ws_api_url.
+func DialWSAPI(ctx context.Context) (*WSAPIConn, error) {
+	wsConfig, err := websocket.NewConfig(config.BinanceWSAPIURL, "http://localhost/")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &WSAPIConn{conn: conn}, nil
+}
+
+func (c *WSAPIConn) Close() error {
+	return c.conn.Close()
+}
+
+// Call sends the request method with params and returns its result. When
+// ctx ends first the call fails and the connection may be unusable.
+func (c *WSAPIConn) Call(ctx context.Context, method string, params map[string]interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// unblock the send or receive in progress when ctx ends
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Now())
+	})
+	defer func() {
+		if stop() {
+			c.conn.SetDeadline(time.Time{})
+		}
+	}()
+	start := time.Now()
+	c.nextID++
+	id := strconv.Itoa(c.nextID)
+	request := map[string]interface{}{"id": id, "method": method}
+	if len(params) > 0 {
+		request["params"] = params
+	}
+	if err := websocket.JSON.Send(c.conn, request); err != nil {
+		return nil, err
+	}
+	for {
+		var response struct {
+			ID     string          `json:"id"`
+			Status int             `json:"status"`
+			Result json.RawMessage `json:"result"`
+			Error  *WSAPIError     `json:"error"`
+		}
+		if err := websocket.JSON.Receive(c.conn, &response); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		// skip anything else the server pushes, e.g. user data events
+		if response.ID != id {
+			continue
+		}
+		entry := log.WithFields(log.Fields{"api": "binance-ws", "method": method, "status": response.Status, "latency_ms": time.Since(start).Milliseconds()})
+		if response.Error != nil {
+			entry.Warn("request")
+			response.Error.Status = response.Status
+			return nil, *response.Error
+		}
+		entry.Info("request")
+		return response.Result, nil
+	}
+}
+
+// wsAPISignaturePayload is what WebSocket API requests are signed over:
+// the params sorted by name, as name=value joined by &.
+func wsAPISignaturePayload(params map[string]interface{}) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%v", name, params[name])
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Logon authenticates the connection as account with session.logon. Binance
+// only accepts Ed25519 keys for it.
+func (c *WSAPIConn) Logon(ctx context.Context, account Account) error {
+	signer, err := account.Signer()
+	if err != nil {
+		return err
+	}
+	if signer.Type() != "Ed25519" {
+		return errors.New("session.logon needs an Ed25519 API key, this account's is " + signer.Type())
+	}
+	params := map[string]interface{}{"apiKey": getApiKey(account), "timestamp": serverNow(ctx).UnixMilli(), "recvWindow": config.BinanceRecvWindow.Milliseconds()}
+	signature, err := signer.Sign(wsAPISignaturePayload(params))
+	if err != nil {
+		return err
+	}
+	params["signature"] = signature
+	_, err = c.Call(ctx, "session.logon", params)
+	return err
+}
+
+// KeyCheck is what CheckAccountKey found out about the keys of an account:
+// "ok" or the error for each API.
+type KeyCheck struct {
+	Account string `json:"account"`
+	KeyType string `json:"key_type"`
+	REST    string `json:"rest"`
+	WSAPI   string `json:"ws_api"`
+}
+
+// OK tells whether every API that was tried accepted the keys.
+func (c KeyCheck) OK() bool {
+	return c.REST == "ok" && (c.WSAPI == "ok" || c.WSAPI == "")
+}
+
+// CheckAccountKey signs a REST request as account and, with an Ed25519 key,
+// logs on to the WebSocket API too. Other keys can't log on, so their WSAPI
+// stays empty.
+func CheckAccountKey(ctx context.Context, account Account) KeyCheck {
+	check := KeyCheck{Account: account.Name}
+	signer, err := account.Signer()
+	if err != nil {
+		check.REST = err.Error()
+		return check
+	}
+	check.KeyType = signer.Type()
+	check.REST = "ok"
+	if _, err := GetAccountInfoFor(ctx, account); err != nil {
+		check.REST = err.Error()
+	}
+	if check.KeyType != "Ed25519" {
+		return check
+	}
+	check.WSAPI = "ok"
+	if err := checkWSAPILogon(ctx, account); err != nil {
+		check.WSAPI = err.Error()
+	}
+	return check
+}
+
+func checkWSAPILogon(ctx context.Context, account Account) error {
+	conn, err := DialWSAPI(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.Logon(ctx, account); err != nil {
+		return err
+	}
+	_, err = conn.Call(ctx, "session.status", nil)
+	return err
+}